@@ -1,31 +1,171 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"os"
+
 	"github.com/threadedstream/wasmexperiments/internal/exec"
 )
 
+// ExecMode selects how function bodies are executed
+type ExecMode = exec.ExecMode
+
+const (
+	// ExecModeCompiled translates function bodies into flat bytecode on their first call
+	ExecModeCompiled = exec.ExecModeCompiled
+	// ExecModeInPlace interprets function bodies without translation, which makes startup cheaper
+	ExecModeInPlace = exec.ExecModeInPlace
+)
+
+var ErrInterrupted = exec.ErrInterrupted
+
+// ContextDoneError is the trap of a call whose context is done, it wraps the error of the context
+type ContextDoneError = exec.ContextDoneError
+
+// Option configures WasmApi on its creation
+type Option = exec.VMOption
+
+// WithHostModules provides imports of the module from host modules
+func WithHostModules(modules ...*HostModule) Option {
+	return exec.WithImportResolver(exec.HostModules(modules))
+}
+
+// WithHostData attaches value to the instance under key, host functions retrieve it with
+// Module.HostData. Values implementing io.Closer are closed by WasmApi.Close
+func WithHostData(key, value any) Option {
+	return exec.WithHostData(key, value)
+}
+
+// WithImportResolver provides imports of the module with resolver
+func WithImportResolver(resolver ImportResolver) Option {
+	return exec.WithImportResolver(resolver)
+}
+
 type WasmApi struct {
 	vm *exec.VM
 }
 
-func NewWasmApi(path string) (*WasmApi, error) {
+func NewWasmApi(path string, opts ...Option) (*WasmApi, error) {
 	api := new(WasmApi)
-	mod, err := exec.NewModule(path)
+	bin, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	api.vm, err = exec.NewVM(mod)
+	mod, err := exec.DecodeModule(bin, exec.ConfigOf(opts...))
+	if err != nil {
+		return nil, err
+	}
+	api.vm, err = exec.NewVM(mod, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return api, nil
 }
 
-func (api *WasmApi) Call(name string, args ...uint64) (any, error) {
-	// resolve function name
-	index, err := api.vm.QueryFunction(name)
+// Call invokes exported function name with args, which must match parameters of the function
+// in number and types. Results are typed according to the signature of the function
+func (api *WasmApi) Call(name string, args ...Value) ([]Value, error) {
+	return api.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is the same as Call, but the call is interrupted with a trap matching
+// ErrInterrupted once ctx is done
+func (api *WasmApi) CallContext(ctx context.Context, name string, args ...Value) ([]Value, error) {
+	index, sig, err := api.function(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(sig.Params) {
+		return nil, fmt.Errorf("api: %s expects %d arguments, got %d", name, len(sig.Params), len(args))
+	}
+	raw := make([]uint64, len(args))
+	for i, arg := range args {
+		if arg.typ != sig.Params[i] {
+			return nil, fmt.Errorf("api: argument %d of %s: expected %v, got %v", i, name, sig.Params[i], arg.typ)
+		}
+		raw[i] = arg.lo
+		if arg.ref != nil {
+			raw[i] = api.vm.ExternRef(arg.ref)
+		}
+	}
+
+	results, err := api.vm.ExecFuncContext(ctx, int64(index), raw...)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, len(results))
+	for i, result := range results {
+		values[i] = ValueOf(sig.Results[i], result)
+		if isReference(sig.Results[i]) {
+			values[i].ref, _ = api.vm.ExternValue(result)
+		}
+	}
+	return values, nil
+}
+
+// CallRaw invokes exported function name with arguments encoded as described by Value,
+// the returned results are only valid until the next call
+func (api *WasmApi) CallRaw(name string, args ...uint64) ([]uint64, error) {
+	return api.CallRawContext(context.Background(), name, args...)
+}
+
+// CallRawContext is the same as CallRaw, but the call is interrupted once ctx is done
+func (api *WasmApi) CallRawContext(ctx context.Context, name string, args ...uint64) ([]uint64, error) {
+	index, _, err := api.function(name)
 	if err != nil {
 		return nil, err
 	}
-	return api.vm.ExecFunc(int64(index), args...)
+	return api.vm.ExecFuncContext(ctx, int64(index), args...)
+}
+
+// Interrupt stops the call in progress, which traps with ErrInterrupted, or the next call if
+// none is in progress. It may be called from any goroutine
+func (api *WasmApi) Interrupt() {
+	api.vm.Interrupt()
+}
+
+// Close tears the instance down releasing state of host modules, e.g. open files and
+// connections of WASI. The instance must not be called afterwards
+func (api *WasmApi) Close() error {
+	return api.vm.Close()
+}
+
+func (api *WasmApi) function(name string) (uint32, *exec.FunctionSig, error) {
+	index, err := api.vm.QueryFunction(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	sig, ok := api.vm.FunctionSig(index)
+	if !ok {
+		return 0, nil, fmt.Errorf("api: export %s refers to unknown function %d", name, index)
+	}
+	for _, ts := range [][]ValueType{sig.Params, sig.Results} {
+		for _, t := range ts {
+			if t == ValueTypeV128 {
+				return 0, nil, fmt.Errorf("api: %s: v128 values are not supported yet", name)
+			}
+		}
+	}
+	return index, sig, nil
+}
+
+// SetExecMode switches execution mode for subsequent calls
+func (api *WasmApi) SetExecMode(mode ExecMode) {
+	api.vm.SetExecMode(mode)
+}
+
+// Memory returns linear memory of the instance, it's nil if the instance has none
+func (api *WasmApi) Memory() Memory {
+	return api.vm.Memory()
+}
+
+// Table returns table exported under name
+func (api *WasmApi) Table(name string) (*TableInstance, bool) {
+	return api.vm.ExportedTable(name)
+}
+
+// Function returns reference to function exported under name, it can be stored in tables
+func (api *WasmApi) Function(name string) (*FuncRef, bool) {
+	return api.vm.ExportedFunction(name)
 }
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+// RuntimeConfig limits resources available to instances and selects enabled proposals
+type RuntimeConfig = exec.RuntimeConfig
+
+// Features is a set of WebAssembly proposals a module may rely on
+type Features = exec.Features
+
+// DisabledFeatureError is returned when a module relies on proposals disabled by WithFeatures
+type DisabledFeatureError = exec.DisabledFeatureError
+
+const (
+	FeatureMultiValue     = exec.FeatureMultiValue
+	FeatureBulkMemory     = exec.FeatureBulkMemory
+	FeatureReferenceTypes = exec.FeatureReferenceTypes
+	FeatureSIMD           = exec.FeatureSIMD
+	FeatureThreads        = exec.FeatureThreads
+	FeatureTailCalls      = exec.FeatureTailCalls
+	// SupportedFeatures are the proposals implemented by the interpreter, all of them are enabled by default
+	SupportedFeatures = exec.SupportedFeatures
+)
+
+// LimitError is returned on decoding and instantiation of a module exceeding limits
+type LimitError = exec.LimitError
+
+var ErrTooManyInstances = exec.ErrTooManyInstances
+
+// DefaultRuntimeConfig enables all supported features with the default limits
+func DefaultRuntimeConfig() RuntimeConfig {
+	return exec.DefaultRuntimeConfig()
+}
+
+// WithRuntimeConfig replaces the whole configuration, options following it adjust the config
+func WithRuntimeConfig(config RuntimeConfig) Option {
+	return exec.WithRuntimeConfig(config)
+}
+
+// WithMaxStackSize limits the operand stack, in values. Exceeding it traps
+func WithMaxStackSize(size int) Option {
+	return exec.WithMaxStackSize(size)
+}
+
+// WithMaxCallDepth limits the number of nested calls. Exceeding it traps
+func WithMaxCallDepth(depth int) Option {
+	return exec.WithMaxCallDepth(depth)
+}
+
+// WithMaxMemoryPages limits size of memory, memory.grow beyond it fails and modules
+// declaring larger memory fail to instantiate. It's 16384 pages, 1GiB, by default and
+// 65536 pages at most
+func WithMaxMemoryPages(pages uint32) Option {
+	return exec.WithMaxMemoryPages(pages)
+}
+
+// WithMaxTableSize limits size of tables declared or imported by modules, table.grow
+// beyond it fails
+func WithMaxTableSize(size uint32) Option {
+	return exec.WithMaxTableSize(size)
+}
+
+// WithMaxInstances limits the number of modules instantiated by a Runtime
+func WithMaxInstances(n int) Option {
+	return exec.WithMaxInstances(n)
+}
+
+// WithMaxFunctions limits the number of functions declared by modules, imported ones included
+func WithMaxFunctions(n uint32) Option {
+	return exec.WithMaxFunctions(n)
+}
+
+// WithMaxLocals limits the number of locals of a function, parameters included
+func WithMaxLocals(n uint32) Option {
+	return exec.WithMaxLocals(n)
+}
+
+// WithMaxFunctionSize limits size of function bodies in bytes
+func WithMaxFunctionSize(size uint32) Option {
+	return exec.WithMaxFunctionSize(size)
+}
+
+// WithMaxDataSize limits total size of data segments of modules in bytes
+func WithMaxDataSize(size uint32) Option {
+	return exec.WithMaxDataSize(size)
+}
+
+// WithFeatures sets enabled proposals, modules relying on others fail to instantiate
+// or to call functions using them
+func WithFeatures(features Features) Option {
+	return exec.WithFeatures(features)
+}
+
+// Config returns configuration of the instance
+func (api *WasmApi) Config() RuntimeConfig {
+	return api.vm.Config()
+}
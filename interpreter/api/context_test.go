@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+type ctxKey struct{}
+
+// spinModule imports env.check, its function "spin" loops forever and "check" calls env.check
+func spinModule() []byte {
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, nil)},
+		Imports: [][]byte{ImportFunc("env", "check", 0)},
+		Funcs: []Func{
+			{Type: 0, Code: B(0x03, 0x40, 0x0c, 0, 0x0b)},
+			{Type: 0, Code: B(0x10, 0)},
+		},
+		Exports: [][]byte{ExportFunc("spin", 1), ExportFunc("check", 2)},
+	}
+	return m.Bytes()
+}
+
+func TestCallContext(t *testing.T) {
+	var got any
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) { got = ctx.Value(ctxKey{}) }).Export("check").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := newWasmApi(t, spinModule(), api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// host functions receive the context of the call
+	if _, err := inst.CallContext(context.WithValue(context.Background(), ctxKey{}, "value"), "check"); err != nil || got != "value" {
+		t.Fatalf("check = %v, host function got %v", err, got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var done api.ContextDoneError
+	if _, err := inst.CallContext(ctx, "spin"); !errors.As(err, &done) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("spin with canceled context: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := inst.CallRawContext(ctx, "spin"); !errors.Is(err, api.ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("spin past deadline: %v", err)
+	}
+
+	spin, err := api.Export[func(context.Context) error](inst, "spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := spin(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("bound spin past deadline: %v", err)
+	}
+
+	// Interrupt issued before the call stops it
+	inst.Interrupt()
+	if _, err := inst.Call("spin"); !errors.Is(err, api.ErrInterrupted) {
+		t.Fatalf("spin after Interrupt: %v", err)
+	}
+	if _, err := inst.Call("check"); err != nil {
+		t.Fatalf("check after interrupted call: %v", err)
+	}
+}
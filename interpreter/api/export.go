@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Export binds exported function name of inst to a Go function of type F. Parameters of F
+// and its results but the last one, which must be error, are of types int32, uint32, int64,
+// uint64, float32, float64 or any for externref and have to match the signature of the wasm
+// function. For example
+//
+//	add, err := api.Export[func(int32, int32) (int32, error)](inst, "add")
+//
+// F may take context.Context as its first parameter, the call is interrupted once it's done as
+// with CallContext. The export is resolved and checked once, so calling the returned function is cheap
+func Export[F any](inst *WasmApi, name string) (F, error) {
+	var zero F
+	t := reflect.TypeOf((*F)(nil)).Elem()
+	if t.Kind() != reflect.Func || t.IsVariadic() || t.NumOut() == 0 || t.Out(t.NumOut()-1) != errorType {
+		return zero, fmt.Errorf("api: expected non-variadic func returning error as its last result, got %v", t)
+	}
+	index, sig, err := inst.function(name)
+	if err != nil {
+		return zero, err
+	}
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first++
+	}
+	if t.NumIn()-first != len(sig.Params) || t.NumOut()-1 != len(sig.Results) {
+		return zero, fmt.Errorf("api: %v doesn't match %s %v -> %v", t, name, sig.Params, sig.Results)
+	}
+	for i := first; i < t.NumIn(); i++ {
+		if vt, ok := valueTypeOf(t.In(i)); !ok || vt != sig.Params[i-first] {
+			return zero, fmt.Errorf("api: parameter %d of %v doesn't match %v of %s", i, t, sig.Params[i-first], name)
+		}
+	}
+	for i := 0; i < t.NumOut()-1; i++ {
+		if vt, ok := valueTypeOf(t.Out(i)); !ok || vt != sig.Results[i] {
+			return zero, fmt.Errorf("api: result %d of %v doesn't match %v of %s", i, t, sig.Results[i], name)
+		}
+	}
+
+	vm, idx := inst.vm, int64(index)
+	var fn any
+	switch any(zero).(type) {
+	case func() error:
+		fn = func() error {
+			_, err := vm.ExecFunc(idx)
+			return err
+		}
+	case func() (int32, error):
+		fn = func() (int32, error) {
+			res, err := vm.ExecFunc(idx)
+			if err != nil {
+				return 0, err
+			}
+			return DecodeI32(res[0]), nil
+		}
+	case func(int32) error:
+		fn = func(a int32) error {
+			_, err := vm.ExecFunc(idx, EncodeI32(a))
+			return err
+		}
+	case func(int32) (int32, error):
+		fn = func(a int32) (int32, error) {
+			res, err := vm.ExecFunc(idx, EncodeI32(a))
+			if err != nil {
+				return 0, err
+			}
+			return DecodeI32(res[0]), nil
+		}
+	case func(int32, int32) (int32, error):
+		fn = func(a, b int32) (int32, error) {
+			res, err := vm.ExecFunc(idx, EncodeI32(a), EncodeI32(b))
+			if err != nil {
+				return 0, err
+			}
+			return DecodeI32(res[0]), nil
+		}
+	case func(uint32, uint32) (uint32, error):
+		fn = func(a, b uint32) (uint32, error) {
+			res, err := vm.ExecFunc(idx, EncodeU32(a), EncodeU32(b))
+			if err != nil {
+				return 0, err
+			}
+			return DecodeU32(res[0]), nil
+		}
+	case func(int64, int64) (int64, error):
+		fn = func(a, b int64) (int64, error) {
+			res, err := vm.ExecFunc(idx, EncodeI64(a), EncodeI64(b))
+			if err != nil {
+				return 0, err
+			}
+			return DecodeI64(res[0]), nil
+		}
+	case func(float64, float64) (float64, error):
+		fn = func(a, b float64) (float64, error) {
+			res, err := vm.ExecFunc(idx, EncodeF64(a), EncodeF64(b))
+			if err != nil {
+				return 0, err
+			}
+			return DecodeF64(res[0]), nil
+		}
+	default:
+		fn = reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+			ctx := context.Background()
+			if first > 0 && !in[0].IsNil() {
+				ctx = in[0].Interface().(context.Context)
+			}
+			args := make([]uint64, len(in)-first)
+			for i, arg := range in[first:] {
+				args[i] = encodeValue(vm, arg)
+			}
+			out := make([]reflect.Value, t.NumOut())
+			res, err := vm.ExecFuncContext(ctx, idx, args...)
+			for i := range out[:len(out)-1] {
+				if err != nil {
+					out[i] = reflect.Zero(t.Out(i))
+				} else {
+					out[i] = decodeValue(vm, t.Out(i), res[i])
+				}
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}).Interface()
+	}
+	return fn.(F), nil
+}
@@ -0,0 +1,113 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// arithModule exports "add" of i32, "sub64" of i64, "split" returning its f64 argument
+// truncated to i64 along with the sum of its i32 and f32 ones as f32, and "trap"
+func arithModule() []byte {
+	m := &Module{
+		Types: [][]byte{
+			FuncType(B(I32, I32), B(I32)),
+			FuncType(B(I64, I64), B(I64)),
+			FuncType(B(I32, F32, F64), B(I64, F32)),
+			FuncType(nil, nil),
+		},
+		Funcs: []Func{
+			{Type: 0, Code: B(0x20, 0, 0x20, 1, 0x6a)},
+			{Type: 1, Code: B(0x20, 0, 0x20, 1, 0x7d)},
+			{Type: 2, Code: Cat(B(0x20, 2, 0xb0), B(0x20, 0, 0xb2), B(0x20, 1, 0x92))}, // i64.trunc_f64_s; f32.convert_i32_s; f32.add
+			{Type: 3, Code: B(0x00)},
+		},
+		Exports: [][]byte{ExportFunc("add", 0), ExportFunc("sub64", 1), ExportFunc("split", 2), ExportFunc("trap", 3)},
+	}
+	return m.Bytes()
+}
+
+func TestExport(t *testing.T) {
+	inst, err := newWasmApi(t, arithModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shapes with fast paths
+	add, err := api.Export[func(int32, int32) (int32, error)](inst, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := add(-2, 5); err != nil || r != 3 {
+		t.Errorf("add(-2, 5) = %v, %v", r, err)
+	}
+	addu, err := api.Export[func(uint32, uint32) (uint32, error)](inst, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := addu(1<<31, 1<<31+1); err != nil || r != 1 {
+		t.Errorf("unsigned add(2^31, 2^31+1) = %v, %v", r, err)
+	}
+	sub64, err := api.Export[func(int64, int64) (int64, error)](inst, "sub64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := sub64(1, 1<<40); err != nil || r != 1-1<<40 {
+		t.Errorf("sub64(1, 2^40) = %v, %v", r, err)
+	}
+	trap, err := api.Export[func() error](inst, "trap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trap(); !errors.Is(err, exec.ErrUnreachable) {
+		t.Errorf("trap: %v", err)
+	}
+
+	// and the others made by reflection
+	split, err := api.Export[func(int32, float32, float64) (int64, float32, error)](inst, "split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, f, err := split(-3, 0.5, -7.9); err != nil || i != -7 || f != -2.5 {
+		t.Errorf("split(-3, 0.5, -7.9) = %v, %v, %v", i, f, err)
+	}
+	unsigned, err := api.Export[func(uint64, uint64) (uint64, error)](inst, "sub64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := unsigned(0, 1); err != nil || r != 1<<64-1 {
+		t.Errorf("unsigned sub64(0, 1) = %v, %v", r, err)
+	}
+}
+
+func TestExportChecksSignature(t *testing.T) {
+	inst, err := newWasmApi(t, arithModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, err error) {
+		t.Helper()
+		if err == nil {
+			t.Errorf("%s bound", name)
+		}
+	}
+	_, err = api.Export[func(int32, int32) int32](inst, "add")
+	check("add without error", err)
+	_, err = api.Export[func(int32) (int32, error)](inst, "add")
+	check("add of one parameter", err)
+	_, err = api.Export[func(int64, int64) (int64, error)](inst, "add")
+	check("add of i64", err)
+	_, err = api.Export[func(int32, int32) (float32, error)](inst, "add")
+	check("add of f32 result", err)
+	_, err = api.Export[func(int32, float32, float64) (int64, error)](inst, "split")
+	check("split of one result", err)
+	_, err = api.Export[func(string) error](inst, "trap")
+	check("trap of string parameter", err)
+	_, err = api.Export[func() error](inst, "missing")
+	check("missing function", err)
+	_, err = api.Export[int](inst, "trap")
+	check("int", err)
+}
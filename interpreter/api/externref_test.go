@@ -0,0 +1,114 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// externModule imports function env.pass of type (externref) -> externref and immutable
+// externref global env.ref. Its function "id" returns pass(x), "get" returns the global
+// and "spin" reads the global x times dropping the value
+func externModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(B(ExternRef), B(ExternRef)), FuncType(nil, B(ExternRef)), FuncType(B(I32), nil)},
+		Imports: [][]byte{
+			ImportFunc("env", "pass", 0),
+			ImportGlobal("env", "ref", ExternRef, false),
+		},
+		Funcs: []Func{
+			{Type: 0, Code: B(0x20, 0, 0x10, 0)},
+			{Type: 1, Code: B(0x23, 0)},
+			{Type: 2, Code: B(
+				0x02, 0x40, 0x03, 0x40, // block loop
+				0x20, 0, 0x45, 0x0d, 1, // br_if 1 (x == 0)
+				0x23, 0, 0x1a, // global.get 0; drop
+				0x20, 0, 0x41, 1, 0x6b, 0x21, 0, // x -= 1
+				0x0c, 0, 0x0b, 0x0b, // br 0; end; end
+			)},
+		},
+		Exports: [][]byte{ExportFunc("id", 1), ExportFunc("get", 2), ExportFunc("spin", 3)},
+	}
+	return m.Bytes()
+}
+
+func externEnv(t *testing.T, ref any, passed *[]any) *api.HostModule {
+	t.Helper()
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(v any) any {
+		*passed = append(*passed, v)
+		return v
+	}).Export("pass").
+		ExportGlobal("ref", api.NewGlobal(api.ValueExtern(ref), false)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestExternRef(t *testing.T) {
+	type object struct{ name string }
+	obj := &object{"obj"}
+	var passed []any
+	inst, err := newWasmApi(t, externModule(), api.WithHostModules(externEnv(t, obj, &passed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &object{"other"}
+	if r, err := inst.Call("id", api.ValueExtern(other)); err != nil || r[0].Extern() != other {
+		t.Fatalf("id(other) = %v, %v", r, err)
+	}
+	if len(passed) != 1 || passed[0] != other {
+		t.Errorf("host function received %v", passed)
+	}
+	if r, err := inst.Call("id", api.ValueExtern(nil)); err != nil || !r[0].IsNull() {
+		t.Errorf("id(null) = %v, %v", r, err)
+	}
+	if r, err := inst.Call("get"); err != nil || r[0].Type() != api.ValueTypeExternRef || r[0].Extern() != obj {
+		t.Errorf("get() = %v, %v", r, err)
+	}
+
+	id, err := api.Export[func(any) (any, error)](inst, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := id("value"); err != nil || v != "value" {
+		t.Errorf("id(value) = %v, %v", v, err)
+	}
+	if _, err := api.Export[func(int32) (any, error)](inst, "id"); err == nil {
+		t.Error("i32 parameter bound to externref")
+	}
+}
+
+// Reading the same host value over and over must not use up handles of the instance
+func TestExternRefHandlesReused(t *testing.T) {
+	for _, mode := range []api.ExecMode{api.ExecModeCompiled, api.ExecModeInPlace} {
+		var passed []any
+		inst, err := newWasmApi(t, externModule(), api.WithHostModules(externEnv(t, new(int), &passed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.SetExecMode(mode)
+		if _, err := inst.Call("spin", api.ValueI32(2_000_000)); err != nil {
+			t.Fatalf("mode %v: spin: %v", mode, err)
+		}
+
+		// values which can't be told apart get a handle each time, but their number is bounded
+		inst, err = newWasmApi(t, externModule(), api.WithHostModules(externEnv(t, []int{1}, &passed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.SetExecMode(mode)
+		if _, err := inst.Call("spin", api.ValueI32(2_000_000)); !errors.Is(err, exec.ErrExternRefsExhausted) {
+			t.Fatalf("mode %v: spin over slice: %v", mode, err)
+		}
+		if r, err := inst.Call("get"); err != nil || r[0].Extern().([]int)[0] != 1 {
+			t.Fatalf("mode %v: get() after trap = %v, %v", mode, r, err)
+		}
+	}
+}
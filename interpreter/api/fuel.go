@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+var ErrOutOfFuel = exec.ErrOutOfFuel
+
+// CostTable holds fuel consumed by instructions indexed by their opcodes, memory.grow is
+// charged once more per page grown and bulk memory and table instructions per 64 bytes
+// or elements they process
+type CostTable = exec.CostTable
+
+// DefaultCostTable charges 1 per instruction, calls, memory growth and bulk instructions
+// are heavier
+func DefaultCostTable() *CostTable {
+	return exec.DefaultCostTable()
+}
+
+// WithFuel limits execution to fuel, which instructions consume according to costs
+// or DefaultCostTable if it's nil. Exhausting fuel traps with ErrOutOfFuel
+func WithFuel(fuel uint64, costs *CostTable) Option {
+	return exec.WithFuel(fuel, costs)
+}
+
+// WithFuelRefill makes refill called once fuel is exhausted, execution resumes with
+// the fuel it returns or traps with ErrOutOfFuel if it returns zero
+func WithFuelRefill(refill func() uint64) Option {
+	return exec.WithFuelRefill(func(*exec.VM) uint64 {
+		return refill()
+	})
+}
+
+// AddFuel increases remaining fuel by n, e.g. to call the instance again after ErrOutOfFuel
+func (api *WasmApi) AddFuel(n uint64) {
+	api.vm.AddFuel(n)
+}
+
+// Fuel returns remaining fuel
+func (api *WasmApi) Fuel() uint64 {
+	return api.vm.Fuel()
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+var ErrImmutableGlobal = exec.ErrImmutableGlobal
+
+// NewGlobal creates a host global initialized with v, other modules can import it
+// once it's exported by a host module, see HostModuleBuilder.ExportGlobal
+func NewGlobal(v Value, mutable bool) *GlobalInstance {
+	g := exec.NewGlobalInstance(v.Type(), mutable, v.Raw())
+	if isReference(v.typ) {
+		g.Value, g.Ref = 0, v.ref
+	}
+	return g
+}
+
+// Global returns global exported under name, it may be exported by a host module to share it
+func (api *WasmApi) Global(name string) (*GlobalInstance, bool) {
+	return api.vm.ExportedGlobal(name)
+}
+
+// GetGlobal returns value of global exported under name
+func (api *WasmApi) GetGlobal(name string) (Value, error) {
+	g, ok := api.vm.ExportedGlobal(name)
+	if !ok {
+		return Value{}, fmt.Errorf("api: no global exported under name '%s'", name)
+	}
+	if isReference(g.Type) {
+		return Value{typ: g.Type, ref: g.Ref}, nil
+	}
+	return ValueOf(g.Type, g.Value), nil
+}
+
+// SetGlobal assigns v to global exported under name, the global has to be mutable
+// and of the same type as v
+func (api *WasmApi) SetGlobal(name string, v Value) error {
+	g, ok := api.vm.ExportedGlobal(name)
+	if !ok {
+		return fmt.Errorf("api: no global exported under name '%s'", name)
+	}
+	if err := g.Set(v.Type(), v.Raw()); err != nil {
+		return fmt.Errorf("api: %s: %w", name, err)
+	}
+	if isReference(v.typ) {
+		g.Value, g.Ref = 0, v.ref
+		if g.Ref == nil && v.lo != 0 {
+			g.Ref, _ = api.vm.ExternValue(v.lo)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// globalsModule exports mutable i64 global "counter" incremented by function "inc" and
+// immutable i32 global "limit"
+func globalsModule() []byte {
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, B(I64))},
+		Globals: [][]byte{Global(I64, true, I64Const(5)), Global(I32, false, I32Const(10))},
+		Funcs: []Func{{Type: 0, Code: Cat(
+			B(0x23, 0), I64Const(1), B(0x7c), B(0x24, 0), B(0x23, 0), // counter += 1
+		)}},
+		Exports: [][]byte{
+			ExportFunc("inc", 0),
+			ExportGlobal("counter", 0),
+			ExportGlobal("limit", 1),
+		},
+	}
+	return m.Bytes()
+}
+
+func TestExportedGlobals(t *testing.T) {
+	inst, err := newWasmApi(t, globalsModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := inst.GetGlobal("limit"); err != nil || v.Type() != api.ValueTypeI32 || v.I32() != 10 {
+		t.Fatalf("limit = %v, %v", v, err)
+	}
+	if _, err := inst.CallRaw("inc"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := inst.GetGlobal("counter"); err != nil || v.I64() != 6 {
+		t.Fatalf("counter after inc = %v, %v", v, err)
+	}
+	if err := inst.SetGlobal("counter", api.ValueI64(-10)); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := inst.Call("inc"); err != nil || r[0].I64() != -9 {
+		t.Fatalf("inc after SetGlobal = %v, %v", r, err)
+	}
+
+	if err := inst.SetGlobal("limit", api.ValueI32(1)); !errors.Is(err, api.ErrImmutableGlobal) {
+		t.Errorf("assignment to immutable global: %v", err)
+	}
+	if err := inst.SetGlobal("counter", api.ValueI32(1)); err == nil {
+		t.Error("i32 assigned to i64 global")
+	}
+	if _, err := inst.GetGlobal("inc"); err == nil {
+		t.Error("function got as global")
+	}
+	if _, ok := inst.Global("missing"); ok {
+		t.Error("missing global found")
+	}
+}
+
+func TestSharedGlobals(t *testing.T) {
+	// the global of an instance exported by a host module is shared with the importer
+	owner, err := newWasmApi(t, globalsModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, ok := owner.Global("counter")
+	if !ok {
+		t.Fatal("counter isn't exported")
+	}
+	bump := api.NewGlobal(api.ValueI32(0), true)
+	env, err := api.NewHostModuleBuilder("env").ExportGlobal("counter", counter).ExportGlobal("bump", bump).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, nil)},
+		Imports: [][]byte{ImportGlobal("env", "counter", I64, true), ImportGlobal("env", "bump", I32, true)},
+		Funcs: []Func{{Type: 0, Code: Cat(
+			B(0x23, 0), I64Const(100), B(0x7c), B(0x24, 0), // counter += 100
+			B(0x23, 1), I32Const(1), B(0x6a), B(0x24, 1), // bump += 1
+		)}},
+		Exports: [][]byte{ExportFunc("run", 0)},
+	}
+	importer, err := newWasmApi(t, m.Bytes(), api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.CallRaw("run"); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := owner.Call("inc"); err != nil || r[0].I64() != 106 {
+		t.Fatalf("inc after run = %v, %v", r, err)
+	}
+	if bump.Value != 1 {
+		t.Fatalf("host global is %d after run", bump.Value)
+	}
+}
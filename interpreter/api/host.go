@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// Module is the instance calling a host function
+type Module interface {
+	// Memory returns linear memory of the instance, it's nil if the instance has none
+	Memory() Memory
+	// ExternRef returns handle of host value v to be returned to the guest as externref
+	ExternRef(v any) uint64
+	// ExternValue returns host value referred to by externref handle received from the guest
+	ExternValue(handle uint64) (any, bool)
+	// ExportedFunction returns function exported by the instance under name, host functions may
+	// call back into the instance with it
+	ExportedFunction(name string) (*FuncRef, bool)
+	// HostData returns value attached to the instance under key with WithHostData or SetHostData
+	HostData(key any) any
+	// SetHostData attaches value to the instance under key, e.g. state of a host module
+	// kept for each instance importing it, it is closed by WasmApi.Close if it implements io.Closer
+	SetHostData(key, value any)
+}
+
+// GoModuleFunction implements a host function. Arguments are passed in stack and results
+// are written back to it starting at index 0
+type GoModuleFunction func(ctx context.Context, mod Module, stack []uint64)
+
+type ValueType = types.ValueType
+
+const (
+	ValueTypeI32 ValueType = types.ValueTypeI32
+	ValueTypeI64 ValueType = types.ValueTypeI64
+	ValueTypeF32 ValueType = types.ValueTypeF32
+	ValueTypeF64 ValueType = types.ValueTypeF64
+)
+
+type (
+	Memory         = exec.Memory
+	HostModule     = exec.HostModule
+	ImportResolver = exec.ImportResolver
+	MemoryInstance = exec.MemoryInstance
+	TableInstance  = exec.TableInstance
+	FuncRef        = exec.FuncRef
+	GlobalInstance = exec.GlobalInstance
+)
+
+// NewMemoryInstance creates memory of min pages, which may grow up to max pages unless max is nil
+func NewMemoryInstance(min uint32, max *uint32) *MemoryInstance {
+	return exec.NewMemoryInstance(min, max)
+}
+
+// NewTableInstance creates table of min null references of type typ, which is either ValueTypeFuncRef
+// or ValueTypeExternRef. The table may grow up to max elements unless max is nil
+func NewTableInstance(typ ValueType, min uint32, max *uint32) *TableInstance {
+	return exec.NewTableInstance(exec.ElementType(typ), min, max)
+}
+
+// NewGlobalInstance creates global holding value, which is stored the same way as arguments of Call
+func NewGlobalInstance(typ ValueType, mutable bool, value uint64) *GlobalInstance {
+	return exec.NewGlobalInstance(typ, mutable, value)
+}
+
+// HostModuleBuilder defines a module of host functions, which can be imported by wasm modules
+type HostModuleBuilder struct {
+	module *HostModule
+	err    error
+}
+
+func NewHostModuleBuilder(name string) *HostModuleBuilder {
+	return &HostModuleBuilder{module: exec.NewHostModule(name)}
+}
+
+// NewFunctionBuilder starts definition of a function to be exported by the module
+func (b *HostModuleBuilder) NewFunctionBuilder() *HostFunctionBuilder {
+	return &HostFunctionBuilder{b: b}
+}
+
+// ExportMemory adds mem to the module under name
+func (b *HostModuleBuilder) ExportMemory(name string, mem *MemoryInstance) *HostModuleBuilder {
+	b.module.AddMemory(name, mem)
+	return b
+}
+
+// ExportTable adds table to the module under name
+func (b *HostModuleBuilder) ExportTable(name string, table *TableInstance) *HostModuleBuilder {
+	b.module.AddTable(name, table)
+	return b
+}
+
+// ExportGlobal adds global to the module under name
+func (b *HostModuleBuilder) ExportGlobal(name string, global *GlobalInstance) *HostModuleBuilder {
+	b.module.AddGlobal(name, global)
+	return b
+}
+
+// Build returns the module, or the first error encountered while defining its functions
+func (b *HostModuleBuilder) Build() (*HostModule, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.module, nil
+}
+
+// Instantiate builds the module and adds it to r
+func (b *HostModuleBuilder) Instantiate(r *Runtime) (*HostModule, error) {
+	hm, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.AddHostModule(hm); err != nil {
+		return nil, err
+	}
+	return hm, nil
+}
+
+type HostFunctionBuilder struct {
+	b  *HostModuleBuilder
+	fn *exec.HostFunction
+}
+
+// WithGoModuleFunction sets implementation of the function and its type
+func (fb *HostFunctionBuilder) WithGoModuleFunction(fn GoModuleFunction, params, results []ValueType) *HostFunctionBuilder {
+	sig := &exec.FunctionSig{Params: params, Results: results}
+	fb.fn = &exec.HostFunction{
+		Sig: sig,
+		Fn: func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			fn(ctx, vm, stack)
+		},
+	}
+	return fb
+}
+
+// Export adds the function to the module under name
+func (fb *HostFunctionBuilder) Export(name string) *HostModuleBuilder {
+	if fb.fn == nil {
+		fb.b.setErr(fmt.Errorf("api: host function %s.%s has no implementation", fb.b.module.Name(), name))
+		return fb.b
+	}
+	fb.b.module.AddFunction(name, fb.fn)
+	return fb.b
+}
+
+func (b *HostModuleBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
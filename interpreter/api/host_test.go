@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// newWasmApi instantiates module bin with opts
+func newWasmApi(t *testing.T, bin []byte, opts ...api.Option) (*api.WasmApi, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "module.wasm")
+	if err := os.WriteFile(path, bin, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return api.NewWasmApi(path, opts...)
+}
+
+// importingModule imports env.add of type (i32, i32) -> i32 and env.store of type (i32) -> (),
+// its function "run" stores add(add(x, y), y) and returns it
+func importingModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32, I32), B(I32)), FuncType(B(I32), nil)},
+		Imports: [][]byte{
+			ImportFunc("env", "add", 0),
+			ImportFunc("env", "store", 1),
+		},
+		Funcs: []Func{{Type: 0, Code: Cat(
+			B(0x20, 0), B(0x20, 1), B(0x10, 0), B(0x20, 1), B(0x10, 0), // add(add(x, y), y)
+			B(0x22, 0), B(0x10, 1), B(0x20, 0), // local.tee 0; call store; local.get 0
+		)}},
+		Mems:    [][]byte{Memory(1, -1)},
+		Exports: [][]byte{ExportFunc("run", 2)},
+	}
+	return m.Bytes()
+}
+
+func envModule(t *testing.T, calls *int) *api.HostModule {
+	t.Helper()
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithGoModuleFunction(func(_ context.Context, _ api.Module, stack []uint64) {
+		*calls++
+		stack[0] = uint64(uint32(stack[0]) + uint32(stack[1]))
+	}, []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}).Export("add").
+		NewFunctionBuilder().WithGoModuleFunction(func(_ context.Context, mod api.Module, stack []uint64) {
+		mod.Memory().WriteUint8(0, byte(stack[0]))
+	}, []api.ValueType{api.ValueTypeI32}, nil).Export("store").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestHostFunctions(t *testing.T) {
+	calls := 0
+	for _, mode := range []api.ExecMode{api.ExecModeCompiled, api.ExecModeInPlace} {
+		inst, err := newWasmApi(t, importingModule(), api.WithHostModules(envModule(t, &calls)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.SetExecMode(mode)
+		if r, err := inst.CallRaw("run", 3, 4); err != nil || r[0] != 11 {
+			t.Fatalf("run(3, 4) = %v, %v", r, err)
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("add called %d times, want 4", calls)
+	}
+}
+
+func TestUnresolvedImports(t *testing.T) {
+	var unresolved exec.UnresolvedImportError
+	if _, err := newWasmApi(t, importingModule()); !errors.As(err, &unresolved) || unresolved.Name != "add" {
+		t.Fatalf("instantiation without imports: %v", err)
+	}
+
+	// store has a result it's not imported with
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithGoModuleFunction(func(context.Context, api.Module, []uint64) {},
+		[]api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}).Export("add").
+		NewFunctionBuilder().WithGoModuleFunction(func(context.Context, api.Module, []uint64) {},
+		[]api.ValueType{api.ValueTypeI32}, []api.ValueType{api.ValueTypeI32}).Export("store").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatch exec.ImportTypeMismatchError
+	if _, err := newWasmApi(t, importingModule(), api.WithHostModules(env)); !errors.As(err, &mismatch) || mismatch.Name != "store" {
+		t.Fatalf("instantiation with mismatching import: %v", err)
+	}
+
+	if _, err := api.NewHostModuleBuilder("env").NewFunctionBuilder().Export("add").Build(); err == nil {
+		t.Fatal("function without implementation exported")
+	}
+}
+
+// hostFunc is a function imported by the module of forwarders
+type hostFunc struct {
+	name            string
+	params, results []byte
+}
+
+// forwarders assembles a module importing fns from module, it exports a function
+// passing its arguments to each of them under the same name
+func forwarders(module string, fns ...hostFunc) []byte {
+	m := &Module{Mems: [][]byte{Memory(1, -1)}}
+	for i, fn := range fns {
+		var code []byte
+		for j := range fn.params {
+			code = append(code, 0x20, byte(j))
+		}
+		m.Types = append(m.Types, FuncType(fn.params, fn.results))
+		m.Imports = append(m.Imports, ImportFunc(module, fn.name, uint32(i)))
+		m.Funcs = append(m.Funcs, Func{Type: uint32(i), Code: Cat(code, B(0x10), U(uint64(i)))})
+		m.Exports = append(m.Exports, ExportFunc(fn.name, uint32(len(fns)+i)))
+	}
+	return m.Bytes()
+}
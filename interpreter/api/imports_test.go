@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// externalsModule imports memory, table and global base of env. It places "hi" at 16 and
+// itself into table element 1, its function "load" returns the word at base + x and "store"
+// stores y there
+func externalsModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32), B(I32)), FuncType(B(I32, I32), nil)},
+		Imports: [][]byte{
+			ImportMemory("env", "memory", 1),
+			ImportTable("env", "table", FuncRef, 2),
+			ImportGlobal("env", "base", I32, false),
+		},
+		Funcs: []Func{
+			{Type: 0, Code: Cat(B(0x23, 0), B(0x20, 0), B(0x6a), B(0x28, 2, 0))},
+			{Type: 1, Code: Cat(B(0x23, 0), B(0x20, 0), B(0x6a), B(0x20, 1), B(0x36, 2, 0))},
+		},
+		Exports: [][]byte{ExportFunc("load", 0), ExportFunc("store", 1)},
+		Elems:   [][]byte{Elem(1, 0)},
+		Datas:   [][]byte{Data(16, []byte("hi"))},
+	}
+	return m.Bytes()
+}
+
+func hostExternals(t *testing.T, mem *api.MemoryInstance, table *api.TableInstance, base uint64) *api.HostModule {
+	t.Helper()
+	env, err := api.NewHostModuleBuilder("env").
+		ExportMemory("memory", mem).
+		ExportTable("table", table).
+		ExportGlobal("base", api.NewGlobalInstance(api.ValueTypeI32, false, base)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestImportedExternals(t *testing.T) {
+	mem := api.NewMemoryInstance(1, nil)
+	table := api.NewTableInstance(api.ValueTypeFuncRef, 2, nil)
+	writer, err := newWasmApi(t, externalsModule(), api.WithHostModules(hostExternals(t, mem, table, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := newWasmApi(t, externalsModule(), api.WithHostModules(hostExternals(t, mem, table, 96)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mem.Data[16:18]) != "hi" || table.Elements[0] != nil || table.Elements[1] == nil {
+		t.Fatalf("segments aren't applied to imports: memory %q, table %v", mem.Data[16:18], table.Elements)
+	}
+
+	// instances share memory
+	if _, err := writer.CallRaw("store", 4, 0xc0ffee); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := reader.CallRaw("load", 8); err != nil || r[0] != 0xc0ffee {
+		t.Fatalf("load(8) = %v, %v", r, err)
+	}
+}
+
+func TestIncompatibleImports(t *testing.T) {
+	var incompatible exec.IncompatibleImportError
+	_, err := newWasmApi(t, externalsModule(), api.WithHostModules(hostExternals(t, api.NewMemoryInstance(1, nil), api.NewTableInstance(api.ValueTypeFuncRef, 1, nil), 0)))
+	if !errors.As(err, &incompatible) || incompatible.Name != "table" {
+		t.Fatalf("instantiation with a table too small: %v", err)
+	}
+
+	env, err := api.NewHostModuleBuilder("env").
+		ExportMemory("memory", api.NewMemoryInstance(1, nil)).
+		ExportTable("table", api.NewTableInstance(api.ValueTypeFuncRef, 2, nil)).
+		ExportGlobal("base", api.NewGlobalInstance(api.ValueTypeI64, false, 0)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWasmApi(t, externalsModule(), api.WithHostModules(env)); !errors.As(err, &incompatible) || incompatible.Name != "base" {
+		t.Fatalf("instantiation with a global of another type: %v", err)
+	}
+}
+
+func TestSegmentsCheckedBeforeApplied(t *testing.T) {
+	mem := api.NewMemoryInstance(1, nil)
+	table := api.NewTableInstance(api.ValueTypeFuncRef, 2, nil)
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, nil)},
+		Imports: [][]byte{ImportMemory("env", "memory", 1), ImportTable("env", "table", FuncRef, 2)},
+		Funcs:   []Func{{Type: 0}},
+		Elems:   [][]byte{Elem(0, 0)},
+		Datas:   [][]byte{Data(0, []byte("applied")), Data(65535, []byte("past the end"))},
+	}
+	env, err := api.NewHostModuleBuilder("env").ExportMemory("memory", mem).ExportTable("table", table).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWasmApi(t, m.Bytes(), api.WithHostModules(env)); !errors.Is(err, exec.ErrDataSegmentDoesNotFit) {
+		t.Fatalf("instantiation with a data segment past memory: %v", err)
+	}
+	if mem.Data[0] != 0 || table.Elements[0] != nil {
+		t.Fatal("segments applied by failed instantiation")
+	}
+}
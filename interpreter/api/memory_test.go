@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// memoryModule exports memory of 1 page growing up to 2 pages, "load" returns the word at x
+func memoryModule() []byte {
+	m := &Module{
+		Types:   [][]byte{FuncType(B(I32), B(I32))},
+		Funcs:   []Func{{Type: 0, Code: B(0x20, 0, 0x28, 2, 0)}},
+		Mems:    [][]byte{Memory(1, 2)},
+		Exports: [][]byte{ExportFunc("load", 0)},
+		Datas:   [][]byte{Data(8, []byte("data"))},
+	}
+	return m.Bytes()
+}
+
+func TestMemory(t *testing.T) {
+	inst, err := newWasmApi(t, memoryModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := inst.Memory()
+	if mem.Size() != 65536 {
+		t.Fatalf("memory of %d bytes", mem.Size())
+	}
+	if s, ok := mem.ReadString(8, 4); !ok || s != "data" {
+		t.Fatalf("ReadString(8, 4) = %q, %v", s, ok)
+	}
+
+	// writes of the host are seen by the guest
+	if !mem.WriteUint32Le(100, 0xdeadbeef) || !mem.WriteFloat64Le(200, 1.5) || !mem.WriteUint16Le(65534, 7) {
+		t.Fatal("writes in bounds failed")
+	}
+	if r, err := inst.CallRaw("load", 100); err != nil || r[0] != 0xdeadbeef {
+		t.Fatalf("load(100) = %v, %v", r, err)
+	}
+	if v, ok := mem.ReadFloat64Le(200); !ok || v != 1.5 {
+		t.Fatalf("ReadFloat64Le(200) = %v, %v", v, ok)
+	}
+	if v, ok := mem.ReadUint64Le(100); !ok || v != 0xdeadbeef {
+		t.Fatalf("ReadUint64Le(100) = %#x, %v", v, ok)
+	}
+
+	// accesses out of bounds fail without touching memory
+	if _, ok := mem.ReadUint32Le(65533); ok {
+		t.Error("ReadUint32Le(65533) succeeded")
+	}
+	if _, ok := mem.Read(math.MaxUint32, 2); ok {
+		t.Error("Read wrapping around succeeded")
+	}
+	if mem.Write(65535, []byte("ab")) {
+		t.Error("Write(65535) succeeded")
+	}
+	if v, _ := mem.ReadUint8(65535); v != 0 {
+		t.Errorf("failed write changed memory to %d", v)
+	}
+	if mem.WriteUint64Le(math.MaxUint32-3, 1) {
+		t.Error("WriteUint64Le wrapping around succeeded")
+	}
+
+	// io.ReaderAt and io.WriterAt
+	buf := make([]byte, 4)
+	if n, err := mem.ReadAt(buf, 65534); n != 2 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+	if n, err := mem.WriteAt([]byte("abc"), 65534); n != 0 || err == nil {
+		t.Errorf("WriteAt past the end = %d, %v", n, err)
+	}
+	if _, err := io.NewSectionReader(mem, 8, 4).Read(buf); err != nil || string(buf) != "data" {
+		t.Errorf("section of memory reads %q, %v", buf, err)
+	}
+
+	// memory grows up to its maximum
+	if prev, ok := mem.Grow(1); !ok || prev != 1 || mem.Size() != 2*65536 {
+		t.Fatalf("Grow(1) = %d, %v, size %d", prev, ok, mem.Size())
+	}
+	if _, ok := mem.Grow(1); ok {
+		t.Fatal("memory grew past its maximum")
+	}
+	if !mem.WriteUint32Le(65536+4, 42) {
+		t.Fatal("write to the page grown failed")
+	}
+	if r, err := inst.CallRaw("load", 65536+4); err != nil || r[0] != 42 {
+		t.Fatalf("load from the page grown = %v, %v", r, err)
+	}
+}
+
+func TestNoMemory(t *testing.T) {
+	inst, err := newWasmApi(t, arithModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem := inst.Memory(); mem != nil {
+		t.Fatalf("instance without memory has %v", mem)
+	}
+
+	var hostMem api.Memory = api.NewMemoryInstance(0, nil)
+	if hostMem.Size() != 0 || hostMem.WriteUint8(0, 1) {
+		t.Fatal("empty memory is writable")
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"os"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+type (
+	ExternalKind = exec.ExternalKind
+	FunctionSig  = exec.FunctionSig
+	// ExternType is the type of an import or export, see exec.ExternType for its fields
+	ExternType = exec.ExternType
+	ImportType = exec.ImportType
+	ExportType = exec.ExportType
+)
+
+const (
+	FunctionKind = exec.FunctionKind
+	TableKind    = exec.TableKind
+	MemoryKind   = exec.MemoryKind
+	GlobalKind   = exec.GlobalKind
+)
+
+// CompiledModule is a decoded module, it can be inspected before being instantiated
+// by Runtime.InstantiateModule any number of times
+type CompiledModule struct {
+	module *exec.Module
+}
+
+// CompileModule decodes binary module, limits of declarations are taken from opts
+func CompileModule(bin []byte, opts ...Option) (*CompiledModule, error) {
+	m, err := exec.DecodeModule(bin, exec.ConfigOf(opts...))
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledModule{module: m}, nil
+}
+
+// CompileFile is the same as CompileModule, but reads the module from path
+func CompileFile(path string, opts ...Option) (*CompiledModule, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return CompileModule(bin, opts...)
+}
+
+// Imports returns imports of the module in order of their declaration
+func (c *CompiledModule) Imports() []ImportType {
+	return c.module.Imports()
+}
+
+// Import looks up import of field name from module
+func (c *CompiledModule) Import(module, name string) (ImportType, bool) {
+	return c.module.Import(module, name)
+}
+
+// Exports returns exports of the module in order of their declaration
+func (c *CompiledModule) Exports() []ExportType {
+	return c.module.Exports()
+}
+
+// Export looks up export by name
+func (c *CompiledModule) Export(name string) (ExportType, bool) {
+	return c.module.Export(name)
+}
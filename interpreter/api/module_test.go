@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+func TestIntrospection(t *testing.T) {
+	c, err := api.CompileModule(externalsModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imports []string
+	for _, i := range c.Imports() {
+		imports = append(imports, fmt.Sprintf("%s.%s %v", i.Module, i.Name, i.Type))
+	}
+	if got, want := fmt.Sprint(imports), "[env.memory memory 1 env.table table 2 funcref env.base global i32]"; got != want {
+		t.Errorf("imports are %s, want %s", got, want)
+	}
+	if i, ok := c.Import("env", "base"); !ok || i.Type.Kind != api.GlobalKind || i.Type.Global.Mutable {
+		t.Errorf("import env.base = %v, %v", i, ok)
+	}
+	if _, ok := c.Import("lib", "base"); ok {
+		t.Error("import of another module found")
+	}
+
+	m := &Module{
+		Types:   [][]byte{FuncType(B(I32, F64), B(I64, F32))},
+		Funcs:   []Func{{Type: 0, Code: Cat(I64Const(0), F32Const(0))}},
+		Tables:  [][]byte{Table(FuncRef, 1, 8)},
+		Mems:    [][]byte{Memory(2, 3)},
+		Globals: [][]byte{Global(I64, true, I64Const(0))},
+		// in an order other than the one of kinds
+		Exports: [][]byte{ExportGlobal("g", 0), ExportMemory("mem", 0), ExportFunc("f", 0), ExportTable("t", 0)},
+	}
+	c, err = api.CompileModule(m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exports []string
+	for _, e := range c.Exports() {
+		exports = append(exports, fmt.Sprintf("%s %v", e.Name, e.Type))
+	}
+	if got, want := fmt.Sprint(exports), "[g global mut i64 mem memory 2..3 f func [i32 f64] -> [i64 f32] t table 1..8 funcref]"; got != want {
+		t.Errorf("exports are %s, want %s", got, want)
+	}
+	if e, ok := c.Export("f"); !ok || e.Type.Kind != api.FunctionKind || len(e.Type.Func.Results) != 2 {
+		t.Errorf("export f = %v, %v", e, ok)
+	}
+	if _, ok := c.Export("missing"); ok {
+		t.Error("missing export found")
+	}
+
+	// compiled modules are instantiated any number of times
+	rt := api.NewRuntime()
+	for i := 0; i < 2; i++ {
+		inst, err := rt.InstantiateModule("", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, err := inst.Call("f", api.ValueI32(1), api.ValueF64(1)); err != nil || len(r) != 2 {
+			t.Fatalf("f(1, 1) = %v, %v", r, err)
+		}
+	}
+}
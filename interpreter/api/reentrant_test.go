@@ -0,0 +1,91 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// countModule imports env.down of type (i32) -> i32, its function "count" returns 0 for x == 0
+// and down(x) + 1 otherwise
+func countModule() []byte {
+	m := &Module{
+		Types:   [][]byte{FuncType(B(I32), B(I32))},
+		Imports: [][]byte{ImportFunc("env", "down", 0)},
+		Funcs: []Func{{Type: 0, Code: Cat(
+			B(0x20, 0, 0x45), B(0x04, I32), I32Const(0), // if x == 0 then 0
+			B(0x05, 0x20, 0, 0x10, 0), I32Const(1), B(0x6a, 0x0b), // else down(x) + 1
+		)}},
+		Exports: [][]byte{ExportFunc("count", 1)},
+	}
+	return m.Bytes()
+}
+
+// TestReentrantHost calls count, whose import calls back into the instance with x - 1
+func TestReentrantHost(t *testing.T) {
+	calls := 0
+	step := int32(1)
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(_ context.Context, mod api.Module, x int32) (int32, error) {
+		calls++
+		count, ok := mod.ExportedFunction("count")
+		if !ok {
+			return 0, errors.New("count isn't exported")
+		}
+		r, err := count.Call(api.EncodeI32(x - step))
+		if err != nil {
+			return 0, err
+		}
+		return api.DecodeI32(r[0]), nil
+	}).Export("down").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := newWasmApi(t, countModule(), api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := inst.CallRaw("count", 100); err != nil || r[0] != 100 || calls != 100 {
+		t.Fatalf("count(100) = %v, %v after %d calls", r, err, calls)
+	}
+
+	// the depth limit covers the frames of all nested calls, the trap unwinds all of them
+	step = 0
+	if _, err := inst.CallRaw("count", 1); !errors.Is(err, exec.ErrCallStackExhausted) {
+		t.Fatalf("endless recursion through host: %v", err)
+	}
+	step, calls = 1, 0
+	if r, err := inst.CallRaw("count", 3); err != nil || r[0] != 3 || calls != 3 {
+		t.Fatalf("count(3) after trap = %v, %v after %d calls", r, err, calls)
+	}
+}
+
+func TestHostFunctionError(t *testing.T) {
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(x int32) (int32, error) {
+		if x < 0 {
+			return 0, errHost
+		}
+		return x, nil
+	}).Export("down").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := newWasmApi(t, countModule(), api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := inst.CallRaw("count", 5); err != nil || r[0] != 6 {
+		t.Errorf("count(5) = %v, %v", r, err)
+	}
+	if _, err := inst.CallRaw("count", api.EncodeI32(-1)); !errors.Is(err, errHost) {
+		t.Errorf("count(-1): %v", err)
+	}
+}
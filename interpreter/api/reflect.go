@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	moduleType  = reflect.TypeOf((*Module)(nil)).Elem()
+)
+
+// WithFunc sets implementation of the function to fn, an ordinary Go function. Its wasm type is
+// derived from the signature of fn: optional leading context.Context and Module parameters are
+// followed by parameters of types int32, uint32, int64, uint64, float32 or float64, and results
+// of these types. Parameters and results of type any are external references. Panics inside fn
+// become traps, so does non-nil error returned as the last result
+func (fb *HostFunctionBuilder) WithFunc(fn any) *HostFunctionBuilder {
+	hf, err := bindFunc(fn)
+	if err != nil {
+		fb.b.setErr(err)
+		return fb
+	}
+	fb.fn = hf
+	return fb
+}
+
+func bindFunc(fn any) (*exec.HostFunction, error) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.IsVariadic() {
+		return nil, fmt.Errorf("api: expected non-variadic func, got %v", t)
+	}
+
+	first := 0
+	withCtx := first < t.NumIn() && t.In(first) == contextType
+	if withCtx {
+		first++
+	}
+	withModule := first < t.NumIn() && t.In(first) == moduleType
+	if withModule {
+		first++
+	}
+
+	sig := new(exec.FunctionSig)
+	for i := first; i < t.NumIn(); i++ {
+		vt, ok := valueTypeOf(t.In(i))
+		if !ok {
+			return nil, fmt.Errorf("api: %v: unsupported parameter type %v", t, t.In(i))
+		}
+		sig.Params = append(sig.Params, vt)
+	}
+	numOut := t.NumOut()
+	withErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if withErr {
+		numOut--
+	}
+	for i := 0; i < numOut; i++ {
+		vt, ok := valueTypeOf(t.Out(i))
+		if !ok {
+			return nil, fmt.Errorf("api: %v: unsupported result type %v", t, t.Out(i))
+		}
+		sig.Results = append(sig.Results, vt)
+	}
+
+	if impl, ok := fastPath(fn); ok {
+		return &exec.HostFunction{Sig: sig, Fn: impl}, nil
+	}
+
+	impl := func(ctx context.Context, vm *exec.VM, stack []uint64) {
+		in := make([]reflect.Value, 0, t.NumIn())
+		if withCtx {
+			in = append(in, reflect.ValueOf(&ctx).Elem())
+		}
+		if withModule {
+			var mod Module = vm
+			in = append(in, reflect.ValueOf(&mod).Elem())
+		}
+		for i := first; i < t.NumIn(); i++ {
+			in = append(in, decodeValue(vm, t.In(i), stack[i-first]))
+		}
+		outs := v.Call(in)
+		if withErr {
+			if err := outs[numOut]; !err.IsNil() {
+				panic(err.Interface().(error))
+			}
+			outs = outs[:numOut]
+		}
+		for i, out := range outs {
+			stack[i] = encodeValue(vm, out)
+		}
+	}
+	return &exec.HostFunction{Sig: sig, Fn: impl}, nil
+}
+
+func valueTypeOf(t reflect.Type) (ValueType, bool) {
+	switch t.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return ValueTypeI32, true
+	case reflect.Int64, reflect.Uint64:
+		return ValueTypeI64, true
+	case reflect.Float32:
+		return ValueTypeF32, true
+	case reflect.Float64:
+		return ValueTypeF64, true
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return ValueTypeExternRef, true
+		}
+	}
+	return 0, false
+}
+
+func decodeValue(vm *exec.VM, t reflect.Type, raw uint64) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if ref, _ := vm.ExternValue(raw); ref != nil {
+			v.Set(reflect.ValueOf(ref))
+		}
+	case reflect.Int32:
+		v.SetInt(int64(int32(raw)))
+	case reflect.Uint32:
+		v.SetUint(uint64(uint32(raw)))
+	case reflect.Int64:
+		v.SetInt(int64(raw))
+	case reflect.Uint64:
+		v.SetUint(raw)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(raw))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(raw))
+	}
+	return v
+}
+
+func encodeValue(vm *exec.VM, v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return vm.ExternRef(v.Interface())
+	case reflect.Int32:
+		return uint64(uint32(v.Int()))
+	case reflect.Uint32:
+		return uint64(uint32(v.Uint()))
+	case reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32:
+		return uint64(math.Float32bits(float32(v.Float())))
+	}
+	return math.Float64bits(v.Float())
+}
+
+// fastPath binds functions of the most common shapes without reflection
+func fastPath(fn any) (exec.HostFunc, bool) {
+	switch fn := fn.(type) {
+	case func():
+		return func(context.Context, *exec.VM, []uint64) { fn() }, true
+	case func(uint32):
+		return func(_ context.Context, _ *exec.VM, stack []uint64) { fn(uint32(stack[0])) }, true
+	case func(uint32) uint32:
+		return func(_ context.Context, _ *exec.VM, stack []uint64) {
+			stack[0] = uint64(fn(uint32(stack[0])))
+		}, true
+	case func(uint32, uint32) uint32:
+		return func(_ context.Context, _ *exec.VM, stack []uint64) {
+			stack[0] = uint64(fn(uint32(stack[0]), uint32(stack[1])))
+		}, true
+	case func(context.Context, Module, uint32):
+		return func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			fn(ctx, vm, uint32(stack[0]))
+		}, true
+	case func(context.Context, Module, uint32, uint32):
+		return func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			fn(ctx, vm, uint32(stack[0]), uint32(stack[1]))
+		}, true
+	case func(context.Context, Module, uint32) uint32:
+		return func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			stack[0] = uint64(fn(ctx, vm, uint32(stack[0])))
+		}, true
+	case func(context.Context, Module, uint32, uint32) uint32:
+		return func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			stack[0] = uint64(fn(ctx, vm, uint32(stack[0]), uint32(stack[1])))
+		}, true
+	case func(context.Context, Module, uint32, uint32) int64:
+		return func(ctx context.Context, vm *exec.VM, stack []uint64) {
+			stack[0] = uint64(fn(ctx, vm, uint32(stack[0]), uint32(stack[1])))
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,87 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+var errHost = errors.New("host error")
+
+func TestWithFunc(t *testing.T) {
+	var stored uint32
+	env, err := api.NewHostModuleBuilder("env").
+		// bound without reflection
+		NewFunctionBuilder().WithFunc(func(a, b uint32) uint32 { return a - b }).Export("sub").
+		NewFunctionBuilder().WithFunc(func(_ context.Context, mod api.Module, v uint32) {
+		stored = v + mod.Memory().Size()
+	}).Export("store").
+		// by reflection
+		NewFunctionBuilder().WithFunc(func(_ context.Context, a int32, b uint64, c float32, d float64) float64 {
+		return float64(a) + float64(b) + float64(c) + d
+	}).Export("mix").
+		NewFunctionBuilder().WithFunc(func(v int32) int64 { return int64(v) * 2 }).Export("twice").
+		NewFunctionBuilder().WithFunc(func() { panic("boom") }).Export("boom").
+		NewFunctionBuilder().WithFunc(func(uint32) { panic(errHost) }).Export("fail").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := forwarders("env",
+		hostFunc{"sub", B(I32, I32), B(I32)},
+		hostFunc{"store", B(I32), nil},
+		hostFunc{"mix", B(I32, I64, F32, F64), B(F64)},
+		hostFunc{"twice", B(I32), B(I64)},
+		hostFunc{"boom", nil, nil},
+		hostFunc{"fail", B(I32), nil},
+	)
+	inst, err := newWasmApi(t, bin, api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := inst.CallRaw("sub", 3, 5); err != nil || r[0] != math.MaxUint32-1 {
+		t.Errorf("sub(3, 5) = %v, %v", r, err)
+	}
+	if _, err := inst.CallRaw("store", 7); err != nil || stored != 7+65536 {
+		t.Errorf("store(7) = %v, stored %d", err, stored)
+	}
+	r, err := inst.CallRaw("mix", uint64(math.MaxUint32), 2, uint64(math.Float32bits(0.5)), math.Float64bits(0.25))
+	if err != nil || r[0] != math.Float64bits(1.75) {
+		t.Errorf("mix(-1, 2, 0.5, 0.25) = %v, %v", r, err)
+	}
+	if r, err := inst.CallRaw("twice", uint64(math.MaxUint32)); err != nil || r[0] != math.MaxUint64-1 {
+		t.Errorf("twice(-1) = %v, %v", r, err)
+	}
+
+	// panics become traps
+	var panicErr exec.HostPanicError
+	if _, err := inst.CallRaw("boom"); !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("boom: %v", err)
+	}
+	if _, err := inst.CallRaw("fail", 0); !errors.Is(err, errHost) {
+		t.Errorf("fail: %v", err)
+	}
+	if r, err := inst.CallRaw("sub", 5, 3); err != nil || r[0] != 2 {
+		t.Errorf("sub(5, 3) after traps = %v, %v", r, err)
+	}
+}
+
+func TestWithFuncRejectsSignatures(t *testing.T) {
+	for _, fn := range []any{
+		42,
+		func(string) {},
+		func(...uint32) {},
+		func(api.Module) bool { return false },
+	} {
+		_, err := api.NewHostModuleBuilder("env").NewFunctionBuilder().WithFunc(fn).Export("f").Build()
+		if err == nil {
+			t.Errorf("%T bound", fn)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"os"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+)
+
+// Runtime links modules together: imports of a module are resolved against host modules
+// and exports of the modules instantiated by the runtime before
+type Runtime struct {
+	store *exec.Store
+}
+
+// NewRuntime creates a runtime, whose modules are configured with opts before options
+// passed on their instantiation
+func NewRuntime(opts ...Option) *Runtime {
+	return &Runtime{store: exec.NewStore(opts...)}
+}
+
+// Instantiate decodes binary module and instantiates it, its exports become importable
+// by other modules under name unless the name is empty
+func (r *Runtime) Instantiate(name string, bin []byte, opts ...Option) (*WasmApi, error) {
+	mod, err := exec.DecodeModule(bin, r.store.Config(opts...))
+	if err != nil {
+		return nil, err
+	}
+	vm, err := r.store.Instantiate(name, mod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &WasmApi{vm: vm}, nil
+}
+
+// InstantiateFile is the same as Instantiate, but reads the module from path
+func (r *Runtime) InstantiateFile(name, path string, opts ...Option) (*WasmApi, error) {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.Instantiate(name, bin, opts...)
+}
+
+// InstantiateModule is the same as Instantiate, but instantiates a module decoded before
+func (r *Runtime) InstantiateModule(name string, c *CompiledModule, opts ...Option) (*WasmApi, error) {
+	vm, err := r.store.Instantiate(name, c.module, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &WasmApi{vm: vm}, nil
+}
+
+// Module returns module instantiated under name
+func (r *Runtime) Module(name string) (*WasmApi, bool) {
+	vm, ok := r.store.Instance(name)
+	if !ok {
+		return nil, false
+	}
+	return &WasmApi{vm: vm}, true
+}
+
+// AddHostModule makes exports of hm importable by modules instantiated afterwards
+func (r *Runtime) AddHostModule(hm *HostModule) error {
+	return r.store.AddHostModule(hm)
+}
@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// libModule exports memory, "double" storing twice its argument at 0 and "trap"
+func libModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32), B(I32)), FuncType(nil, nil)},
+		Funcs: []Func{
+			{Type: 0, Code: Cat(I32Const(0), B(0x20, 0), I32Const(1), B(0x74), B(0x36, 2, 0), I32Const(0), B(0x28, 2, 0))},
+			{Type: 1, Code: B(0x00)},
+		},
+		Mems:    [][]byte{Memory(1, -1)},
+		Exports: [][]byte{ExportFunc("double", 0), ExportFunc("trap", 1), ExportMemory("memory", 0)},
+	}
+	return m.Bytes()
+}
+
+// appModule imports memory and functions of lib, its "run" returns lib.double of x
+// incremented by the value lib stored in memory
+func appModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32), B(I32)), FuncType(nil, nil)},
+		Imports: [][]byte{
+			ImportFunc("lib", "double", 0),
+			ImportFunc("lib", "trap", 1),
+			ImportMemory("lib", "memory", 1),
+		},
+		Funcs: []Func{
+			{Type: 0, Code: Cat(B(0x20, 0), B(0x10, 0), I32Const(0), B(0x28, 2, 0), B(0x6a))},
+			{Type: 1, Code: B(0x10, 1)},
+		},
+		Exports: [][]byte{ExportFunc("run", 2), ExportFunc("trap", 3)},
+	}
+	return m.Bytes()
+}
+
+func TestRuntimeLinking(t *testing.T) {
+	rt := api.NewRuntime()
+	if _, err := rt.Instantiate("lib", libModule()); err != nil {
+		t.Fatal(err)
+	}
+	app, err := rt.Instantiate("", appModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := app.CallRaw("run", 21); err != nil || r[0] != 84 {
+		t.Fatalf("run(21) = %v, %v", r, err)
+	}
+	if _, err := app.CallRaw("trap"); !errors.Is(err, exec.ErrUnreachable) {
+		t.Fatalf("trap in lib: %v", err)
+	}
+	lib, ok := rt.Module("lib")
+	if !ok {
+		t.Fatal("lib isn't registered")
+	}
+	if r, err := lib.CallRaw("double", 2); err != nil || r[0] != 4 {
+		t.Fatalf("double(2) after trap = %v, %v", r, err)
+	}
+	if _, ok := rt.Module(""); ok {
+		t.Fatal("anonymous module registered")
+	}
+}
+
+func TestRuntimeModuleNames(t *testing.T) {
+	rt := api.NewRuntime()
+	var unresolved exec.UnresolvedImportError
+	if _, err := rt.Instantiate("", appModule()); !errors.As(err, &unresolved) {
+		t.Fatalf("instantiation before lib: %v", err)
+	}
+	if _, err := rt.Instantiate("lib", libModule()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Instantiate("lib", libModule()); err == nil {
+		t.Fatal("module instantiated under the name taken")
+	}
+	hm, err := api.NewHostModuleBuilder("lib").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.AddHostModule(hm); err == nil {
+		t.Fatal("host module added under the name taken")
+	}
+	// host modules are linked just like instances
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(v uint32) uint32 { return v + 1 }).Export("inc").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.AddHostModule(env); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := rt.Instantiate("", forwarders("env", hostFunc{"inc", B(I32), B(I32)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := inst.CallRaw("inc", 1); err != nil || r[0] != 2 {
+		t.Fatalf("inc(1) = %v, %v", r, err)
+	}
+}
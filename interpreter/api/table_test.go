@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// tableModule exports table "table" of 2..4 elements holding "seven" at 0, function "dispatch"
+// calls the element at x
+func tableModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(nil, B(I32)), FuncType(B(I32), B(I32))},
+		Funcs: []Func{
+			{Type: 0, Code: I32Const(7)},
+			{Type: 1, Code: B(0x20, 0, 0x11, 0, 0)},
+		},
+		Tables:  [][]byte{Table(FuncRef, 2, 4)},
+		Exports: [][]byte{ExportFunc("seven", 0), ExportFunc("dispatch", 1), ExportTable("table", 0)},
+		Elems:   [][]byte{Elem(0, 0)},
+	}
+	return m.Bytes()
+}
+
+func TestTable(t *testing.T) {
+	inst, err := newWasmApi(t, tableModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, ok := inst.Table("table")
+	if !ok || table.Size() != 2 {
+		t.Fatalf("table = %v, %v", table, ok)
+	}
+	if _, ok := inst.Table("seven"); ok {
+		t.Error("function found as table")
+	}
+
+	elem, ok := table.Get(0)
+	ref, isRef := elem.(*api.FuncRef)
+	if !ok || !isRef {
+		t.Fatalf("table[0] = %v, %v", elem, ok)
+	}
+	if sig := ref.Sig(); len(sig.Params) != 0 || len(sig.Results) != 1 || sig.Results[0] != api.ValueTypeI32 {
+		t.Errorf("signature of table[0] is %v -> %v", sig.Params, sig.Results)
+	}
+	if r, err := ref.Call(); err != nil || r[0] != 7 {
+		t.Errorf("table[0]() = %v, %v", r, err)
+	}
+	if elem, ok := table.Get(1); !ok || elem != nil {
+		t.Errorf("table[1] = %v, %v", elem, ok)
+	}
+	if _, ok := table.Get(2); ok {
+		t.Error("element out of bounds got")
+	}
+
+	if _, err := inst.CallRaw("dispatch", 1); err == nil {
+		t.Fatal("null element called")
+	}
+	seven, ok := inst.Function("seven")
+	if !ok {
+		t.Fatal("function seven not found")
+	}
+	if err := table.Set(1, seven); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := inst.CallRaw("dispatch", 1); err != nil || r[0] != 7 {
+		t.Fatalf("dispatch(1) = %v, %v", r, err)
+	}
+	if err := table.Set(2, seven); !errors.Is(err, exec.ErrTableIndexOutOfBounds) {
+		t.Errorf("store out of bounds: %v", err)
+	}
+	if err := table.Set(0, "seven"); err == nil {
+		t.Error("host value stored in table of function references")
+	}
+
+	if size, ok := table.Grow(2, seven); !ok || size != 2 {
+		t.Fatalf("Grow(2) = %d, %v", size, ok)
+	}
+	if r, err := inst.CallRaw("dispatch", 3); err != nil || r[0] != 7 {
+		t.Fatalf("dispatch(3) = %v, %v", r, err)
+	}
+	if _, ok := table.Grow(1, nil); ok {
+		t.Error("table grown beyond its maximum")
+	}
+}
+
+func TestExternTable(t *testing.T) {
+	table := api.NewTableInstance(api.ValueTypeExternRef, 1, nil)
+	if err := table.Set(0, "host"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := table.Get(0); !ok || v != "host" {
+		t.Errorf("table[0] = %v, %v", v, ok)
+	}
+	if size, ok := table.Grow(1, 42); !ok || size != 1 {
+		t.Fatalf("Grow(1) = %d, %v", size, ok)
+	}
+	if v, _ := table.Get(1); v != 42 {
+		t.Errorf("table[1] = %v", v)
+	}
+
+	// tables of external references can't be imported as tables of functions
+	var incompatible exec.IncompatibleImportError
+	_, err := newWasmApi(t, externalsModule(), api.WithHostModules(hostExternals(t, api.NewMemoryInstance(1, nil), table, 0)))
+	if !errors.As(err, &incompatible) || incompatible.Name != "table" {
+		t.Fatalf("instantiation with a table of external references: %v", err)
+	}
+}
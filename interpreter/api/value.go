@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+const (
+	ValueTypeV128      ValueType = types.ValueTypeVector
+	ValueTypeFuncRef   ValueType = types.ValueTypeFuncRef
+	ValueTypeExternRef ValueType = types.ValueTypeExternRef
+)
+
+// Value is a wasm value along with its type. Numbers are kept in the same encoding they have
+// on the operand stack, see EncodeI32 and friends, references are opaque and zero means null.
+// References made by ValueExtern and ValueFunc carry a host value instead
+type Value struct {
+	typ ValueType
+	// lo holds all types but v128, whose upper half is in hi
+	lo, hi uint64
+	ref    any
+}
+
+func ValueI32(v int32) Value   { return Value{typ: ValueTypeI32, lo: EncodeI32(v)} }
+func ValueI64(v int64) Value   { return Value{typ: ValueTypeI64, lo: EncodeI64(v)} }
+func ValueF32(v float32) Value { return Value{typ: ValueTypeF32, lo: EncodeF32(v)} }
+func ValueF64(v float64) Value { return Value{typ: ValueTypeF64, lo: EncodeF64(v)} }
+func ValueV128(lo, hi uint64) Value {
+	return Value{typ: ValueTypeV128, lo: lo, hi: hi}
+}
+func ValueFuncRef(ref uint64) Value   { return Value{typ: ValueTypeFuncRef, lo: ref} }
+func ValueExternRef(ref uint64) Value { return Value{typ: ValueTypeExternRef, lo: ref} }
+
+// ValueExtern wraps host value v to be passed to a guest as externref, nil is null reference
+func ValueExtern(v any) Value { return Value{typ: ValueTypeExternRef, ref: v} }
+
+// ValueFunc wraps function ref to be passed to a guest as funcref, nil is null reference
+func ValueFunc(ref *FuncRef) Value {
+	if ref == nil {
+		return Value{typ: ValueTypeFuncRef}
+	}
+	return Value{typ: ValueTypeFuncRef, ref: ref}
+}
+
+func isReference(t ValueType) bool {
+	return t == ValueTypeFuncRef || t == ValueTypeExternRef
+}
+
+// ValueOf wraps raw value of type typ, the way values are passed to and returned by host functions
+func ValueOf(typ ValueType, raw uint64) Value {
+	return Value{typ: typ, lo: raw}
+}
+
+func (v Value) Type() ValueType { return v.typ }
+
+// Raw returns value in the encoding of the operand stack, the lower half for v128
+func (v Value) Raw() uint64 { return v.lo }
+
+func (v Value) I32() int32            { return DecodeI32(v.lo) }
+func (v Value) U32() uint32           { return DecodeU32(v.lo) }
+func (v Value) I64() int64            { return DecodeI64(v.lo) }
+func (v Value) F32() float32          { return DecodeF32(v.lo) }
+func (v Value) F64() float64          { return DecodeF64(v.lo) }
+func (v Value) V128() (lo, hi uint64) { return v.lo, v.hi }
+
+// Extern returns host value of external reference made by ValueExtern or returned by a guest,
+// *FuncRef for function references
+func (v Value) Extern() any { return v.ref }
+
+// IsNull reports whether v is a null reference
+func (v Value) IsNull() bool {
+	return (v.typ == ValueTypeFuncRef || v.typ == ValueTypeExternRef) && v.lo == 0 && v.ref == nil
+}
+
+func (v Value) String() string {
+	switch v.typ {
+	case ValueTypeI32:
+		return fmt.Sprintf("i32:%d", v.I32())
+	case ValueTypeI64:
+		return fmt.Sprintf("i64:%d", v.I64())
+	case ValueTypeF32:
+		return fmt.Sprintf("f32:%v", v.F32())
+	case ValueTypeF64:
+		return fmt.Sprintf("f64:%v", v.F64())
+	case ValueTypeV128:
+		return fmt.Sprintf("v128:%#016x%016x", v.hi, v.lo)
+	}
+	if v.IsNull() {
+		return v.typ.String() + ":null"
+	}
+	if v.ref != nil {
+		return fmt.Sprintf("%v:%v", v.typ, v.ref)
+	}
+	return fmt.Sprintf("%v:%#x", v.typ, v.lo)
+}
+
+func EncodeI32(v int32) uint64   { return uint64(uint32(v)) }
+func EncodeU32(v uint32) uint64  { return uint64(v) }
+func EncodeI64(v int64) uint64   { return uint64(v) }
+func EncodeF32(v float32) uint64 { return uint64(math.Float32bits(v)) }
+func EncodeF64(v float64) uint64 { return math.Float64bits(v) }
+
+func DecodeI32(v uint64) int32   { return int32(v) }
+func DecodeU32(v uint64) uint32  { return uint32(v) }
+func DecodeI64(v uint64) int64   { return int64(v) }
+func DecodeF32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+func DecodeF64(v uint64) float64 { return math.Float64frombits(v) }
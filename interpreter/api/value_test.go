@@ -0,0 +1,89 @@
+package api_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+func TestCallTyped(t *testing.T) {
+	// divmod returns both the quotient and the remainder of host function divmod, scale
+	// multiplies its f32 and f64 arguments by its i64 one
+	env, err := api.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(a, b int32) (int32, int32) { return a / b, a % b }).Export("divmod").
+		NewFunctionBuilder().WithFunc(func(a float32, b float64, n int64) (float32, float64) {
+		return a * float32(n), b * float64(n)
+	}).Export("scale").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := newWasmApi(t, forwarders("env",
+		hostFunc{"divmod", B(I32, I32), B(I32, I32)},
+		hostFunc{"scale", B(F32, F64, I64), B(F32, F64)},
+	), api.WithHostModules(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := inst.Call("divmod", api.ValueI32(-7), api.ValueI32(2))
+	if err != nil || len(r) != 2 || r[0].I32() != -3 || r[1].I32() != -1 || r[0].Type() != api.ValueTypeI32 {
+		t.Fatalf("divmod(-7, 2) = %v, %v", r, err)
+	}
+	r, err = inst.Call("scale", api.ValueF32(1.5), api.ValueF64(-0.25), api.ValueI64(-4))
+	if err != nil || len(r) != 2 || r[0].F32() != -6 || r[1].F64() != 1 {
+		t.Fatalf("scale(1.5, -0.25, -4) = %v, %v", r, err)
+	}
+	if got := r[0].String() + " " + r[1].String(); got != "f32:-6 f64:1" {
+		t.Errorf("results are formatted as %s", got)
+	}
+	raw, err := inst.CallRaw("divmod", api.EncodeI32(9), api.EncodeI32(4))
+	if err != nil || len(raw) != 2 || api.DecodeI32(raw[0]) != 2 || api.DecodeI32(raw[1]) != 1 {
+		t.Fatalf("raw divmod(9, 4) = %v, %v", raw, err)
+	}
+
+	// arguments are checked against the signature
+	for _, args := range [][]api.Value{
+		nil,
+		{api.ValueI32(1)},
+		{api.ValueI32(1), api.ValueI64(1)},
+		{api.ValueI32(1), api.ValueI32(1), api.ValueI32(1)},
+		{api.ValueF32(1), api.ValueI32(1)},
+	} {
+		if _, err := inst.Call("divmod", args...); err == nil {
+			t.Errorf("divmod called with %v", args)
+		}
+	}
+	if _, err := inst.Call("missing"); err == nil {
+		t.Error("missing function called")
+	}
+}
+
+func TestValueEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		v    api.Value
+		raw  uint64
+		text string
+	}{
+		{api.ValueI32(-1), math.MaxUint32, "i32:-1"},
+		{api.ValueI64(-1), math.MaxUint64, "i64:-1"},
+		{api.ValueF32(0.5), uint64(math.Float32bits(0.5)), "f32:0.5"},
+		{api.ValueF64(-2), math.Float64bits(-2), "f64:-2"},
+		{api.ValueOf(api.ValueTypeI32, 7), 7, "i32:7"},
+	} {
+		if tc.v.Raw() != tc.raw || tc.v.String() != tc.text {
+			t.Errorf("%v is encoded as %#x, want %#x %s", tc.v, tc.v.Raw(), tc.raw, tc.text)
+		}
+		if tc.v.IsNull() {
+			t.Errorf("%v is null", tc.v)
+		}
+	}
+	if v := api.ValueOf(api.ValueTypeI32, math.MaxUint32); v.I32() != -1 || v.U32() != math.MaxUint32 {
+		t.Errorf("%#x decodes to %d and %d", v.Raw(), v.I32(), v.U32())
+	}
+	if !api.ValueFuncRef(0).IsNull() || !api.ValueExternRef(0).IsNull() || api.ValueExternRef(1).IsNull() {
+		t.Error("null references are misreported")
+	}
+}
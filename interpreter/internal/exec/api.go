@@ -5,7 +5,7 @@ import (
 )
 
 func (vm *VM) QueryFunction(name string) (uint32, error) {
-	if index, ok := vm.funcMap[name]; ok {
+	if index, ok := vm.Export(FunctionKind, name); ok {
 		return index, nil
 	}
 	return 0, fmt.Errorf("no index associated with function '%s'", name)
@@ -16,3 +16,11 @@ func (vm *VM) PushValuesToStack(values ...uint64) {
 		vm.pushUint64(val)
 	}
 }
+
+// FunctionSig returns type of function at index of the function index space
+func (vm *VM) FunctionSig(index uint32) (*FunctionSig, bool) {
+	if int(index) >= len(vm.funcs) {
+		return nil, false
+	}
+	return vm.funcs[index].sig, true
+}
@@ -2,15 +2,14 @@ package exec
 
 import (
 	"encoding/binary"
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
 	"math"
 )
 
+// pushUint64 doesn't check for overflow, the stack is grown on function entry
+// to fit maximum stack height of the function
 func (vm *VM) pushUint64(n uint64) {
-	if len(vm.ctx.stack) >= cap(vm.ctx.stack) {
-		reporter.ReportError("stack exceeding max depth: len=%d,cap=%d", len(vm.ctx.stack), cap(vm.ctx.stack))
-	}
-	vm.ctx.stack = append(vm.ctx.stack, n)
+	vm.stack[vm.sp] = n
+	vm.sp++
 }
 
 func (vm *VM) pushInt64(n int64) {
@@ -22,7 +21,7 @@ func (vm *VM) pushUint32(n uint32) {
 }
 
 func (vm *VM) pushInt32(n int32) {
-	vm.pushUint64(uint64(n))
+	vm.pushUint64(uint64(uint32(n)))
 }
 
 // pushZero is a pseudo-instruction, it has a practical utility in cmp instruction
@@ -36,13 +35,8 @@ func (vm *VM) pushOne() {
 }
 
 func (vm *VM) popUint64() uint64 {
-	if len(vm.ctx.stack) == 0 {
-		reporter.ReportError("popUint64: stack's empty")
-	}
-	idx := len(vm.ctx.stack) - 1
-	val := vm.ctx.stack[idx]
-	vm.ctx.stack = vm.ctx.stack[:idx]
-	return val
+	vm.sp--
+	return vm.stack[vm.sp]
 }
 
 func (vm *VM) popInt64() int64 {
@@ -59,12 +53,7 @@ func (vm *VM) popInt32() int32 {
 
 // the same as popUint64, but doesn't pop value off the stack
 func (vm *VM) peekUint64() uint64 {
-	if len(vm.ctx.stack) == 0 {
-		reporter.ReportError("peekUint64: stack's empty")
-	}
-	idx := len(vm.ctx.stack) - 1
-	val := vm.ctx.stack[idx]
-	return val
+	return vm.stack[vm.sp-1]
 }
 
 func (vm *VM) peekInt64() int64 {
@@ -112,17 +101,22 @@ func (vm *VM) fetchFloat64() float64 {
 }
 
 func (vm *VM) pushFloat32(v float32) {
-	vm.pushUint32(uint32(v))
+	vm.pushUint32(math.Float32bits(v))
 }
 
 func (vm *VM) pushFloat64(v float64) {
-	vm.pushUint64(uint64(v))
+	vm.pushUint64(math.Float64bits(v))
 }
 
 func (vm *VM) popFloat32() float32 {
 	return math.Float32frombits(vm.popUint32())
 }
 
-func (vm *VM) currIns() Instr {
-	return vm.ctx.ins[vm.ctx.pc]
+func (vm *VM) popFloat64() float64 {
+	return math.Float64frombits(vm.popUint64())
+}
+
+// currIns returns the instruction being executed
+func (vm *VM) currIns() *CompiledIns {
+	return vm.ctx.ins
 }
@@ -0,0 +1,298 @@
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// internal opcodes, they never appear in wasm binaries
+const (
+	jmpOp      Bytecode = 0xF0
+	jmpIfNotOp Bytecode = 0xF1
+)
+
+// CompiledIns is a fixed-width instruction of the internal bytecode, see Compile
+type CompiledIns struct {
+	code Bytecode
+	// index of a local, global or function, offset of memory access,
+	// index of instruction to continue execution at in case of branches
+	arg0 uint32
+	// stack height of the label a branch targets, table index of call_indirect and
+	// prefixed table instructions
+	arg1 uint32
+	// number of values carried over by a branch, source table of table.copy
+	arg2 uint32
+	// binary representation of a constant
+	imm uint64
+}
+
+type label struct {
+	height int
+	// number of parameters and results of the block
+	params, arity int
+	loop          bool
+	// first instruction of a loop, this is where branches to loops continue at
+	start int
+	// branches to a block that need to be patched once its end is reached
+	fixups []int
+}
+
+type compiler struct {
+	module *Module
+	code   []CompiledIns
+	labels []*label
+	// height of the operand stack at the point being compiled
+	height int
+}
+
+// Compile flattens decoded instructions of a function with numResults results into the internal bytecode:
+// structured control flow is turned into jumps with precomputed stack adjustments and immediates are unboxed
+func Compile(m *Module, numResults int, is []Instr) ([]CompiledIns, error) {
+	c := &compiler{module: m}
+	body := &label{arity: numResults}
+	c.labels = append(c.labels, body)
+	if err := c.compile(is); err != nil {
+		return nil, err
+	}
+	c.resolve(body)
+	return c.code, nil
+}
+
+func (c *compiler) emit(in CompiledIns) {
+	c.code = append(c.code, in)
+}
+
+func (c *compiler) push(n int) {
+	c.height += n
+}
+
+func (c *compiler) pop(n int) {
+	c.height -= n
+	// stack is polymorphic in unreachable code, don't go below the height of enclosing label
+	if top := c.labels[len(c.labels)-1]; c.height < top.height {
+		c.height = top.height
+	}
+}
+
+// markUnreachable must be called after unconditional transfers of control
+func (c *compiler) markUnreachable() {
+	c.height = c.labels[len(c.labels)-1].height
+}
+
+// resolve patches forward branches to l with the current position
+func (c *compiler) resolve(l *label) {
+	for _, fixup := range l.fixups {
+		c.code[fixup].arg0 = uint32(len(c.code))
+	}
+}
+
+// enter starts a block, whose parameters are on top of the operand stack already
+func (c *compiler) enter(bt types.BlockType, loop bool) (*label, error) {
+	params, results, err := c.blockArity(bt)
+	if err != nil {
+		return nil, err
+	}
+	l := &label{height: c.height - params, params: params, arity: results, loop: loop, start: len(c.code)}
+	c.labels = append(c.labels, l)
+	return l, nil
+}
+
+func (c *compiler) leave(l *label) {
+	c.labels = c.labels[:len(c.labels)-1]
+	c.height = l.height + l.arity
+	if !l.loop {
+		c.resolve(l)
+	}
+}
+
+// branch emits a branch to the label at given depth
+func (c *compiler) branch(code Bytecode, depth uint32) error {
+	if int(depth) >= len(c.labels) {
+		return fmt.Errorf("compile: branch depth %d exceeds number of enclosing labels", depth)
+	}
+	l := c.labels[len(c.labels)-1-int(depth)]
+	in := CompiledIns{code: code, arg1: uint32(l.height)}
+	if l.loop {
+		in.arg0, in.arg2 = uint32(l.start), uint32(l.params)
+	} else {
+		in.arg2 = uint32(l.arity)
+		l.fixups = append(l.fixups, len(c.code))
+	}
+	c.emit(in)
+	return nil
+}
+
+// blockArity returns number of parameters and results of a block
+func (c *compiler) blockArity(bt types.BlockType) (int, int, error) {
+	switch bt := bt.(type) {
+	case types.ResultBlockType:
+		return 0, 1, nil
+	case types.OtherBlockType:
+		sig, ok := c.module.sig(uint32(bt.X))
+		if !ok {
+			return 0, 0, fmt.Errorf("compile: block refers to unknown type %d", bt.X)
+		}
+		return len(sig.Params), sig.numResults(), nil
+	}
+	return 0, 0, nil
+}
+
+func (c *compiler) compile(is []Instr) error {
+	for _, i := range is {
+		if err := c.compileIns(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) compileIns(i Instr) error {
+	var arg0, arg1 any
+	if in, ok := i.(interface{ args() (any, any) }); ok {
+		arg0, arg1 = in.args()
+	}
+
+	switch code := i.Op().Code; code {
+	default:
+		op := lookupOp(code)
+		c.pop(countTypes(op.InputTypes))
+		c.push(countTypes(op.OutputTypes))
+		c.emit(CompiledIns{code: code})
+	case nopOp:
+	case miscPrefix:
+		in := i.(*MiscI)
+		op := i.Op()
+		c.pop(countTypes(op.InputTypes))
+		c.push(countTypes(op.OutputTypes))
+		c.emit(CompiledIns{code: code, arg0: in.sub, arg1: in.tables[0], arg2: in.tables[1]})
+	case blockOp:
+		in := i.(*BlockI)
+		l, err := c.enter(in.blockType, false)
+		if err != nil {
+			return err
+		}
+		if err = c.compile(in.body); err != nil {
+			return err
+		}
+		c.leave(l)
+	case loopOp:
+		in := i.(*LoopI)
+		l, err := c.enter(in.blockType, true)
+		if err != nil {
+			return err
+		}
+		if err = c.compile(in.body); err != nil {
+			return err
+		}
+		c.leave(l)
+	case ifOp:
+		in := i.(*IfI)
+		c.pop(1)
+		l, err := c.enter(in.blockType, false)
+		if err != nil {
+			return err
+		}
+		cond := len(c.code)
+		c.emit(CompiledIns{code: jmpIfNotOp})
+		if err = c.compile(in.body); err != nil {
+			return err
+		}
+		if in.elseBody != nil {
+			l.fixups = append(l.fixups, len(c.code))
+			c.emit(CompiledIns{code: jmpOp})
+			c.code[cond].arg0 = uint32(len(c.code))
+			c.height = l.height + l.params
+			if err = c.compile(in.elseBody); err != nil {
+				return err
+			}
+		} else {
+			l.fixups = append(l.fixups, cond)
+		}
+		c.leave(l)
+	case brOp:
+		if err := c.branch(code, arg0.(uint32)); err != nil {
+			return err
+		}
+		c.markUnreachable()
+	case brIfOp:
+		c.pop(1)
+		if err := c.branch(code, arg0.(uint32)); err != nil {
+			return err
+		}
+	case brTableOp:
+		in := i.(*BrTableI)
+		c.pop(1)
+		c.emit(CompiledIns{code: code, arg0: uint32(len(in.labels))})
+		for _, depth := range append(in.labels, in.defaultLabel) {
+			if err := c.branch(brOp, depth); err != nil {
+				return err
+			}
+		}
+		c.markUnreachable()
+	case returnOp, unreachableOp:
+		c.emit(CompiledIns{code: code})
+		c.markUnreachable()
+	case callOp:
+		index := arg0.(uint32)
+		if int(index) >= len(c.module.FunctionIndexSpace) {
+			return fmt.Errorf("compile: call to unknown function %d", index)
+		}
+		fn := c.module.FunctionIndexSpace[index]
+		c.pop(len(fn.sig.Params))
+		c.push(fn.sig.numResults())
+		c.emit(CompiledIns{code: code, arg0: index})
+	case callIndirectOp:
+		typeIndex, tableIndex := arg0.(uint32), arg1.(uint32)
+		if c.module.TypesSection == nil || int(typeIndex) >= len(c.module.TypesSection.sigs) {
+			return fmt.Errorf("compile: call_indirect refers to unknown type %d", typeIndex)
+		}
+		sig := c.module.TypesSection.sigs[typeIndex]
+		c.pop(1 + len(sig.Params))
+		c.push(sig.numResults())
+		c.emit(CompiledIns{code: code, arg0: typeIndex, arg1: tableIndex})
+	case localGetOp, globalGetOp, refFuncOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code, arg0: arg0.(uint32)})
+	case localSetOp, globalSetOp:
+		c.pop(1)
+		c.emit(CompiledIns{code: code, arg0: arg0.(uint32)})
+	case tableGetOp:
+		c.emit(CompiledIns{code: code, arg0: arg0.(uint32)})
+	case tableSetOp:
+		c.pop(2)
+		c.emit(CompiledIns{code: code, arg0: arg0.(uint32)})
+	case refNullOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code})
+	case localTeeOp:
+		c.emit(CompiledIns{code: code, arg0: arg0.(uint32)})
+	case dropOp:
+		c.pop(1)
+		c.emit(CompiledIns{code: code})
+	case selectOp, selectTypedOp:
+		c.pop(2)
+		c.emit(CompiledIns{code: code})
+	case i32ConstOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code, imm: uint64(uint32(arg0.(int32)))})
+	case i64ConstOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code, imm: uint64(arg0.(int64))})
+	case f32ConstOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code, imm: uint64(math.Float32bits(arg0.(float32)))})
+	case f64ConstOp:
+		c.push(1)
+		c.emit(CompiledIns{code: code, imm: math.Float64bits(arg0.(float64))})
+	case i32LoadOp, i64LoadOp, f32LoadOp, f64LoadOp, i32Load8SOp, i32Load8UOp, i32Load16SOp, i32Load16UOp,
+		i64Load8SOp, i64Load8UOp, i64Load16SOp, i64Load16UOp, i64Load32SOp, i64Load32UOp,
+		i32StoreOp, i64StoreOp, f32StoreOp, f64StoreOp, i32Store8Op, i32Store16Op, i64Store8Op, i64Store16Op, i64Store32Op:
+		op := lookupOp(code)
+		c.pop(countTypes(op.InputTypes))
+		c.push(countTypes(op.OutputTypes))
+		c.emit(CompiledIns{code: code, arg0: arg1.(uint32)})
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// default limits of declarations of a module
+const (
+	maxFunctions    = 1 << 20
+	maxLocals       = 50000
+	maxFunctionSize = 1 << 23
+	maxDataSize     = 1 << 30
+)
+
+// Features is a set of WebAssembly proposals a module may rely on
+type Features uint64
+
+const (
+	FeatureMultiValue Features = 1 << iota
+	FeatureBulkMemory
+	FeatureReferenceTypes
+	FeatureSIMD
+	FeatureThreads
+	FeatureTailCalls
+)
+
+// SupportedFeatures are the proposals implemented by the interpreter. Instructions relying on
+// passive segments, memory.init, data.drop, table.init and elem.drop, aren't supported so far
+const SupportedFeatures = FeatureMultiValue | FeatureBulkMemory | FeatureReferenceTypes
+
+var featureNames = []string{"multi-value", "bulk-memory", "reference-types", "simd", "threads", "tail-calls"}
+
+func (f Features) String() string {
+	if f == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range featureNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := f &^ (1<<len(featureNames) - 1); rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint64(rest)))
+	}
+	return strings.Join(names, "|")
+}
+
+// DisabledFeatureError is returned when a module relies on proposals disabled by RuntimeConfig
+type DisabledFeatureError struct {
+	Features Features
+}
+
+func (e DisabledFeatureError) Error() string {
+	return fmt.Sprintf("exec: module requires disabled features %v", e.Features)
+}
+
+// LimitError is returned on decoding and instantiation of a module exceeding limits, either
+// configured by RuntimeConfig or imposed by the size of the input
+type LimitError struct {
+	What         string
+	Value, Limit uint64
+	// what the limit is measured in
+	Unit string
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("exec: %d %s exceed limit of %d %s", e.Value, e.What, e.Limit, e.Unit)
+}
+
+// RuntimeConfig limits resources available to instances and selects enabled proposals,
+// limits of declarations are enforced by the decoder already
+type RuntimeConfig struct {
+	// MaxStackSize limits the value stack shared by all frames, in values
+	MaxStackSize int
+	// MaxCallDepth limits the number of nested calls, see VM.SetMaxCallDepth
+	MaxCallDepth int
+	// MaxMemoryPages limits size of memory instantiated or grown by the instance, growth of
+	// memory imported or exported by the instance is limited for other users as well
+	MaxMemoryPages uint32
+	// MaxTableSize limits size of tables defined or imported by the module the same way
+	MaxTableSize uint32
+	// MaxFunctions limits the number of functions, imported ones included
+	MaxFunctions uint32
+	// MaxLocals limits the number of locals of a function, parameters included
+	MaxLocals uint32
+	// MaxFunctionSize limits size of a function body in bytes
+	MaxFunctionSize uint32
+	// MaxDataSize limits total size of data segments in bytes
+	MaxDataSize uint32
+	// MaxInstances limits the number of instances created by a Store, zero means no limit
+	MaxInstances int
+	// Features enabled for modules, relying on others makes them fail to instantiate or run
+	Features Features
+}
+
+// DefaultRuntimeConfig enables all supported features with the default limits
+func DefaultRuntimeConfig() RuntimeConfig {
+	return RuntimeConfig{
+		MaxStackSize:    maxStackSize,
+		MaxCallDepth:    maxStackFrameNum,
+		MaxMemoryPages:  defaultMemoryPages,
+		MaxTableSize:    maxTableSize,
+		MaxFunctions:    maxFunctions,
+		MaxLocals:       maxLocals,
+		MaxFunctionSize: maxFunctionSize,
+		MaxDataSize:     maxDataSize,
+		Features:        SupportedFeatures,
+	}
+}
+
+func (c *RuntimeConfig) validate() error {
+	if unsupported := c.Features &^ SupportedFeatures; unsupported != 0 {
+		return fmt.Errorf("exec: features %v aren't supported", unsupported)
+	}
+	if c.MaxStackSize <= 0 || c.MaxCallDepth <= 0 || c.MaxInstances < 0 {
+		return fmt.Errorf("exec: invalid runtime limits %+v", *c)
+	}
+	if c.MaxMemoryPages > maxMemoryPages {
+		return fmt.Errorf("exec: memory limit of %d pages exceeds %d", c.MaxMemoryPages, maxMemoryPages)
+	}
+	return nil
+}
+
+// WithRuntimeConfig replaces the whole configuration of VM
+func WithRuntimeConfig(config RuntimeConfig) VMOption {
+	return func(vm *VM) {
+		vm.config = config
+	}
+}
+
+func WithMaxStackSize(size int) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxStackSize = size
+	}
+}
+
+func WithMaxCallDepth(depth int) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxCallDepth = depth
+	}
+}
+
+func WithMaxMemoryPages(pages uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxMemoryPages = pages
+	}
+}
+
+func WithMaxTableSize(size uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxTableSize = size
+	}
+}
+
+// WithMaxInstances limits the number of instances created by a Store, it has no effect on NewVM
+func WithMaxInstances(n int) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxInstances = n
+	}
+}
+
+// WithFeatures sets enabled proposals
+func WithFeatures(features Features) VMOption {
+	return func(vm *VM) {
+		vm.config.Features = features
+	}
+}
+
+func WithMaxFunctions(n uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxFunctions = n
+	}
+}
+
+func WithMaxLocals(n uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxLocals = n
+	}
+}
+
+func WithMaxFunctionSize(size uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxFunctionSize = size
+	}
+}
+
+func WithMaxDataSize(size uint32) VMOption {
+	return func(vm *VM) {
+		vm.config.MaxDataSize = size
+	}
+}
+
+// ConfigOf returns configuration resulting from applying opts to DefaultRuntimeConfig,
+// modules are decoded with it
+func ConfigOf(opts ...VMOption) RuntimeConfig {
+	probe := &VM{config: DefaultRuntimeConfig()}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	return probe.config
+}
+
+// Config returns configuration of the instance
+func (vm *VM) Config() RuntimeConfig {
+	return vm.config
+}
+
+// valueTypeFeatures returns proposals introducing value type t
+func valueTypeFeatures(t types.ValueType) Features {
+	switch t {
+	case types.ValueTypeVector:
+		return FeatureSIMD
+	case types.ValueTypeFuncRef, types.ValueTypeExternRef:
+		return FeatureReferenceTypes
+	}
+	return 0
+}
+
+// features returns proposals relied on by declarations of the module, function bodies
+// are checked once validated
+func (m *Module) features() Features {
+	var f Features
+	if m.TypesSection != nil {
+		for _, sig := range m.TypesSection.sigs {
+			if len(sig.Results) > 1 {
+				f |= FeatureMultiValue
+			}
+			for _, ts := range [][]types.ValueType{sig.Params, sig.Results} {
+				for _, t := range ts {
+					f |= valueTypeFeatures(t)
+				}
+			}
+		}
+	}
+
+	var tables []*Table
+	var memories []ResizableLimits
+	var globals []GlobalKindDesc
+	var imports []*ImportEntry
+	if m.ImportSection != nil {
+		imports = m.ImportSection.Entries
+	}
+	for _, entry := range imports {
+		switch desc := entry.Description.(type) {
+		case *TableKindDesc:
+			tables = append(tables, &desc.Table)
+		case *MemoryKindDesc:
+			memories = append(memories, desc.Limits)
+		case *GlobalKindDesc:
+			globals = append(globals, *desc)
+		}
+	}
+	if m.TableSection != nil {
+		tables = append(tables, m.TableSection.Entries...)
+	}
+	if m.MemorySection != nil {
+		for _, entry := range m.MemorySection.Entries {
+			memories = append(memories, entry.Limits)
+		}
+	}
+	if m.GlobalSection != nil {
+		for _, entry := range m.GlobalSection.Entries {
+			globals = append(globals, entry.Description)
+			if len(entry.Init) > 0 && (entry.Init[0] == refNull || entry.Init[0] == refFunc) {
+				f |= FeatureReferenceTypes
+			}
+		}
+	}
+
+	if len(tables) > 1 {
+		f |= FeatureReferenceTypes
+	}
+	for _, table := range tables {
+		if table.ElemType != FuncRefElementType {
+			f |= FeatureReferenceTypes
+		}
+	}
+	for _, limits := range memories {
+		// shared memory
+		if limits.Flags&0x2 != 0 {
+			f |= FeatureThreads
+		}
+	}
+	for _, global := range globals {
+		f |= valueTypeFeatures(global.Type)
+	}
+	return f
+}
+
+// checkLimits makes sure memories and tables defined by the module fit limits of the instance
+func (vm *VM) checkLimits() error {
+	m := vm.module
+	if m.MemorySection != nil {
+		for _, entry := range m.MemorySection.Entries {
+			if entry.Limits.Minimum > vm.config.MaxMemoryPages {
+				return LimitError{What: "memory pages", Value: uint64(entry.Limits.Minimum), Limit: uint64(vm.config.MaxMemoryPages), Unit: "pages"}
+			}
+		}
+	}
+	if m.TableSection != nil {
+		for _, entry := range m.TableSection.Entries {
+			if entry.Limits.Minimum > vm.config.MaxTableSize {
+				return LimitError{What: "table elements", Value: uint64(entry.Limits.Minimum), Limit: uint64(vm.config.MaxTableSize), Unit: "elements"}
+			}
+		}
+	}
+	return nil
+}
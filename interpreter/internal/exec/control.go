@@ -1,292 +1,107 @@
 package exec
 
 import (
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
+	"errors"
+
 	"github.com/threadedstream/wasmexperiments/internal/types"
-	"math"
 )
 
 var (
-	callOp   = newOp("call", 0x10, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	ifOp     = newOp("if", 0x04, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	elseOp   = newOp("else", 0x05, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	endOp    = newOp("end", 0x0B, types.ValueTypeVoid, types.ValueTypeVoid)
-	returnOp = newOp("return", 0x0F, types.ValueTypeVoid, types.ValueTypeVoid)
-	blockOp  = newOp("block", 0x02, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	brOp     = newOp("br", 0x0C, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	brIfOp   = newOp("br_if", 0x0D, types.ValueTypeSingleI32, types.ValueTypeVoid)
-	loopOp   = newOp("loop", 0x03, types.ValueTypeSingleI32, types.ValueTypeVoid)
+	unreachableOp  = newOp("unreachable", 0x00, types.ValueTypeVoid, types.ValueTypeVoid)
+	nopOp          = newOp("nop", 0x01, types.ValueTypeVoid, types.ValueTypeVoid)
+	blockOp        = newOp("block", 0x02, types.ValueTypeVoid, types.ValueTypeVoid)
+	loopOp         = newOp("loop", 0x03, types.ValueTypeVoid, types.ValueTypeVoid)
+	ifOp           = newOp("if", 0x04, types.ValueTypeSingleI32, types.ValueTypeVoid)
+	elseOp         = newOp("else", 0x05, types.ValueTypeVoid, types.ValueTypeVoid)
+	endOp          = newOp("end", 0x0B, types.ValueTypeVoid, types.ValueTypeVoid)
+	brOp           = newOp("br", 0x0C, types.ValueTypeVoid, types.ValueTypeVoid)
+	brIfOp         = newOp("br_if", 0x0D, types.ValueTypeSingleI32, types.ValueTypeVoid)
+	brTableOp      = newOp("br_table", 0x0E, types.ValueTypeSingleI32, types.ValueTypeVoid)
+	returnOp       = newOp("return", 0x0F, types.ValueTypeVoid, types.ValueTypeVoid)
+	callOp         = newVarargOp("call", 0x10)
+	callIndirectOp = newVarargOp("call_indirect", 0x11)
+	dropOp         = newVarargOp("drop", 0x1A)
+	selectOp       = newVarargOp("select", 0x1B)
+	// select with explicit type of its operands, references can only be selected this way
+	selectTypedOp = newVarargOp("select", 0x1C)
 )
 
-func (vm *VM) execBlock() {
-	var retVal any
-	in := vm.currIns().(*BlockI)
-	newCtx := &context{
-		parent:  vm.ctx,
-		stack:   make([]uint64, 0, maxDepth),
-		locals:  vm.ctx.locals,
-		raw:     vm.ctx.raw,
-		ins:     in.body,
-		curFunc: vm.ctx.curFunc,
-		isBlock: true,
-	}
-	vm.ctx.pc++
-	vm.ctx = newCtx
-	vm.ctxchain = append(vm.ctxchain, newCtx)
-	retVal = vm.execCode()
-	switch bt := in.blockType.(type) {
-	case types.EmptyBlockType:
-		break
-	case types.ResultBlockType:
-		v := retVal.(int64)
-		switch bt.Ty {
-		case types.ValueTypeI32:
-			vm.pushInt32(int32(v))
-		case types.ValueTypeI64:
-			vm.pushInt64(v)
-		case types.ValueTypeF32:
-			// not sure if that's going to work with floats, though
-			vm.pushFloat32(math.Float32frombits(uint32(v)))
-		case types.ValueTypeF64:
-			vm.pushFloat64(math.Float64frombits(uint64(v)))
-		}
-	case types.OtherBlockType:
-		reporter.ReportError("unknown result type with value %d", bt.X)
-	}
-
-	if newCtx.breakExecuted || vm.returned {
-		return
-	}
+var (
+	ErrUnreachable = errors.New("exec: unreachable executed")
+)
 
+func (vm *VM) unreachable() {
+	panic(ErrUnreachable)
 }
 
-func (vm *VM) execBr() {
-	in := vm.currIns().(*BrI)
-	if in.context == "block" {
-		vm.ctx.breakExecuted = true
-		var temp uint64
-		if len(vm.ctx.stack) > 0 {
-			temp = vm.popUint64()
-		}
-		off := in.arg0.(uint32)
-		neededCtx := vm.ctx
-		idx := uint32(0)
-		for idx < off {
-			neededCtx = neededCtx.parent
-			idx++
-		}
-		vm.ctx = neededCtx.parent
-		vm.pushUint64(temp)
-	} else if in.context == "loop" {
-		off := in.arg0.(uint32)
-		neededCtx := vm.ctx
-		idx := uint32(0)
-		for idx < off {
-			neededCtx = neededCtx.parent
-			idx++
-		}
+func (vm *VM) nop() {}
 
-		vm.ctx.breakExecuted = true
-		vm.ctx.pendingContext = neededCtx
-		if neededCtx.isBlock {
-			neededCtx.breakExecuted = true
-		}
-		neededCtx.pc = 0
+// branch transfers control to a label: values in between stack height of the label
+// and the values carried over by the branch are discarded
+func (vm *VM) branch(in *CompiledIns) {
+	vm.unwind(int(in.arg1), int(in.arg2))
+	if int(in.arg0) < vm.ctx.pc {
+		// back-edge of a loop
+		vm.checkInterrupt()
 	}
-	vm.ctx.pc++
+	vm.ctx.pc = int(in.arg0)
 }
 
-func (vm *VM) execBrBlock() {
-
+// unwind keeps n topmost values at height, which is relative to the operand stack of the frame
+func (vm *VM) unwind(height, n int) {
+	height += vm.ctx.base
+	copy(vm.stack[height:], vm.stack[vm.sp-n:vm.sp])
+	vm.sp = height + n
 }
 
-func (vm *VM) execBrLoop() {
-	in := vm.currIns().(*BrI)
-	// specify location to jump to and start executing this thing over again
-	id := in.arg0.(uint32)
-	idx := len(vm.ctxchain) - int(id) - 1
-	neededCtx := vm.ctxchain[idx]
-	if neededCtx.isBlock {
-		neededCtx.pc = int64(len(neededCtx.ins))
-		vm.ctxchain = vm.ctxchain[:idx]
-		vm.ctx = vm.ctxchain[len(vm.ctxchain)-1]
-	} else {
-		vm.ctxchain = vm.ctxchain[:idx+1]
-		vm.ctx = vm.ctxchain[len(vm.ctxchain)-1]
-		vm.ctx.pc = 0
-	}
+func (vm *VM) execBr() {
+	vm.branch(vm.currIns())
 }
 
-func (vm *VM) execBrIfBlock() {
-
+func (vm *VM) execBrIf() {
+	in := vm.currIns()
+	if vm.popUint32() != 0 {
+		vm.branch(in)
+	}
 }
 
-func (vm *VM) execBrIfLoop() {
-	var _ any
-	in := vm.currIns().(*BrIfI)
-	val := vm.popUint32()
-	if val > 0 {
-		// specify location to jump to and start executing this thing over again
-		id := in.arg0.(uint32)
-		idx := len(vm.ctxchain) - int(id) - 1
-		neededCtx := vm.ctxchain[idx]
-		if neededCtx.isBlock {
-			neededCtx.pc = int64(len(neededCtx.ins))
-			vm.ctxchain = vm.ctxchain[:idx]
-			vm.ctx = vm.ctxchain[len(vm.ctxchain)-1]
-		} else {
-			// neededCtx
-			vm.ctxchain = vm.ctxchain[:idx+1]
-			vm.ctx = vm.ctxchain[len(vm.ctxchain)-1]
-			vm.ctx.pc = 0
-		}
-		// block->loop
-	} else {
-		// just increment pc
-		vm.ctx.pc++
+// execBrTable picks one of the branches following br_table, the last one being the default
+func (vm *VM) execBrTable() {
+	n := vm.currIns().arg0
+	idx := vm.popUint32()
+	if idx > n {
+		idx = n
 	}
+	vm.branch(&vm.ctx.code[vm.ctx.pc+int(idx)])
 }
 
-func (vm *VM) execBrIf() {
-	var _ any
-	in := vm.currIns().(*BrIfI)
-	// decide if we enter "if" body
-	val := vm.popUint32()
+func (vm *VM) jmp() {
+	vm.ctx.pc = int(vm.currIns().arg0)
+}
 
-	if val > 0 {
-		if in.context == "block" {
-			vm.ctx.breakExecuted = true
-			var temp uint64
-			if len(vm.ctx.stack) > 0 {
-				temp = vm.popUint64()
-			}
-			off := in.arg0.(uint32)
-			neededCtx := vm.ctx
-			idx := uint32(0)
-			for idx < off {
-				neededCtx = neededCtx.parent
-				idx++
-			}
-			// TODO(threadedstream): make use of pendingCtx
-			vm.ctx = neededCtx.parent
-			vm.pushUint64(temp)
-		} else if in.context == "loop" {
-			off := in.arg0.(uint32)
-			neededCtx := vm.ctx
-			idx := uint32(0)
-			for idx < off {
-				neededCtx = neededCtx.parent
-				idx++
-			}
-			vm.ctx.breakExecuted = true
-			vm.ctx.pendingContext = neededCtx
-			if neededCtx.isBlock {
-				neededCtx.breakExecuted = true
-			}
-		}
+// jmpIfNot is what if compiles to, no unwinding is needed when entering else branch
+func (vm *VM) jmpIfNot() {
+	in := vm.currIns()
+	if vm.popUint32() == 0 {
+		vm.ctx.pc = int(in.arg0)
 	}
-	vm.ctx.pc++
 }
 
-func (vm *VM) execLoop() {
-	var retVal any
-	in := vm.currIns().(*LoopI)
-	newCtx := &context{
-		parent:  vm.ctx,
-		stack:   make([]uint64, 0, maxDepth),
-		locals:  vm.ctx.locals,
-		raw:     vm.ctx.raw,
-		ins:     in.body,
-		curFunc: vm.ctx.curFunc,
-	}
-	vm.ctx.pc++
-	vm.ctx = newCtx
-	vm.ctxchain = append(vm.ctxchain, newCtx)
-	retVal = vm.execCode()
-	switch bt := in.blockType.(type) {
-	case types.EmptyBlockType:
-		break
-	case types.ResultBlockType:
-		v := retVal.(int64)
-		switch bt.Ty {
-		case types.ValueTypeI32:
-			vm.pushInt32(int32(v))
-		case types.ValueTypeI64:
-			vm.pushInt64(v)
-		case types.ValueTypeF32:
-			// not sure if that's going to work with floats, though
-			vm.pushFloat32(math.Float32frombits(uint32(v)))
-		case types.ValueTypeF64:
-			vm.pushFloat64(math.Float64frombits(uint64(v)))
-		}
-	case types.OtherBlockType:
-		reporter.ReportError("unknown result type with value %d", bt.X)
-	}
+func (vm *VM) ret() {
+	vm.ctx.pc = len(vm.ctx.code)
+}
 
-	if newCtx.breakExecuted || vm.returned {
-		return
-	}
+func (vm *VM) drop() {
+	vm.popUint64()
 }
 
-func (vm *VM) execIf() {
-	var retVal any
-	in := vm.currIns().(*IfI)
-	// decide if we enter "if" body
-	val := vm.popUint32()
-	if val > 0 {
-		newCtx := &context{
-			stack:   make([]uint64, 0, maxDepth),
-			locals:  vm.ctx.locals,
-			raw:     vm.ctx.raw,
-			ins:     in.body,
-			curFunc: vm.ctx.curFunc,
-		}
-		vm.frames = append(vm.frames, newCtx)
-		vm.ctx = newCtx
-		retVal = vm.execCode()
-		vm.frames = vm.frames[:len(vm.frames)-1]
-		// blindly accept that vm.frames always has at least one frame at this point
-		vm.ctx = vm.frames[len(vm.frames)-1]
+func (vm *VM) sel() {
+	cond := vm.popUint32()
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if cond != 0 {
+		vm.pushUint64(lhs)
 	} else {
-		if in.elseBody != nil {
-			newCtx := &context{
-				stack:   make([]uint64, 0, maxDepth),
-				locals:  vm.ctx.locals,
-				raw:     vm.ctx.raw,
-				ins:     in.elseBody,
-				curFunc: vm.ctx.curFunc,
-			}
-			vm.frames = append(vm.frames, newCtx)
-			vm.ctx = newCtx
-			retVal = vm.execCode()
-			vm.frames = vm.frames[:len(vm.frames)-1]
-			// blindly accept that vm.frames always has at least one frame at this point
-			vm.ctx = vm.frames[len(vm.frames)-1]
-		}
-	}
-
-	switch bt := in.blockType.(type) {
-	case types.EmptyBlockType:
-		return
-	case types.ResultBlockType:
-		v := retVal.(int64)
-		switch bt.Ty {
-		case types.ValueTypeI32:
-			vm.pushInt32(int32(v))
-		case types.ValueTypeI64:
-			vm.pushInt64(v)
-		case types.ValueTypeF32:
-			// not sure if that's going to work with floats, though
-			vm.pushFloat32(math.Float32frombits(uint32(v)))
-		case types.ValueTypeF64:
-			vm.pushFloat64(math.Float64frombits(uint64(v)))
-		}
-	case types.OtherBlockType:
-		reporter.ReportError("unknown result type with value %d", bt.X)
+		vm.pushUint64(rhs)
 	}
-	vm.ctx.pc++
-}
-
-func (vm *VM) ret() {
-	vm.returned = true
 }
@@ -2,12 +2,13 @@ package exec
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
+	"github.com/threadedstream/wasmexperiments/internal/types"
 	"io"
+	"math"
 )
 
 var (
@@ -120,20 +121,73 @@ func disIf(reader *wasm_reader.WasmReader) (*IfI, error) {
 	return in, nil
 }
 
+func disBrTable(reader *wasm_reader.WasmReader) (*BrTableI, error) {
+	in := new(BrTableI)
+	in.commonI = commonI{op: lookupOp(brTableOp)}
+	count, err := readCount(reader, "br_table labels")
+	if err != nil {
+		return nil, err
+	}
+	in.labels = make([]uint32, 0, count)
+	for i := uint32(0); i < count; i++ {
+		label, err := wbinary.ReadVarUint32(reader)
+		if err != nil {
+			return nil, err
+		}
+		in.labels = append(in.labels, label)
+	}
+	if in.defaultLabel, err = wbinary.ReadVarUint32(reader); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// readValueTypes decodes the vector of value types of typed select, which holds a single type
+func readValueTypes(reader *wasm_reader.WasmReader) ([]types.ValueType, error) {
+	count, err := wbinary.ReadVarUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("decodeIns: typed select expects a single value type, got %d", count)
+	}
+	ts := make([]types.ValueType, count)
+	for i := range ts {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		ts[i] = types.ValueType(b)
+	}
+	return ts, nil
+}
+
 func decodeIns(op Op, reader *wasm_reader.WasmReader, context string) (Instr, error) {
 	switch op.Code {
 	default:
-		return nil, fmt.Errorf("decodeIns: unknown instruction with opcode %v", op.Code)
-	case i32AddOp, i32SubOp, i32MulOp, i32DivUOp, i32DivSOp:
-		// we ain't got any operand stack yet
-		return newDoubleArgI(op, nil, nil), nil
-	case globalGetOp, localGetOp, localSetOp, globalSetOp, callOp:
+		return newNoArgI(op), nil
+	case globalGetOp, localGetOp, localSetOp, localTeeOp, globalSetOp,
+		callOp, brOp, brIfOp, tableGetOp, tableSetOp, refFuncOp:
 		index, e := wbinary.ReadVarUint32(reader)
 		if e != nil {
 			return nil, e
 		}
 		return newSingleArgI(op, index), nil
-	case i32LoadOp, i32StoreOp:
+	case callIndirectOp:
+		typeIndex, err := wbinary.ReadVarUint32(reader)
+		if err != nil {
+			return nil, err
+		}
+		tableIndex, err := wbinary.ReadVarUint32(reader)
+		if err != nil {
+			return nil, err
+		}
+		return newDoubleArgI(op, typeIndex, tableIndex), nil
+	case brTableOp:
+		return disBrTable(reader)
+	case i32LoadOp, i64LoadOp, f32LoadOp, f64LoadOp, i32Load8SOp, i32Load8UOp, i32Load16SOp, i32Load16UOp,
+		i64Load8SOp, i64Load8UOp, i64Load16SOp, i64Load16UOp, i64Load32SOp, i64Load32UOp,
+		i32StoreOp, i64StoreOp, f32StoreOp, f64StoreOp, i32Store8Op, i32Store16Op, i64Store8Op, i64Store16Op, i64Store32Op:
 		var align, off uint32
 		align, err := wbinary.ReadVarUint32(reader)
 		if err != nil {
@@ -144,12 +198,53 @@ func decodeIns(op Op, reader *wasm_reader.WasmReader, context string) (Instr, er
 			return nil, err
 		}
 		return newDoubleArgI(op, align, off), nil
+	case miscPrefix:
+		return readMiscOp(reader)
+	case refNullOp:
+		t, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return newSingleArgI(op, types.ValueType(t)), nil
+	case selectTypedOp:
+		ts, err := readValueTypes(reader)
+		if err != nil {
+			return nil, err
+		}
+		return newSingleArgI(op, ts), nil
+	case memorySizeOp, memoryGrowOp:
+		reserved, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if reserved != 0 {
+			return nil, fmt.Errorf("decodeIns: %s expects zero memory index", op.Name)
+		}
+		return newNoArgI(op), nil
 	case i32ConstOp:
-		imm, e := wbinary.ReadVarUint32(reader)
+		imm, e := wbinary.ReadVarInt32(reader)
 		if e != nil {
 			return nil, e
 		}
 		return newSingleArgI(op, imm), nil
+	case i64ConstOp:
+		imm, e := wbinary.ReadVarInt64(reader)
+		if e != nil {
+			return nil, e
+		}
+		return newSingleArgI(op, imm), nil
+	case f32ConstOp:
+		imm, e := wbinary.ReadU32(reader)
+		if e != nil {
+			return nil, e
+		}
+		return newSingleArgI(op, math.Float32frombits(imm)), nil
+	case f64ConstOp:
+		imm, e := wbinary.ReadU64(reader)
+		if e != nil {
+			return nil, e
+		}
+		return newSingleArgI(op, math.Float64frombits(imm)), nil
 	case ifOp:
 		ifI, err := disIf(reader)
 		if err != nil {
@@ -168,22 +263,6 @@ func decodeIns(op Op, reader *wasm_reader.WasmReader, context string) (Instr, er
 			return nil, err
 		}
 		return loopI, nil
-	case brOp:
-		imm, e := wbinary.ReadVarUint32(reader)
-		if e != nil {
-			return nil, e
-		}
-		brI := newSingleArgI(op, imm).(*BrI)
-		brI.context = context
-		return brI, nil
-	case brIfOp:
-		imm, e := wbinary.ReadVarUint32(reader)
-		if e != nil {
-			return nil, e
-		}
-		brIfI := newSingleArgI(op, imm).(*BrIfI)
-		brIfI.context = context
-		return brIfI, nil
 	case elseOp:
 		// check if else is inside if
 		if context != "if" {
@@ -191,37 +270,5 @@ func decodeIns(op Op, reader *wasm_reader.WasmReader, context string) (Instr, er
 		}
 		// don't do anything with it, just return both nils
 		return nil, nil
-	case endOp, i32EqOp, returnOp, i32LtSOp:
-		return newNoArgI(op), nil
 	}
 }
-
-// Compile is a reverse of Disassemble
-func Compile(is []Instr) ([]byte, error) {
-	binaryFormat := binary.LittleEndian
-	// let the implementation allocate bytes on demand
-	byteStream := bytes.NewBuffer(nil)
-	for _, i := range is {
-		switch code := i.Op().Code; code {
-		case localGetOp, globalGetOp, callOp, i32ConstOp:
-			ins := i.(*singleArgI)
-			// does allocation happen if I use b[:]?
-			byteStream.WriteByte(byte(code))
-			var b [4]byte
-			binaryFormat.PutUint32(b[:], ins.arg0.(uint32))
-			byteStream.Write(b[:])
-		case i32AddOp, i32SubOp, i32MulOp, i32DivUOp, i32DivSOp, i32EqOp:
-			byteStream.WriteByte(byte(code))
-		case i32LoadOp, i32StoreOp:
-			ins := i.(*doubleArgI)
-			byteStream.WriteByte(byte(code))
-			var b [8]byte
-			binaryFormat.PutUint32(b[0:4], ins.arg0.(uint32))
-			binaryFormat.PutUint32(b[4:8], ins.arg1.(uint32))
-			byteStream.Write(b[:])
-		case endOp:
-			byteStream.WriteByte(byte(endOp))
-		}
-	}
-	return byteStream.Bytes(), nil
-}
@@ -0,0 +1,79 @@
+package exec
+
+import "fmt"
+
+// indexExports builds the export index of the instance checking that exports refer to
+// existing objects of their kind
+func (vm *VM) indexExports() error {
+	m := vm.module
+	if m.ExportSection == nil {
+		return nil
+	}
+	for _, entry := range m.ExportSection.Entries {
+		var size int
+		switch entry.Kind {
+		case FunctionKind:
+			size = len(vm.funcs)
+		case TableKind:
+			size = len(vm.tables)
+		case MemoryKind:
+			if vm.memory != nil {
+				size = 1
+			}
+		case GlobalKind:
+			size = len(vm.globals)
+		default:
+			return fmt.Errorf("exec: export %s has unknown kind %d", entry.Name, entry.Kind)
+		}
+		if int(entry.Index) >= size {
+			return fmt.Errorf("exec: export %s refers to unknown %v %d", entry.Name, entry.Kind, entry.Index)
+		}
+		if vm.exports[entry.Kind] == nil {
+			vm.exports[entry.Kind] = make(map[string]uint32)
+		}
+		vm.exports[entry.Kind][entry.Name] = entry.Index
+	}
+	return nil
+}
+
+// Export returns index of the export of the given kind
+func (vm *VM) Export(kind ExternalKind, name string) (uint32, bool) {
+	if kind < 0 || kind >= numExternalKinds {
+		return 0, false
+	}
+	index, ok := vm.exports[kind][name]
+	return index, ok
+}
+
+func (vm *VM) ExportedMemory(name string) (*MemoryInstance, bool) {
+	if _, ok := vm.Export(MemoryKind, name); !ok {
+		return nil, false
+	}
+	return vm.memory, true
+}
+
+func (vm *VM) ExportedTable(name string) (*TableInstance, bool) {
+	index, ok := vm.Export(TableKind, name)
+	if !ok {
+		return nil, false
+	}
+	return vm.tables[index], true
+}
+
+func (vm *VM) ExportedGlobal(name string) (*GlobalInstance, bool) {
+	index, ok := vm.Export(GlobalKind, name)
+	if !ok {
+		return nil, false
+	}
+	return vm.globals[index], true
+}
+
+// ExportedFunction returns reference to function exported under name, which can be
+// stored in tables or called by host
+func (vm *VM) ExportedFunction(name string) (*FuncRef, bool) {
+	index, ok := vm.Export(FunctionKind, name)
+	if !ok {
+		return nil, false
+	}
+	return vm.funcRef(index), true
+}
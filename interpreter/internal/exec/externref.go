@@ -0,0 +1,147 @@
+package exec
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+const (
+	// handles of external references carry externRefTag in their upper bits, which makes them
+	// unlikely to be confused with numbers while the operand stack is scanned for live handles
+	externRefTag  = uint64(0x7ef0) << 48
+	externRefMask = uint64(0xffff) << 48
+	// number of handles an instance may hold before the first collection
+	minExternRefs = 64
+	// limit of handles an instance may hold at once
+	maxExternRefs = 1 << 20
+)
+
+// ErrExternRefsExhausted is a trap raised when an instance holds too many handles of external references
+var ErrExternRefsExhausted = errors.New("exec: external references exhausted")
+
+// externRefs maps handles of external references passed to an instance onto host values.
+// Handles are only held by the operand stack, so the ones missing from it are released
+// by collect, globals and tables hold host values themselves
+type externRefs struct {
+	values []any
+	used   []bool
+	// handles of comparable values, so that a value gets the same handle while it's in use
+	handles map[any]uint32
+	free    []uint32
+	live    int
+	// number of live handles triggering the next collection
+	next int
+}
+
+// ExternRef returns handle of host value v to be passed to the instance as externref, nil
+// becomes null reference. The handle stays valid as long as it's reachable from the operand
+// stack of the instance or is among the results of the last call. Comparable values such as
+// pointers get the same handle each time, handing out more than maxExternRefs distinct
+// handles traps with ErrExternRefsExhausted
+func (vm *VM) ExternRef(v any) uint64 {
+	if v == nil {
+		return 0
+	}
+	refs := &vm.externs
+	key := hashable(v)
+	if key {
+		if index, ok := refs.handles[v]; ok {
+			return externRefTag | uint64(index)
+		}
+	}
+	if refs.live >= maxExternRefs {
+		panic(ErrExternRefsExhausted)
+	}
+	var index uint32
+	if n := len(refs.free); n > 0 {
+		index, refs.free = refs.free[n-1], refs.free[:n-1]
+		refs.values[index], refs.used[index] = v, true
+	} else {
+		index = uint32(len(refs.values))
+		refs.values, refs.used = append(refs.values, v), append(refs.used, true)
+	}
+	refs.live++
+	if key {
+		if refs.handles == nil {
+			refs.handles = make(map[any]uint32)
+		}
+		refs.handles[v] = index
+	}
+	return externRefTag | uint64(index)
+}
+
+// hashable reports whether v can be used as a key of a map without panicking
+func hashable(v any) bool {
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer, reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// ExternValue returns host value referred to by handle, null reference results in nil
+func (vm *VM) ExternValue(handle uint64) (any, bool) {
+	if handle == 0 {
+		return nil, true
+	}
+	index, ok := vm.externs.index(handle)
+	if !ok {
+		return nil, false
+	}
+	return vm.externs.values[index], true
+}
+
+func (refs *externRefs) index(handle uint64) (uint64, bool) {
+	if handle&externRefMask != externRefTag {
+		return 0, false
+	}
+	index := handle &^ externRefMask
+	if index >= uint64(len(refs.values)) || !refs.used[index] {
+		return 0, false
+	}
+	return index, true
+}
+
+// collectExternRefs releases handles unreachable from the operand stack once there are
+// enough of them, it's only called when all handles in use are on the stack
+func (vm *VM) collectExternRefs() {
+	refs := &vm.externs
+	if refs.live < refs.next || refs.live < minExternRefs {
+		return
+	}
+	marked := make([]bool, len(refs.values))
+	for _, roots := range [][]uint64{vm.stack[:vm.sp], vm.results} {
+		for _, v := range roots {
+			if index, ok := refs.index(v); ok {
+				marked[index] = true
+			}
+		}
+	}
+	for i, used := range refs.used {
+		if used && !marked[i] {
+			if hashable(refs.values[i]) {
+				delete(refs.handles, refs.values[i])
+			}
+			refs.values[i], refs.used[i] = nil, false
+			refs.free = append(refs.free, uint32(i))
+			refs.live--
+		}
+	}
+	refs.next = 2 * refs.live
+}
+
+// translateExternRefs replaces handles of from with handles of to in values of types typs,
+// function references included
+func translateExternRefs(from, to *VM, typs []types.ValueType, values []uint64) {
+	for i, typ := range typs {
+		if isReference(typ) {
+			v, _ := from.ExternValue(values[i])
+			values[i] = to.ExternRef(v)
+		}
+	}
+}
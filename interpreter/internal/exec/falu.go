@@ -1,100 +1,161 @@
 package exec
 
 import (
-	"github.com/threadedstream/wasmexperiments/internal/types"
+	"errors"
 	"math"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
 )
 
 var (
-	f32AddOp = newOp("f32.add", 0x92, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
-	f32SubOp = newOp("f32.sub", 0x93, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
-	f32MulOp = newOp("f32.mul", 0x94, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32ConstOp          = newOp("f32.const", 0x43, types.ValueTypeVoid, types.ValueTypeSingleF32)
+	f64ConstOp          = newOp("f64.const", 0x44, types.ValueTypeVoid, types.ValueTypeSingleF64)
+	f32EqOp             = newOp("f32.eq", 0x5B, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f32NeOp             = newOp("f32.ne", 0x5C, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f32LtOp             = newOp("f32.lt", 0x5D, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f32GtOp             = newOp("f32.gt", 0x5E, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f32LeOp             = newOp("f32.le", 0x5F, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f32GeOp             = newOp("f32.ge", 0x60, types.ValueTypeDoubleF32, types.ValueTypeSingleI32)
+	f64EqOp             = newOp("f64.eq", 0x61, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f64NeOp             = newOp("f64.ne", 0x62, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f64LtOp             = newOp("f64.lt", 0x63, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f64GtOp             = newOp("f64.gt", 0x64, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f64LeOp             = newOp("f64.le", 0x65, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f64GeOp             = newOp("f64.ge", 0x66, types.ValueTypeDoubleF64, types.ValueTypeSingleI32)
+	f32AbsOp            = newOp("f32.abs", 0x8B, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32NegOp            = newOp("f32.neg", 0x8C, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32CeilOp           = newOp("f32.ceil", 0x8D, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32FloorOp          = newOp("f32.floor", 0x8E, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32TruncOp          = newOp("f32.trunc", 0x8F, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32NearestOp        = newOp("f32.nearest", 0x90, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32SqrtOp           = newOp("f32.sqrt", 0x91, types.ValueTypeSingleF32, types.ValueTypeSingleF32)
+	f32AddOp            = newOp("f32.add", 0x92, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32SubOp            = newOp("f32.sub", 0x93, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32MulOp            = newOp("f32.mul", 0x94, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32DivOp            = newOp("f32.div", 0x95, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32MinOp            = newOp("f32.min", 0x96, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32MaxOp            = newOp("f32.max", 0x97, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f32CopysignOp       = newOp("f32.copysign", 0x98, types.ValueTypeDoubleF32, types.ValueTypeSingleF32)
+	f64AbsOp            = newOp("f64.abs", 0x99, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64NegOp            = newOp("f64.neg", 0x9A, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64CeilOp           = newOp("f64.ceil", 0x9B, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64FloorOp          = newOp("f64.floor", 0x9C, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64TruncOp          = newOp("f64.trunc", 0x9D, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64NearestOp        = newOp("f64.nearest", 0x9E, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64SqrtOp           = newOp("f64.sqrt", 0x9F, types.ValueTypeSingleF64, types.ValueTypeSingleF64)
+	f64AddOp            = newOp("f64.add", 0xA0, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64SubOp            = newOp("f64.sub", 0xA1, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64MulOp            = newOp("f64.mul", 0xA2, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64DivOp            = newOp("f64.div", 0xA3, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64MinOp            = newOp("f64.min", 0xA4, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64MaxOp            = newOp("f64.max", 0xA5, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	f64CopysignOp       = newOp("f64.copysign", 0xA6, types.ValueTypeDoubleF64, types.ValueTypeSingleF64)
+	i32TruncF32SOp      = newOp("i32.trunc_f32_s", 0xA8, types.ValueTypeSingleF32, types.ValueTypeSingleI32)
+	i32TruncF32UOp      = newOp("i32.trunc_f32_u", 0xA9, types.ValueTypeSingleF32, types.ValueTypeSingleI32)
+	i32TruncF64SOp      = newOp("i32.trunc_f64_s", 0xAA, types.ValueTypeSingleF64, types.ValueTypeSingleI32)
+	i32TruncF64UOp      = newOp("i32.trunc_f64_u", 0xAB, types.ValueTypeSingleF64, types.ValueTypeSingleI32)
+	i64TruncF32SOp      = newOp("i64.trunc_f32_s", 0xAE, types.ValueTypeSingleF32, types.ValueTypeSingleI64)
+	i64TruncF32UOp      = newOp("i64.trunc_f32_u", 0xAF, types.ValueTypeSingleF32, types.ValueTypeSingleI64)
+	i64TruncF64SOp      = newOp("i64.trunc_f64_s", 0xB0, types.ValueTypeSingleF64, types.ValueTypeSingleI64)
+	i64TruncF64UOp      = newOp("i64.trunc_f64_u", 0xB1, types.ValueTypeSingleF64, types.ValueTypeSingleI64)
+	f32ConvertI32SOp    = newOp("f32.convert_i32_s", 0xB2, types.ValueTypeSingleI32, types.ValueTypeSingleF32)
+	f32ConvertI32UOp    = newOp("f32.convert_i32_u", 0xB3, types.ValueTypeSingleI32, types.ValueTypeSingleF32)
+	f32ConvertI64SOp    = newOp("f32.convert_i64_s", 0xB4, types.ValueTypeSingleI64, types.ValueTypeSingleF32)
+	f32ConvertI64UOp    = newOp("f32.convert_i64_u", 0xB5, types.ValueTypeSingleI64, types.ValueTypeSingleF32)
+	f32DemoteF64Op      = newOp("f32.demote_f64", 0xB6, types.ValueTypeSingleF64, types.ValueTypeSingleF32)
+	f64ConvertI32SOp    = newOp("f64.convert_i32_s", 0xB7, types.ValueTypeSingleI32, types.ValueTypeSingleF64)
+	f64ConvertI32UOp    = newOp("f64.convert_i32_u", 0xB8, types.ValueTypeSingleI32, types.ValueTypeSingleF64)
+	f64ConvertI64SOp    = newOp("f64.convert_i64_s", 0xB9, types.ValueTypeSingleI64, types.ValueTypeSingleF64)
+	f64ConvertI64UOp    = newOp("f64.convert_i64_u", 0xBA, types.ValueTypeSingleI64, types.ValueTypeSingleF64)
+	f64PromoteF32Op     = newOp("f64.promote_f32", 0xBB, types.ValueTypeSingleF32, types.ValueTypeSingleF64)
+	i32ReinterpretF32Op = newOp("i32.reinterpret_f32", 0xBC, types.ValueTypeSingleF32, types.ValueTypeSingleI32)
+	i64ReinterpretF64Op = newOp("i64.reinterpret_f64", 0xBD, types.ValueTypeSingleF64, types.ValueTypeSingleI64)
+	f32ReinterpretI32Op = newOp("f32.reinterpret_i32", 0xBE, types.ValueTypeSingleI32, types.ValueTypeSingleF32)
+	f64ReinterpretI64Op = newOp("f64.reinterpret_i64", 0xBF, types.ValueTypeSingleI64, types.ValueTypeSingleF64)
 )
 
-func (vm *VM) f32Add() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(lhs + rhs))
+var (
+	ErrInvalidConversion = errors.New("exec: invalid conversion to integer")
+)
+
+func (vm *VM) f32Abs() {
+	vm.pushUint32(vm.popUint32() &^ 0x80000000)
 }
 
-func (vm *VM) f32Sub() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(lhs - rhs))
+func (vm *VM) f32Neg() {
+	vm.pushUint32(vm.popUint32() ^ 0x80000000)
 }
 
-func (vm *VM) f32Mul() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(lhs * rhs))
+func (vm *VM) f32Ceil() {
+	target := vm.popFloat32()
+	vm.pushFloat32(float32(math.Ceil(float64(target))))
 }
 
-func (vm *VM) f32Div() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(lhs / rhs))
+func (vm *VM) f32Floor() {
+	target := vm.popFloat32()
+	vm.pushFloat32(float32(math.Floor(float64(target))))
 }
 
-func (vm *VM) f32Sqrt() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Sqrt(float64(target))))
+func (vm *VM) f32Trunc() {
+	target := vm.popFloat32()
+	vm.pushFloat32(float32(math.Trunc(float64(target))))
 }
 
-func (vm *VM) f32Min() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Min(float64(lhs), float64(rhs))))
+func (vm *VM) f32Nearest() {
+	target := vm.popFloat32()
+	vm.pushFloat32(float32(math.RoundToEven(float64(target))))
 }
 
-func (vm *VM) f32Max() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Max(float64(lhs), float64(rhs))))
+func (vm *VM) f32Sqrt() {
+	target := vm.popFloat32()
+	vm.pushFloat32(float32(math.Sqrt(float64(target))))
 }
 
-func (vm *VM) f32Ceil() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Ceil(float64(target))))
+func (vm *VM) f32Add() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(lhs + rhs)
 }
 
-func (vm *VM) f32Floor() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Floor(float64(target))))
+func (vm *VM) f32Sub() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(lhs - rhs)
 }
 
-func (vm *VM) f32Trunc() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Trunc(float64(target))))
+func (vm *VM) f32Mul() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(lhs * rhs)
 }
 
-func (vm *VM) f32Nearest() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Round(float64(target))))
+func (vm *VM) f32Div() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(lhs / rhs)
 }
 
-func (vm *VM) f32Abs() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Abs(float64(target))))
+func (vm *VM) f32Min() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(float32(math.Min(float64(lhs), float64(rhs))))
 }
 
-func (vm *VM) f32Neg() {
-	target := float32(vm.popUint32())
-	if math.Signbit(float64(target)) {
-		vm.pushUint32(uint32(math.Copysign(float64(target), -1)))
-	} else {
-		vm.pushUint32(uint32(math.Copysign(float64(target), 1)))
-	}
+func (vm *VM) f32Max() {
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
+	vm.pushFloat32(float32(math.Max(float64(lhs), float64(rhs))))
 }
 
 func (vm *VM) f32Copysign() {
-	sign := float32(vm.popUint32())
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(math.Copysign(float64(target), float64(sign))))
+	sign := vm.popUint32() & 0x80000000
+	target := vm.popUint32() &^ 0x80000000
+	vm.pushUint32(target | sign)
 }
 
 func (vm *VM) f32Eq() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs == rhs {
 		vm.pushOne()
 	} else {
@@ -103,8 +164,8 @@ func (vm *VM) f32Eq() {
 }
 
 func (vm *VM) f32Ne() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs != rhs {
 		vm.pushOne()
 	} else {
@@ -113,8 +174,8 @@ func (vm *VM) f32Ne() {
 }
 
 func (vm *VM) f32Lt() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs < rhs {
 		vm.pushOne()
 	} else {
@@ -123,8 +184,8 @@ func (vm *VM) f32Lt() {
 }
 
 func (vm *VM) f32Le() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs <= rhs {
 		vm.pushOne()
 	} else {
@@ -133,8 +194,8 @@ func (vm *VM) f32Le() {
 }
 
 func (vm *VM) f32Gt() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs > rhs {
 		vm.pushOne()
 	} else {
@@ -143,8 +204,8 @@ func (vm *VM) f32Gt() {
 }
 
 func (vm *VM) f32Ge() {
-	rhs := float32(vm.popUint32())
-	lhs := float32(vm.popUint32())
+	rhs := vm.popFloat32()
+	lhs := vm.popFloat32()
 	if lhs >= rhs {
 		vm.pushOne()
 	} else {
@@ -152,58 +213,224 @@ func (vm *VM) f32Ge() {
 	}
 }
 
-func (vm *VM) i32TruncF32S() {
-	target := float32(vm.popUint32())
-	vm.pushInt32(int32(target))
+func (vm *VM) f64Abs() {
+	vm.pushUint64(vm.popUint64() &^ 0x8000000000000000)
 }
 
-func (vm *VM) i32TruncF64S() {
-	target := float64(vm.popInt64())
-	vm.pushUint32(uint32(float32(target)))
+func (vm *VM) f64Neg() {
+	vm.pushUint64(vm.popUint64() ^ 0x8000000000000000)
+}
+
+func (vm *VM) f64Ceil() {
+	target := vm.popFloat64()
+	vm.pushFloat64(math.Ceil(target))
+}
+
+func (vm *VM) f64Floor() {
+	target := vm.popFloat64()
+	vm.pushFloat64(math.Floor(target))
+}
+
+func (vm *VM) f64Trunc() {
+	target := vm.popFloat64()
+	vm.pushFloat64(math.Trunc(target))
+}
+
+func (vm *VM) f64Nearest() {
+	target := vm.popFloat64()
+	vm.pushFloat64(math.RoundToEven(target))
+}
+
+func (vm *VM) f64Sqrt() {
+	target := vm.popFloat64()
+	vm.pushFloat64(math.Sqrt(target))
+}
+
+func (vm *VM) f64Add() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(lhs + rhs)
+}
+
+func (vm *VM) f64Sub() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(lhs - rhs)
+}
+
+func (vm *VM) f64Mul() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(lhs * rhs)
+}
+
+func (vm *VM) f64Div() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(lhs / rhs)
+}
+
+func (vm *VM) f64Min() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(math.Min(lhs, rhs))
+}
+
+func (vm *VM) f64Max() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	vm.pushFloat64(math.Max(lhs, rhs))
+}
+
+func (vm *VM) f64Copysign() {
+	sign := vm.popUint64() & 0x8000000000000000
+	target := vm.popUint64() &^ 0x8000000000000000
+	vm.pushUint64(target | sign)
+}
+
+func (vm *VM) f64Eq() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs == rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) f64Ne() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs != rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) f64Lt() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs < rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) f64Le() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs <= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) f64Gt() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs > rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) f64Ge() {
+	rhs := vm.popFloat64()
+	lhs := vm.popFloat64()
+	if lhs >= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i32TruncF32S() {
+	vm.pushInt32(int32(truncFloat(float64(vm.popFloat32()), -2147483648, 2147483648, true)))
 }
 
 func (vm *VM) i32TruncF32U() {
-	target := float32(vm.popUint64())
-	vm.pushUint32(uint32(target))
+	vm.pushUint32(uint32(truncFloat(float64(vm.popFloat32()), -1, 4294967296, false)))
+}
+
+func (vm *VM) i32TruncF64S() {
+	vm.pushInt32(int32(truncFloat(vm.popFloat64(), -2147483648, 2147483648, true)))
 }
 
 func (vm *VM) i32TruncF64U() {
-	target := float64(vm.popUint64())
-	vm.pushUint32(uint32(float32(target)))
+	vm.pushUint32(uint32(truncFloat(vm.popFloat64(), -1, 4294967296, false)))
 }
 
-func (vm *VM) f32DemoteF64() {
-	target := float64(vm.popUint64())
-	vm.pushUint32(uint32(float32(target)))
+func (vm *VM) i64TruncF32S() {
+	vm.pushInt64(int64(truncFloat(float64(vm.popFloat32()), -9223372036854775808, 9223372036854775808, true)))
 }
 
-func (vm *VM) f64PromoteF32() {
-	target := float32(vm.popUint32())
-	vm.pushUint64(uint64(target))
+func (vm *VM) i64TruncF32U() {
+	vm.pushUint64(uint64(truncFloat(float64(vm.popFloat32()), -1, 18446744073709551616, false)))
 }
 
-func (vm *VM) f32ConvertI32S() {
-	target := float32(vm.popInt32())
-	vm.pushUint32(uint32(target))
+func (vm *VM) i64TruncF64S() {
+	vm.pushInt64(int64(truncFloat(vm.popFloat64(), -9223372036854775808, 9223372036854775808, true)))
 }
 
-func (vm *VM) f32ConvertI64S() {
-	target := vm.popInt64()
-	vm.pushUint32(uint32(float32(target)))
+func (vm *VM) i64TruncF64U() {
+	vm.pushUint64(uint64(truncFloat(vm.popFloat64(), -1, 18446744073709551616, false)))
+}
+
+// truncFloat truncates x towards zero trapping if the result doesn't fit into [lo, hi)
+func truncFloat(x, lo, hi float64, signed bool) float64 {
+	if math.IsNaN(x) {
+		panic(ErrInvalidConversion)
+	}
+	x = math.Trunc(x)
+	if x >= hi || (signed && x < lo) || (!signed && x <= lo) {
+		panic(ErrIntegerOverflow)
+	}
+	return x
+}
+
+func (vm *VM) f32ConvertI32S() {
+	vm.pushFloat32(float32(vm.popInt32()))
 }
 
 func (vm *VM) f32ConvertI32U() {
-	target := float32(vm.popUint32())
-	vm.pushUint32(uint32(target))
+	vm.pushFloat32(float32(vm.popUint32()))
+}
+
+func (vm *VM) f32ConvertI64S() {
+	vm.pushFloat32(float32(vm.popInt64()))
 }
 
 func (vm *VM) f32ConvertI64U() {
-	target := vm.popUint64()
-	vm.pushUint32(uint32(float32(target)))
+	vm.pushFloat32(float32(vm.popUint64()))
+}
+
+func (vm *VM) f64ConvertI32S() {
+	vm.pushFloat64(float64(vm.popInt32()))
+}
+
+func (vm *VM) f64ConvertI32U() {
+	vm.pushFloat64(float64(vm.popUint32()))
+}
+
+func (vm *VM) f64ConvertI64S() {
+	vm.pushFloat64(float64(vm.popInt64()))
+}
+
+func (vm *VM) f64ConvertI64U() {
+	vm.pushFloat64(float64(vm.popUint64()))
+}
+
+func (vm *VM) f32DemoteF64() {
+	vm.pushFloat32(float32(vm.popFloat64()))
 }
 
-func (vm *VM) f32ReinterpretI32() {
-	target := vm.popUint32()
-	reinterpreted := math.Float32frombits(target)
-	vm.pushUint32(uint32(reinterpreted))
+func (vm *VM) f64PromoteF32() {
+	vm.pushFloat64(float64(vm.popFloat32()))
 }
+
+// reinterpretations are no-ops since values on the stack are kept in their binary representation
+func (vm *VM) reinterpret() {}
@@ -0,0 +1,128 @@
+package exec
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+var ErrOutOfFuel = errors.New("exec: out of fuel")
+
+// CostTable holds fuel consumed by instructions indexed by their opcodes, prefixed
+// instructions are charged by their prefix. Work of some instructions depends on their
+// operands, so they're charged their cost once more per unit of it: memory.grow per page
+// grown, table.grow, table.fill, table.copy, memory.fill and memory.copy per started
+// bulkUnit elements or bytes
+type CostTable [256]uint64
+
+// number of bytes or table elements bulk instructions are charged for at once
+const bulkUnit = 64
+
+// DefaultCostTable charges 1 per instruction, calls, memory growth and bulk instructions
+// are heavier
+func DefaultCostTable() *CostTable {
+	costs := new(CostTable)
+	for i := range costs {
+		costs[i] = 1
+	}
+	costs[callOp] = 5
+	costs[callIndirectOp] = 10
+	costs[memoryGrowOp] = 100
+	// bulk memory and table instructions
+	costs[miscPrefix] = 10
+	return costs
+}
+
+// fuel is the execution budget of an instance, it's only accounted once costs are set
+type fuel struct {
+	remaining uint64
+	costs     *CostTable
+	// asked for more fuel once it's exhausted, execution traps unless it returns some
+	refill func(vm *VM) uint64
+}
+
+// WithFuel enables fuel metering, execution traps with ErrOutOfFuel once fuel is exhausted.
+// Costs of instructions are taken from costs or DefaultCostTable if it's nil. Consumption is
+// deterministic for the given ExecMode, compiled bytecode omits blocks, loops and ends though
+func WithFuel(fuel uint64, costs *CostTable) VMOption {
+	return func(vm *VM) {
+		if costs == nil {
+			costs = DefaultCostTable()
+		}
+		vm.fuel.remaining, vm.fuel.costs = fuel, costs
+	}
+}
+
+// WithFuelRefill makes exhausted fuel refilled with the amount returned by refill, which
+// is called right where execution stops, so that it resumes unless refill returns zero
+func WithFuelRefill(refill func(vm *VM) uint64) VMOption {
+	return func(vm *VM) {
+		vm.fuel.refill = refill
+	}
+}
+
+// AddFuel increases remaining fuel by n
+func (vm *VM) AddFuel(n uint64) {
+	vm.fuel.remaining += n
+}
+
+// Fuel returns remaining fuel
+func (vm *VM) Fuel() uint64 {
+	return vm.fuel.remaining
+}
+
+// consumeFuel charges instruction op, it either refills fuel or traps once it's exhausted
+func (vm *VM) consumeFuel(op byte) {
+	vm.chargeFuel(vm.fuel.costs[op])
+}
+
+// consumeFuelUnits charges instruction op once more per unit of work it does, if fuel is metered
+func (vm *VM) consumeFuelUnits(op Bytecode, units uint64) {
+	if vm.fuel.costs == nil || units == 0 {
+		return
+	}
+	hi, cost := bits.Mul64(vm.fuel.costs[op], units)
+	if hi != 0 {
+		cost = math.MaxUint64
+	}
+	vm.chargeFuel(cost)
+}
+
+// bulkUnits returns the number of units n bytes or elements are charged as
+func bulkUnits(n uint32) uint64 {
+	return (uint64(n) + bulkUnit - 1) / bulkUnit
+}
+
+func (vm *VM) chargeFuel(cost uint64) {
+	for vm.fuel.remaining < cost {
+		var n uint64
+		if vm.fuel.refill != nil {
+			n = vm.fuel.refill(vm)
+		}
+		if n == 0 {
+			panic(ErrOutOfFuel)
+		}
+		vm.fuel.remaining += n
+	}
+	vm.fuel.remaining -= cost
+}
+
+// execMetered is execCode accounting fuel
+func (vm *VM) execMetered() {
+	if vm.ctx.raw != nil {
+		for vm.ctx.pc < len(vm.ctx.raw) {
+			code := vm.ctx.raw[vm.ctx.pc]
+			vm.consumeFuel(code)
+			vm.ctx.pc++
+			inPlaceTable[code](vm)
+		}
+		return
+	}
+	for vm.ctx.pc < len(vm.ctx.code) {
+		in := &vm.ctx.code[vm.ctx.pc]
+		vm.consumeFuel(byte(in.code))
+		vm.ctx.pc++
+		vm.ctx.ins = in
+		funcTable[in.code](vm)
+	}
+}
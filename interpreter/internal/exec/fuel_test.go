@@ -0,0 +1,108 @@
+package exec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// module of a function looping forever, one growing memory and one filling it
+func meteredModule() []byte {
+	m := &Module{
+		Types: [][]byte{FuncType(nil, nil), FuncType(B(I32), B(I32))},
+		Funcs: []Func{
+			{Type: 0, Code: B(0x03, 0x40, 0x0c, 0, 0x0b)}, // loop br 0 end
+			{Type: 1, Code: Cat(B(0x20, 0), B(0x40, 0))},
+			{Type: 1, Code: Cat(I32Const(0), I32Const(0), B(0x20, 0), B(0xfc), U(11), B(0), I32Const(0))},
+		},
+		Mems:    [][]byte{Memory(1, -1)},
+		Exports: [][]byte{ExportFunc("spin", 0), ExportFunc("grow", 1), ExportFunc("fill", 2)},
+	}
+	return m.Bytes()
+}
+
+func TestOutOfFuel(t *testing.T) {
+	for _, mode := range execModes {
+		vm := instantiate(t, meteredModule(), mode, exec.WithFuel(1000, nil))
+		if _, err := call(t, vm, "spin"); !errors.Is(err, exec.ErrOutOfFuel) {
+			t.Fatalf("spin in %s mode: %v", modeName(mode), err)
+		}
+		if _, err := call(t, vm, "grow", 1); !errors.Is(err, exec.ErrOutOfFuel) {
+			t.Fatalf("grow without fuel in %s mode: %v", modeName(mode), err)
+		}
+		vm.AddFuel(1000)
+		if r, err := call(t, vm, "grow", 1); err != nil || r[0] != 1 {
+			t.Fatalf("grow after AddFuel in %s mode = %v, %v", modeName(mode), r, err)
+		}
+	}
+}
+
+func TestFuelRefill(t *testing.T) {
+	for _, mode := range execModes {
+		refills := 0
+		vm := instantiate(t, meteredModule(), mode, exec.WithFuel(0, nil), exec.WithFuelRefill(func(*exec.VM) uint64 {
+			if refills++; refills > 5 {
+				return 0
+			}
+			return 100
+		}))
+		if _, err := call(t, vm, "spin"); !errors.Is(err, exec.ErrOutOfFuel) {
+			t.Fatalf("spin in %s mode: %v", modeName(mode), err)
+		}
+		if refills != 6 {
+			t.Errorf("refill called %d times in %s mode, want 6", refills, modeName(mode))
+		}
+	}
+}
+
+// consumed returns fuel consumed by the call
+func consumed(t *testing.T, vm *exec.VM, name string, args ...uint64) uint64 {
+	t.Helper()
+	before := vm.Fuel()
+	if _, err := call(t, vm, name, args...); err != nil {
+		t.Fatalf("%s%v: %v", name, args, err)
+	}
+	return before - vm.Fuel()
+}
+
+func TestFuelOfMemoryGrowth(t *testing.T) {
+	costs := exec.DefaultCostTable()
+	for _, mode := range execModes {
+		vm := instantiate(t, meteredModule(), mode, exec.WithFuel(1<<40, costs))
+		// memory.grow is charged once more per page
+		base := consumed(t, vm, "grow", 0)
+		if got, want := consumed(t, vm, "grow", 3)-base, 3*costs[0x40]; got != want {
+			t.Errorf("growth by 3 pages in %s mode costs %d more than by none, want %d", modeName(mode), got, want)
+		}
+		// failed growth is charged as growth by none
+		if got := consumed(t, vm, "grow", 1<<20); got != base {
+			t.Errorf("failed growth in %s mode costs %d, want %d", modeName(mode), got, base)
+		}
+	}
+}
+
+func TestFuelOfBulkInstructions(t *testing.T) {
+	costs := exec.DefaultCostTable()
+	for _, mode := range execModes {
+		vm := instantiate(t, meteredModule(), mode, exec.WithFuel(1<<40, costs))
+		// memory.fill is charged once more per started 64 bytes
+		base := consumed(t, vm, "fill", 0)
+		if got, want := consumed(t, vm, "fill", 6400)-base, 100*costs[0xfc]; got != want {
+			t.Errorf("fill of 6400 bytes in %s mode costs %d more than the empty one, want %d", modeName(mode), got, want)
+		}
+		if got, want := consumed(t, vm, "fill", 65)-base, 2*costs[0xfc]; got != want {
+			t.Errorf("fill of 65 bytes in %s mode costs %d more than the empty one, want %d", modeName(mode), got, want)
+		}
+	}
+
+	// filling the whole page doesn't fit the fuel filling a few bytes does
+	vm := instantiate(t, meteredModule(), exec.ExecModeCompiled, exec.WithFuel(1000, nil))
+	if _, err := call(t, vm, "fill", 64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(t, vm, "fill", 65536); !errors.Is(err, exec.ErrOutOfFuel) {
+		t.Fatalf("fill of a page: %v", err)
+	}
+}
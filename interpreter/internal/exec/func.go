@@ -1,8 +1,48 @@
 package exec
 
+import (
+	"errors"
+)
+
+var (
+	ErrUndefinedElement         = errors.New("exec: undefined element")
+	ErrIndirectCallTypeMismatch = errors.New("exec: indirect call type mismatch")
+)
+
 func (vm *VM) call() {
-	index := vm.popInt32()
+	index := vm.currIns().arg0
+	vm.invoke(int64(index), vm.funcs[index])
+}
+
+func (vm *VM) callIndirect() {
+	in := vm.currIns()
+	if int(in.arg1) >= len(vm.tables) {
+		panic(InvalidTableIndexError(in.arg1))
+	}
+	table := vm.tables[in.arg1]
+	elem := vm.popUint32()
+	if int(elem) >= len(table.Elements) {
+		panic(ErrUndefinedElement)
+	}
+	ref := table.Elements[elem]
+	if ref == nil {
+		panic(UninitializedTableEntryError(elem))
+	}
+	fn := ref.vm.funcs[ref.index]
+	if !fn.sig.Equal(vm.module.TypesSection.sigs[in.arg0]) {
+		panic(ErrIndirectCallTypeMismatch)
+	}
+	if ref.vm != vm {
+		// the table is shared with another instance, the function runs there
+		bp, stack := vm.outgoingStack(fn.sig)
+		ref.vm.callExternal(vm, ref.index, stack)
+		vm.returnFromOutside(fn.sig, bp, stack)
+		return
+	}
+	vm.invoke(int64(ref.index), fn)
+}
 
-	fn := vm.module.FunctionIndexSpace[index]
-	fn.call(vm, int64(index))
+// invoke calls fn passing arguments from the operand stack of the caller
+func (vm *VM) invoke(index int64, fn *Function) {
+	fn.call(vm, index)
 }
@@ -2,79 +2,113 @@ package exec
 
 import (
 	"errors"
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
+	"sync"
 )
 
 const (
-	// make an interpreter option?
-	maxDepth         = 15
-	maxStackFrameNum = 256
+	// default limit of nested calls, see VM.SetMaxCallDepth
+	maxStackFrameNum = 1 << 16
+	// default limit of the value stack shared by all frames, in values
+	maxStackSize = 1 << 24
+)
+
+var (
+	ErrCallStackExhausted    = errors.New("exec: call stack exhausted")
+	ErrOperandStackExhausted = errors.New("exec: operand stack exhausted")
 )
 
 type Function struct {
 	numLocals int
 	numParams int
 	code      []byte
-	returns   bool
 	name      string
+	sig       *FunctionSig
+	body      *FunctionBody
+	// imported functions are implemented by host, which is resolved per instance
+	imported bool
+	host     *HostFunction
+
+	// code gets validated once, the side table is used by in-place execution
+	validateOnce sync.Once
+	validated    *validatedFunction
+	validateErr  error
+
+	// code gets decoded and compiled on the first call only
+	compileOnce sync.Once
+	compiled    []CompiledIns
+	compileErr  error
 }
 
-func (fn *Function) call(vm *VM, index int64, args ...uint64) (any, error) {
-	if len(args) != fn.numParams {
-		return nil, errors.New("number of arguments do not match")
-	}
+// validate type-checks body of fn, the result is cached for subsequent calls
+func (fn *Function) validate(m *Module) (*validatedFunction, error) {
+	fn.validateOnce.Do(func() {
+		fn.validated, fn.validateErr = validateFunction(m, fn.sig, fn.body)
+	})
+	return fn.validated, fn.validateErr
+}
 
-	stack := make([]uint64, 0, maxDepth)
+// instructions returns compiled body of fn, the result is cached for subsequent calls
+func (fn *Function) instructions(m *Module) ([]CompiledIns, error) {
+	fn.compileOnce.Do(func() {
+		if _, fn.compileErr = fn.validate(m); fn.compileErr != nil {
+			return
+		}
+		var ins []Instr
+		if ins, fn.compileErr = Disassemble(fn.code); fn.compileErr != nil {
+			return
+		}
+		fn.compiled, fn.compileErr = Compile(m, fn.sig.numResults(), ins)
+	})
+	return fn.compiled, fn.compileErr
+}
 
-	disasmedCode, err := Disassemble(fn.code)
-	if err != nil {
-		return nil, err
+// call invokes fn in a new frame, arguments are the topmost values of the operand stack
+// and become the first locals of the callee, results replace them once fn returns
+func (fn *Function) call(vm *VM, index int64) {
+	if vm.outerDepth+len(vm.frames) >= vm.config.MaxCallDepth {
+		panic(ErrCallStackExhausted)
+	}
+	vm.checkInterrupt()
+	if fn.imported {
+		fn.callHost(vm)
+		return
 	}
 
-	Dump(disasmedCode)
-	//compiledCode, _ := Compile(disasmedCode)
-
-	vm.ctx = &context{
-		stack:   stack,
-		raw:     nil,
-		ins:     disasmedCode,
-		pc:      0,
+	validated, err := fn.validate(vm.module)
+	if err != nil {
+		panic(err)
+	}
+	if disabled := validated.features &^ vm.config.Features; disabled != 0 {
+		panic(DisabledFeatureError{Features: disabled})
+	}
+	ctx := frame{
+		locals:  vm.sp - fn.numParams,
+		base:    vm.sp + fn.numLocals,
 		curFunc: index,
 	}
-	vm.ctxchain = append(vm.ctxchain, vm.ctx)
-
-	for _, arg := range args {
-		vm.pushUint64(arg)
+	if vm.mode == ExecModeInPlace {
+		ctx.raw, ctx.sideTable = fn.code, validated.sideTable
+	} else if ctx.code, err = fn.instructions(vm.module); err != nil {
+		panic(err)
 	}
 
-	var locals []uint64
-	for i := fn.numParams; i > 0; i-- {
-		locals = append(locals, vm.popUint64())
+	vm.growStack(ctx.base + validated.maxStackHeight)
+	for i := vm.sp; i < ctx.base; i++ {
+		vm.stack[i] = 0
 	}
+	vm.sp = ctx.base
+	vm.frames = append(vm.frames, ctx)
+	vm.ctx = &vm.frames[len(vm.frames)-1]
 
-	vm.ctx.locals = locals
-
-	vm.frames = append(vm.frames, vm.ctx)
-
-	ret := fn.execCode(vm)
+	vm.execCode()
 
+	n := fn.sig.numResults()
+	copy(vm.stack[ctx.locals:], vm.stack[vm.sp-n:vm.sp])
+	vm.sp = ctx.locals + n
 	vm.frames = vm.frames[:len(vm.frames)-1]
-	if fn.returns {
-		return ret, nil
-	}
-
-	return nil, nil
-}
-
-func (fn *Function) execCode(vm *VM) any {
-	// check if function returns something
-	_ = vm.execCode()
-	if fn.returns {
-		if len(vm.ctx.stack) > 0 {
-			return vm.popUint64()
-		} else {
-			reporter.ReportError("expected to have return value on stack")
-		}
+	if len(vm.frames) > 0 {
+		vm.ctx = &vm.frames[len(vm.frames)-1]
+	} else {
+		vm.ctx = nil
 	}
-	return nil
 }
@@ -0,0 +1,249 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+)
+
+// HostFunc implements an imported function in Go. Arguments are passed in stack, and results
+// are written back to it starting at index 0, stack is large enough to hold either of them.
+// vm is the instance calling the function
+type HostFunc func(ctx context.Context, vm *VM, stack []uint64)
+
+// HostFunction is a Go function which can be imported by modules
+type HostFunction struct {
+	Sig *FunctionSig
+	Fn  HostFunc
+}
+
+// ImportResolver provides implementations of imports of a module being instantiated
+type ImportResolver interface {
+	// ResolveFunction returns function exported by module under name
+	ResolveFunction(module, name string) (*HostFunction, bool)
+	ResolveMemory(module, name string) (*MemoryInstance, bool)
+	ResolveTable(module, name string) (*TableInstance, bool)
+	ResolveGlobal(module, name string) (*GlobalInstance, bool)
+}
+
+// HostModule is a named set of host functions, memories, tables and globals,
+// it resolves imports from the module of the same name
+type HostModule struct {
+	name     string
+	funcs    map[string]*HostFunction
+	memories map[string]*MemoryInstance
+	tables   map[string]*TableInstance
+	globals  map[string]*GlobalInstance
+}
+
+func NewHostModule(name string) *HostModule {
+	return &HostModule{
+		name:     name,
+		funcs:    make(map[string]*HostFunction),
+		memories: make(map[string]*MemoryInstance),
+		tables:   make(map[string]*TableInstance),
+		globals:  make(map[string]*GlobalInstance),
+	}
+}
+
+func (hm *HostModule) Name() string {
+	return hm.name
+}
+
+// AddFunction exports fn under name replacing the function exported under the same name before
+func (hm *HostModule) AddFunction(name string, fn *HostFunction) {
+	hm.funcs[name] = fn
+}
+
+func (hm *HostModule) AddMemory(name string, mem *MemoryInstance) {
+	hm.memories[name] = mem
+}
+
+func (hm *HostModule) AddTable(name string, table *TableInstance) {
+	hm.tables[name] = table
+}
+
+func (hm *HostModule) AddGlobal(name string, global *GlobalInstance) {
+	hm.globals[name] = global
+}
+
+func (hm *HostModule) ResolveFunction(module, name string) (*HostFunction, bool) {
+	return resolve(hm, hm.funcs, module, name)
+}
+
+func (hm *HostModule) ResolveMemory(module, name string) (*MemoryInstance, bool) {
+	return resolve(hm, hm.memories, module, name)
+}
+
+func (hm *HostModule) ResolveTable(module, name string) (*TableInstance, bool) {
+	return resolve(hm, hm.tables, module, name)
+}
+
+func (hm *HostModule) ResolveGlobal(module, name string) (*GlobalInstance, bool) {
+	return resolve(hm, hm.globals, module, name)
+}
+
+func resolve[T any](hm *HostModule, exports map[string]*T, module, name string) (*T, bool) {
+	if module != hm.name {
+		return nil, false
+	}
+	v, ok := exports[name]
+	return v, ok
+}
+
+// HostModules resolves imports from any of its modules
+type HostModules []*HostModule
+
+func (hms HostModules) ResolveFunction(module, name string) (*HostFunction, bool) {
+	return resolveAny(hms, (*HostModule).ResolveFunction, module, name)
+}
+
+func (hms HostModules) ResolveMemory(module, name string) (*MemoryInstance, bool) {
+	return resolveAny(hms, (*HostModule).ResolveMemory, module, name)
+}
+
+func (hms HostModules) ResolveTable(module, name string) (*TableInstance, bool) {
+	return resolveAny(hms, (*HostModule).ResolveTable, module, name)
+}
+
+func (hms HostModules) ResolveGlobal(module, name string) (*GlobalInstance, bool) {
+	return resolveAny(hms, (*HostModule).ResolveGlobal, module, name)
+}
+
+func resolveAny[T any](hms HostModules, resolve func(*HostModule, string, string) (*T, bool), module, name string) (*T, bool) {
+	for _, hm := range hms {
+		if v, ok := resolve(hm, module, name); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// UnresolvedImportError is returned on instantiation of a module whose import isn't provided
+type UnresolvedImportError struct {
+	Module, Name string
+}
+
+func (e UnresolvedImportError) Error() string {
+	return fmt.Sprintf("exec: unresolved import %s.%s", e.Module, e.Name)
+}
+
+// ImportTypeMismatchError is returned on instantiation of a module whose import is provided
+// with a type different from the one declared by the module
+type ImportTypeMismatchError struct {
+	Module, Name string
+	Expected     *FunctionSig
+	Actual       *FunctionSig
+}
+
+func (e ImportTypeMismatchError) Error() string {
+	return fmt.Sprintf("exec: import %s.%s: expected type %v -> %v, got %v -> %v", e.Module, e.Name,
+		e.Expected.Params, e.Expected.results(), e.Actual.Params, e.Actual.results())
+}
+
+// HostPanicError is a trap caused by a host function panicking with a value other than error,
+// errors are propagated as traps as is
+type HostPanicError struct {
+	Name  string
+	Value any
+}
+
+func (e HostPanicError) Error() string {
+	return fmt.Sprintf("exec: host function %s panicked: %v", e.Name, e.Value)
+}
+
+// resolveImports builds function index space of the instance, where imported functions
+// are backed by host functions provided by resolver
+func (vm *VM) resolveImports(resolver ImportResolver) error {
+	vm.funcs = vm.module.FunctionIndexSpace
+	imported := vm.module.importsOf(FunctionKind)
+	if len(imported) == 0 {
+		return nil
+	}
+
+	vm.funcs = append([]*Function(nil), vm.module.FunctionIndexSpace...)
+	for i, entry := range imported {
+		var host *HostFunction
+		ok := false
+		if resolver != nil {
+			host, ok = resolver.ResolveFunction(entry.ModuleName, entry.ExportName)
+		}
+		if !ok {
+			return UnresolvedImportError{Module: entry.ModuleName, Name: entry.ExportName}
+		}
+		fn := vm.module.FunctionIndexSpace[i]
+		if !fn.sig.Equal(host.Sig) {
+			return ImportTypeMismatchError{Module: entry.ModuleName, Name: entry.ExportName, Expected: fn.sig, Actual: host.Sig}
+		}
+		vm.funcs[i] = &Function{name: fn.name, numParams: fn.numParams, sig: fn.sig, imported: true, host: host}
+	}
+	return nil
+}
+
+// outgoingStack returns the part of the operand stack holding arguments of a function implemented
+// outside of the instance, it's large enough to hold results of the function as well
+func (vm *VM) outgoingStack(sig *FunctionSig) (int, []uint64) {
+	bp := vm.sp - len(sig.Params)
+	size := len(sig.Params)
+	if n := sig.numResults(); n > size {
+		size = n
+	}
+	vm.growStack(bp + size)
+	return bp, vm.stack[bp : bp+size : bp+size]
+}
+
+// returnFromOutside makes results of the call left in stack by outgoingStack the topmost values
+// of the operand stack, the value stack may have been reallocated by re-entrant calls meanwhile
+func (vm *VM) returnFromOutside(sig *FunctionSig, bp int, stack []uint64) {
+	n := sig.numResults()
+	copy(vm.stack[bp:bp+n], stack[:n])
+	vm.sp = bp + n
+}
+
+// callHost runs host function fn, its arguments are the topmost values of the operand stack
+func (fn *Function) callHost(vm *VM) {
+	bp, stack := vm.outgoingStack(fn.sig)
+	vm.collectExternRefs()
+	// results written by the host are kept on the stack
+	vm.sp = bp + len(stack)
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(error); ok {
+				panic(r)
+			}
+			panic(HostPanicError{Name: fn.name, Value: r})
+		}
+	}()
+	fn.host.Fn(vm.callCtx, vm, stack)
+	vm.returnFromOutside(fn.sig, bp, stack)
+}
+
+// callExternal runs function at index on behalf of caller, another instance. Stack holds
+// arguments and receives results. Traps are propagated to the caller once the state of vm is restored
+func (vm *VM) callExternal(caller *VM, index uint32, stack []uint64) {
+	fn := vm.funcs[index]
+	translateExternRefs(caller, vm, fn.sig.Params, stack)
+	sp, depth, outerDepth, prevCaller := vm.sp, len(vm.frames), vm.outerDepth, vm.caller
+	// depth limit and interruption apply to the whole chain of calls
+	vm.outerDepth, vm.caller = caller.outerDepth+len(caller.frames), caller
+	defer func() {
+		if r := recover(); r != nil {
+			vm.restore(sp, depth)
+			vm.outerDepth, vm.caller = outerDepth, prevCaller
+			panic(r)
+		}
+	}()
+	vm.growStack(sp + fn.numParams)
+	vm.sp += copy(vm.stack[sp:], stack[:fn.numParams])
+	fn.call(vm, int64(index))
+	copy(stack, vm.stack[sp:vm.sp])
+	vm.sp, vm.outerDepth, vm.caller = sp, outerDepth, prevCaller
+	translateExternRefs(vm, caller, fn.sig.Results, stack)
+}
+
+// Memory returns linear memory of the instance, it's nil if the instance has none
+func (vm *VM) Memory() Memory {
+	if vm.memory == nil {
+		return nil
+	}
+	return vm.memory
+}
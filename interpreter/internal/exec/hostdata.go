@@ -0,0 +1,42 @@
+package exec
+
+import "io"
+
+// WithHostData attaches value to the instance under key, host functions retrieve it with
+// HostData. Keys follow the rules of context.WithValue, values implementing io.Closer are
+// closed by VM.Close
+func WithHostData(key, value any) VMOption {
+	return func(vm *VM) {
+		vm.SetHostData(key, value)
+	}
+}
+
+// HostData returns value attached to the instance under key, it's nil if there's none
+func (vm *VM) HostData(key any) any {
+	return vm.hostData[key]
+}
+
+// SetHostData attaches value to the instance under key, host modules keep their state
+// for the instance this way
+func (vm *VM) SetHostData(key, value any) {
+	if vm.hostData == nil {
+		vm.hostData = make(map[any]any)
+	}
+	vm.hostData[key] = value
+}
+
+// Close tears the instance down closing its host data implementing io.Closer, e.g. state of
+// host modules holding host resources. It returns the first error, the instance must not
+// be used afterwards
+func (vm *VM) Close() error {
+	var first error
+	for key, value := range vm.hostData {
+		if c, ok := value.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+		delete(vm.hostData, key)
+	}
+	return first
+}
@@ -1,144 +1,207 @@
 package exec
 
 import (
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
-	"github.com/threadedstream/wasmexperiments/internal/types"
+	"errors"
 	"math"
 	"math/bits"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+var (
+	i32ConstOp      = newOp("i32.const", 0x41, types.ValueTypeVoid, types.ValueTypeSingleI32)
+	i64ConstOp      = newOp("i64.const", 0x42, types.ValueTypeVoid, types.ValueTypeSingleI64)
+	i32EqzOp        = newOp("i32.eqz", 0x45, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32EqOp         = newOp("i32.eq", 0x46, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32NeOp         = newOp("i32.ne", 0x47, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32LtSOp        = newOp("i32.lt_s", 0x48, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32LtUOp        = newOp("i32.lt_u", 0x49, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32GtSOp        = newOp("i32.gt_s", 0x4A, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32GtUOp        = newOp("i32.gt_u", 0x4B, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32LeSOp        = newOp("i32.le_s", 0x4C, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32LeUOp        = newOp("i32.le_u", 0x4D, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32GeSOp        = newOp("i32.ge_s", 0x4E, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32GeUOp        = newOp("i32.ge_u", 0x4F, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i64EqzOp        = newOp("i64.eqz", 0x50, types.ValueTypeSingleI64, types.ValueTypeSingleI32)
+	i64EqOp         = newOp("i64.eq", 0x51, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64NeOp         = newOp("i64.ne", 0x52, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64LtSOp        = newOp("i64.lt_s", 0x53, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64LtUOp        = newOp("i64.lt_u", 0x54, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64GtSOp        = newOp("i64.gt_s", 0x55, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64GtUOp        = newOp("i64.gt_u", 0x56, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64LeSOp        = newOp("i64.le_s", 0x57, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64LeUOp        = newOp("i64.le_u", 0x58, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64GeSOp        = newOp("i64.ge_s", 0x59, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i64GeUOp        = newOp("i64.ge_u", 0x5A, types.ValueTypeDoubleI64, types.ValueTypeSingleI32)
+	i32ClzOp        = newOp("i32.clz", 0x67, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32CtzOp        = newOp("i32.ctz", 0x68, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32PopcntOp     = newOp("i32.popcnt", 0x69, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32AddOp        = newOp("i32.add", 0x6A, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32SubOp        = newOp("i32.sub", 0x6B, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32MulOp        = newOp("i32.mul", 0x6C, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32DivSOp       = newOp("i32.div_s", 0x6D, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32DivUOp       = newOp("i32.div_u", 0x6E, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32RemSOp       = newOp("i32.rem_s", 0x6F, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32RemUOp       = newOp("i32.rem_u", 0x70, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32AndOp        = newOp("i32.and", 0x71, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32OrOp         = newOp("i32.or", 0x72, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32XorOp        = newOp("i32.xor", 0x73, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32ShlOp        = newOp("i32.shl", 0x74, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32ShrSOp       = newOp("i32.shr_s", 0x75, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32ShrUOp       = newOp("i32.shr_u", 0x76, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32RotLOp       = newOp("i32.rotl", 0x77, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i32RotROp       = newOp("i32.rotr", 0x78, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	i64ClzOp        = newOp("i64.clz", 0x79, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
+	i64CtzOp        = newOp("i64.ctz", 0x7A, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
+	i64PopcntOp     = newOp("i64.popcnt", 0x7B, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
+	i64AddOp        = newOp("i64.add", 0x7C, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64SubOp        = newOp("i64.sub", 0x7D, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64MulOp        = newOp("i64.mul", 0x7E, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64DivSOp       = newOp("i64.div_s", 0x7F, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64DivUOp       = newOp("i64.div_u", 0x80, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64RemSOp       = newOp("i64.rem_s", 0x81, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64RemUOp       = newOp("i64.rem_u", 0x82, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64AndOp        = newOp("i64.and", 0x83, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64OrOp         = newOp("i64.or", 0x84, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64XorOp        = newOp("i64.xor", 0x85, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64ShlOp        = newOp("i64.shl", 0x86, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64ShrSOp       = newOp("i64.shr_s", 0x87, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64ShrUOp       = newOp("i64.shr_u", 0x88, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64RotLOp       = newOp("i64.rotl", 0x89, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i64RotROp       = newOp("i64.rotr", 0x8A, types.ValueTypeDoubleI64, types.ValueTypeSingleI64)
+	i32WrapI64Op    = newOp("i32.wrap_i64", 0xA7, types.ValueTypeSingleI64, types.ValueTypeSingleI32)
+	i64ExtendI32SOp = newOp("i64.extend_i32_s", 0xAC, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64ExtendI32UOp = newOp("i64.extend_i32_u", 0xAD, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i32Extend8SOp   = newOp("i32.extend8_s", 0xC0, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32Extend16SOp  = newOp("i32.extend16_s", 0xC1, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i64Extend8SOp   = newOp("i64.extend8_s", 0xC2, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
+	i64Extend16SOp  = newOp("i64.extend16_s", 0xC3, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
+	i64Extend32SOp  = newOp("i64.extend32_s", 0xC4, types.ValueTypeSingleI64, types.ValueTypeSingleI64)
 )
 
 var (
-	i32ConstOp = newOp("i32.const", 0x41, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
-	i32EqOp    = newOp("i32.eq", 0x46, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32AddOp   = newOp("i32.add", 0x6A, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32MulOp   = newOp("i32.mul", 0x6C, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32SubOp   = newOp("i32.sub", 0x6B, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32DivSOp  = newOp("i32.div_s", 0x6D, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32DivUOp  = newOp("i32.div_u", 0x6E, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32RemSOp  = newOp("i32.rem_s", 0x6F, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32RemUOp  = newOp("i32.rem_u", 0x70, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	i32LtSOp   = newOp("i32.lt_s", 0x48, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
+	ErrIntegerDivideByZero = errors.New("exec: integer divide by zero")
+	ErrIntegerOverflow     = errors.New("exec: integer overflow")
 )
 
 func (vm *VM) i32Clz() {
-	vm.pushUint64(uint64(bits.LeadingZeros32(vm.popUint32())))
+	vm.pushUint32(uint32(bits.LeadingZeros32(vm.popUint32())))
 }
 
 func (vm *VM) i32Ctz() {
-	vm.pushUint64(uint64(bits.TrailingZeros32(vm.popUint32())))
+	vm.pushUint32(uint32(bits.TrailingZeros32(vm.popUint32())))
 }
 
 func (vm *VM) i32Popcnt() {
-	vm.pushUint64(uint64(bits.OnesCount32(vm.popUint32())))
+	vm.pushUint32(uint32(bits.OnesCount32(vm.popUint32())))
 }
 
 func (vm *VM) i32Add() {
 	vm.pushUint32(vm.popUint32() + vm.popUint32())
-	vm.ctx.pc++
+}
+
+func (vm *VM) i32Sub() {
+	rhs := vm.popUint32()
+	lhs := vm.popUint32()
+	vm.pushUint32(lhs - rhs)
 }
 
 func (vm *VM) i32Mul() {
-	// TODO(threadedstream): add overflow checks?
 	vm.pushUint32(vm.popUint32() * vm.popUint32())
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32DivS() {
 	rhs := vm.popInt32()
 	lhs := vm.popInt32()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
 	if lhs == math.MinInt32 && rhs == -1 {
-		reporter.ReportError("detected integer overflow")
+		panic(ErrIntegerOverflow)
 	}
 	vm.pushInt32(lhs / rhs)
-	vm.ctx.pc++
-}
-
-func (vm *VM) i32Sub() {
-	rhs := vm.popUint32()
-	lhs := vm.popUint32()
-	vm.pushUint32(lhs - rhs)
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32DivU() {
 	rhs := vm.popUint32()
 	lhs := vm.popUint32()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
 	vm.pushUint32(lhs / rhs)
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32RemS() {
 	rhs := vm.popInt32()
 	lhs := vm.popInt32()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	if rhs == -1 {
+		// avoid overflow of MinInt32 % -1, the result is 0 anyway
+		vm.pushInt32(0)
+		return
+	}
 	vm.pushInt32(lhs % rhs)
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32RemU() {
 	rhs := vm.popUint32()
 	lhs := vm.popUint32()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
 	vm.pushUint32(lhs % rhs)
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32And() {
 	vm.pushUint32(vm.popUint32() & vm.popUint32())
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32Or() {
 	vm.pushUint32(vm.popUint32() | vm.popUint32())
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32Xor() {
 	vm.pushUint32(vm.popUint32() ^ vm.popUint32())
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32Shl() {
 	shift := vm.popUint32()
 	target := vm.popUint32()
 	vm.pushUint32(target << (shift % 32))
-	vm.ctx.pc++
 }
 
-func (vm *VM) i32Shr() {
+func (vm *VM) i32ShrU() {
 	shift := vm.popUint32()
 	target := vm.popUint32()
 	vm.pushUint32(target >> (shift % 32))
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32ShrS() {
 	shift := vm.popUint32()
 	target := vm.popInt32()
 	vm.pushInt32(target >> (shift % 32))
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32RotL() {
 	factor := vm.popUint32()
 	target := vm.popUint32()
-	vm.pushUint32(bits.RotateLeft32(target, int(factor)))
-	vm.ctx.pc++
+	vm.pushUint32(bits.RotateLeft32(target, int(factor%32)))
 }
 
 func (vm *VM) i32RotR() {
 	factor := vm.popUint32()
 	target := vm.popUint32()
-	vm.pushUint32(bits.RotateLeft32(target, int(-factor)))
-	vm.ctx.pc++
+	vm.pushUint32(bits.RotateLeft32(target, -int(factor%32)))
 }
 
 func (vm *VM) i32Eqz() {
-	target := vm.popUint32()
-	if target == 0 {
-		vm.pushUint32(1)
+	if vm.popUint32() == 0 {
+		vm.pushOne()
 	} else {
-		vm.pushUint32(0)
+		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32Eq() {
@@ -149,7 +212,6 @@ func (vm *VM) i32Eq() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32Ne() {
@@ -160,7 +222,6 @@ func (vm *VM) i32Ne() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32LtS() {
@@ -171,7 +232,6 @@ func (vm *VM) i32LtS() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32LtU() {
@@ -182,7 +242,6 @@ func (vm *VM) i32LtU() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32LeS() {
@@ -193,7 +252,6 @@ func (vm *VM) i32LeS() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32LeU() {
@@ -204,7 +262,6 @@ func (vm *VM) i32LeU() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32GtS() {
@@ -215,7 +272,6 @@ func (vm *VM) i32GtS() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32GtU() {
@@ -226,7 +282,6 @@ func (vm *VM) i32GtU() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32GeS() {
@@ -237,7 +292,6 @@ func (vm *VM) i32GeS() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
 }
 
 func (vm *VM) i32GeU() {
@@ -248,5 +302,256 @@ func (vm *VM) i32GeU() {
 	} else {
 		vm.pushZero()
 	}
-	vm.ctx.pc++
+}
+
+func (vm *VM) i64Clz() {
+	vm.pushUint64(uint64(bits.LeadingZeros64(vm.popUint64())))
+}
+
+func (vm *VM) i64Ctz() {
+	vm.pushUint64(uint64(bits.TrailingZeros64(vm.popUint64())))
+}
+
+func (vm *VM) i64Popcnt() {
+	vm.pushUint64(uint64(bits.OnesCount64(vm.popUint64())))
+}
+
+func (vm *VM) i64Add() {
+	vm.pushUint64(vm.popUint64() + vm.popUint64())
+}
+
+func (vm *VM) i64Sub() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	vm.pushUint64(lhs - rhs)
+}
+
+func (vm *VM) i64Mul() {
+	vm.pushUint64(vm.popUint64() * vm.popUint64())
+}
+
+func (vm *VM) i64DivS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	if lhs == math.MinInt64 && rhs == -1 {
+		panic(ErrIntegerOverflow)
+	}
+	vm.pushInt64(lhs / rhs)
+}
+
+func (vm *VM) i64DivU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	vm.pushUint64(lhs / rhs)
+}
+
+func (vm *VM) i64RemS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	if rhs == -1 {
+		// avoid overflow of MinInt64 % -1, the result is 0 anyway
+		vm.pushInt64(0)
+		return
+	}
+	vm.pushInt64(lhs % rhs)
+}
+
+func (vm *VM) i64RemU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if rhs == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	vm.pushUint64(lhs % rhs)
+}
+
+func (vm *VM) i64And() {
+	vm.pushUint64(vm.popUint64() & vm.popUint64())
+}
+
+func (vm *VM) i64Or() {
+	vm.pushUint64(vm.popUint64() | vm.popUint64())
+}
+
+func (vm *VM) i64Xor() {
+	vm.pushUint64(vm.popUint64() ^ vm.popUint64())
+}
+
+func (vm *VM) i64Shl() {
+	shift := vm.popUint64()
+	target := vm.popUint64()
+	vm.pushUint64(target << (shift % 64))
+}
+
+func (vm *VM) i64ShrU() {
+	shift := vm.popUint64()
+	target := vm.popUint64()
+	vm.pushUint64(target >> (shift % 64))
+}
+
+func (vm *VM) i64ShrS() {
+	shift := vm.popUint64()
+	target := vm.popInt64()
+	vm.pushInt64(target >> (shift % 64))
+}
+
+func (vm *VM) i64RotL() {
+	factor := vm.popUint64()
+	target := vm.popUint64()
+	vm.pushUint64(bits.RotateLeft64(target, int(factor%64)))
+}
+
+func (vm *VM) i64RotR() {
+	factor := vm.popUint64()
+	target := vm.popUint64()
+	vm.pushUint64(bits.RotateLeft64(target, -int(factor%64)))
+}
+
+func (vm *VM) i64Eqz() {
+	if vm.popUint64() == 0 {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64Eq() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs == rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64Ne() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs != rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64LtS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if lhs < rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64LtU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs < rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64LeS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if lhs <= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64LeU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs <= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64GtS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if lhs > rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64GtU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs > rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64GeS() {
+	rhs := vm.popInt64()
+	lhs := vm.popInt64()
+	if lhs >= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i64GeU() {
+	rhs := vm.popUint64()
+	lhs := vm.popUint64()
+	if lhs >= rhs {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) i32WrapI64() {
+	vm.pushUint32(uint32(vm.popUint64()))
+}
+
+func (vm *VM) i64ExtendI32S() {
+	vm.pushInt64(int64(vm.popInt32()))
+}
+
+func (vm *VM) i64ExtendI32U() {
+	vm.pushUint64(uint64(vm.popUint32()))
+}
+
+func (vm *VM) i32Extend8S() {
+	vm.pushInt32(int32(int8(vm.popUint32())))
+}
+
+func (vm *VM) i32Extend16S() {
+	vm.pushInt32(int32(int16(vm.popUint32())))
+}
+
+func (vm *VM) i64Extend8S() {
+	vm.pushInt64(int64(int8(vm.popUint64())))
+}
+
+func (vm *VM) i64Extend16S() {
+	vm.pushInt64(int64(int16(vm.popUint64())))
+}
+
+func (vm *VM) i64Extend32S() {
+	vm.pushInt64(int64(int32(vm.popUint64())))
 }
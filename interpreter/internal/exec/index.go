@@ -1,9 +1,10 @@
 package exec
 
 import (
+	"errors"
 	"fmt"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
-	"reflect"
+	"github.com/threadedstream/wasmexperiments/internal/types"
 )
 
 // Mostly copied from wagon
@@ -31,8 +32,8 @@ func (e UninitializedTableEntryError) Error() string {
 }
 
 type InvalidValueTypeInitExprError struct {
-	Wanted reflect.Kind
-	Got    reflect.Kind
+	Wanted types.ValueType
+	Got    types.ValueType
 }
 
 func (e InvalidValueTypeInitExprError) Error() string {
@@ -45,82 +46,126 @@ func (e InvalidLinearMemoryIndexError) Error() string {
 	return fmt.Sprintf("wasm: Invalid linear memory index: %d", uint32(e))
 }
 
-func (m *Module) populateLinearMemory() error {
-	if m.DataSection == nil || len(m.DataSection.Entries) == 0 {
-		return nil
+// initializeFunctionIndexSpace lays out imported functions followed by the ones defined in the module,
+// imported functions get their implementation once an instance resolves its imports
+func (m *Module) initializeFunctionIndexSpace() error {
+	imported := m.importsOf(FunctionKind)
+	requiredLen := len(imported)
+	if m.FunctionSection != nil {
+		requiredLen += len(m.FunctionSection.Indices)
 	}
 
-	for _, entry := range m.DataSection.Entries {
-		if entry.Index != 0 {
-			return InvalidLinearMemoryIndexError(entry.Index)
+	m.FunctionIndexSpace = make([]*Function, 0, requiredLen)
+	for _, entry := range imported {
+		sigIndex := entry.Description.(*FunctionKindDesc).SigIndex
+		sig, ok := m.sig(sigIndex)
+		if !ok {
+			return fmt.Errorf("wasm: import %s.%s refers to unknown type %d", entry.ModuleName, entry.ExportName, sigIndex)
 		}
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, &Function{
+			name:      entry.ModuleName + "." + entry.ExportName,
+			numParams: len(sig.Params),
+			sig:       sig,
+			imported:  true,
+		})
+	}
 
-		val, err := m.execInitExpr(entry.Offset)
-		if err != nil {
-			return err
-		}
-		off, ok := val.(int32)
-		if !ok {
-			return InvalidValueTypeInitExprError{reflect.Int32, reflect.TypeOf(val).Kind()}
+	if m.FunctionSection != nil {
+		if m.CodeSection == nil || len(m.CodeSection.Entries) != len(m.FunctionSection.Indices) {
+			return errors.New("wasm: function and code sections have inconsistent lengths")
 		}
-		offset := uint32(off)
-
-		memory := m.LinearMemoryIndexSpace[entry.Index]
-		if uint64(offset)+uint64(len(entry.Data)) > uint64(len(memory)) {
-			data := make([]byte, uint64(offset)+uint64(len(entry.Data)))
-			copy(data, memory)
-			copy(data[offset:], entry.Data)
-			m.LinearMemoryIndexSpace[int(entry.Index)] = data
-		} else {
-			copy(memory[offset:], entry.Data)
+		for idx, typeIdx := range m.FunctionSection.Indices {
+			sig, ok := m.sig(typeIdx)
+			if !ok {
+				return fmt.Errorf("wasm: function %d refers to unknown type %d", len(m.FunctionIndexSpace), typeIdx)
+			}
+			body := m.CodeSection.Entries[idx]
+			numLocals := 0
+			for _, local := range body.Locals {
+				numLocals += int(local.Count)
+			}
+			m.FunctionIndexSpace = append(m.FunctionIndexSpace, &Function{
+				code:      body.Code,
+				name:      "",
+				numLocals: numLocals,
+				numParams: len(sig.Params),
+				sig:       sig,
+				body:      body,
+			})
 		}
 	}
-
 	return nil
+}
+
+func (m *Module) GetFunction(i int) *Function {
+	if i >= len(m.FunctionIndexSpace) || i < 0 {
+		reporter.ReportError("module.GetFunction: attempting to use index out of bounds %d", i)
+	}
 
+	return m.FunctionIndexSpace[i]
 }
 
-func (m *Module) initializeFunctionIndexSpace() {
-	requiredLen := 0
-	if m.FunctionSection != nil {
-		requiredLen += len(m.FunctionSection.Indices)
+// importsOf returns imports of the given kind in order of their appearance
+func (m *Module) importsOf(kind ExternalKind) []*ImportEntry {
+	if m.ImportSection == nil {
+		return nil
 	}
+	var entries []*ImportEntry
+	for _, entry := range m.ImportSection.Entries {
+		if entry.Description.Kind() == kind {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
 
-	if m.ImportSection != nil {
-		requiredLen += len(m.ImportSection.Entries)
+// functionSig returns signature of a function from the function index space, imported functions come first
+func (m *Module) functionSig(index uint32) (*FunctionSig, bool) {
+	var typeIndex uint32
+	imported := m.importsOf(FunctionKind)
+	switch {
+	case int(index) < len(imported):
+		typeIndex = imported[index].Description.(*FunctionKindDesc).SigIndex
+	case m.FunctionSection != nil && int(index)-len(imported) < len(m.FunctionSection.Indices):
+		typeIndex = m.FunctionSection.Indices[int(index)-len(imported)]
+	default:
+		return nil, false
 	}
+	return m.sig(typeIndex)
+}
 
-	m.FunctionIndexSpace = make([]*Function, requiredLen)
-	if m.ImportSection != nil {
-		// TODO(threadedstream): most likely that it's not a correct way to populate function index space with imported functions
-		for i := 0; i < len(m.ImportSection.Entries); i++ {
-			m.FunctionIndexSpace[i] = &Function{
-				code:      m.CodeSection.Entries[i].Code,
-				name:      m.ImportSection.Entries[i].ExportName,
-				numParams: len(m.TypesSection.sigs[i].Params),
-				returns:   m.TypesSection.sigs[i].Results[0] != 0,
-			}
-		}
+func (m *Module) sig(typeIndex uint32) (*FunctionSig, bool) {
+	if m.TypesSection == nil || int(typeIndex) >= len(m.TypesSection.sigs) {
+		return nil, false
 	}
+	return m.TypesSection.sigs[typeIndex], true
+}
 
-	if m.FunctionSection != nil {
-		idx := 0
-		for _, typeIdx := range m.FunctionSection.Indices {
-			m.FunctionIndexSpace[idx] = &Function{
-				code:      m.CodeSection.Entries[idx].Code,
-				name:      "",
-				numParams: len(m.TypesSection.sigs[typeIdx].Params),
-				returns:   m.TypesSection.sigs[typeIdx].Results[0] != 0,
-			}
-			idx++
-		}
+// globalType returns description of a global from the global index space, imported globals come first
+func (m *Module) globalType(index uint32) (GlobalKindDesc, bool) {
+	imported := m.importsOf(GlobalKind)
+	if int(index) < len(imported) {
+		return *imported[index].Description.(*GlobalKindDesc), true
 	}
+	index -= uint32(len(imported))
+	if m.GlobalSection == nil || int(index) >= len(m.GlobalSection.Entries) {
+		return GlobalKindDesc{}, false
+	}
+	return m.GlobalSection.Entries[index].Description, true
 }
 
-func (m *Module) GetFunction(i int) *Function {
-	if i >= len(m.FunctionIndexSpace) || i < 0 {
-		reporter.ReportError("module.GetFunction: attempting to use index out of bounds %d", i)
+func (m *Module) numTables() int {
+	n := len(m.importsOf(TableKind))
+	if m.TableSection != nil {
+		n += len(m.TableSection.Entries)
 	}
+	return n
+}
 
-	return m.FunctionIndexSpace[i]
+func (m *Module) numMemories() int {
+	n := len(m.importsOf(MemoryKind))
+	if m.MemorySection != nil {
+		n += len(m.MemorySection.Entries)
+	}
+	return n
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
+	"github.com/threadedstream/wasmexperiments/internal/types"
 )
 
 const (
@@ -16,6 +17,8 @@ const (
 	f32Const  byte = 0x43
 	f64Const  byte = 0x44
 	globalGet byte = 0x23
+	refNull   byte = 0xd0
+	refFunc   byte = 0xd2
 	end       byte = 0x0b
 )
 
@@ -68,10 +71,14 @@ outer:
 			if _, err = wbinary.ReadU64(reader); err != nil {
 				return nil, err
 			}
-		case globalGet:
+		case globalGet, refFunc:
 			if _, err = wbinary.ReadVarUint32(reader); err != nil {
 				return nil, err
 			}
+		case refNull:
+			if _, err = io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
 		case end:
 			break outer
 		}
@@ -84,7 +91,90 @@ outer:
 	return buf.Bytes(), nil
 }
 
-// TODO(threadedstream): make it public?
-func (m *Module) execInitExpr(expr []byte) (any, error) {
-	return nil, nil
+var ErrInitExprMultipleValues = errors.New("wasm: initializer expression produces more than one value")
+
+// execInitExpr evaluates constant expression expr, global.get may only refer to globals
+// of the instance which are initialized already
+func (vm *VM) execInitExpr(expr []byte) (uint64, types.ValueType, error) {
+	reader := wasm_reader.NewWasmReader(bytes.NewReader(expr))
+	var (
+		vals []uint64
+		typs []types.ValueType
+	)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		var (
+			val uint64
+			typ types.ValueType
+		)
+		switch b {
+		default:
+			return 0, 0, InvalidInitExprOpError(b)
+		case i32Const:
+			v, err := wbinary.ReadVarInt32(reader)
+			if err != nil {
+				return 0, 0, err
+			}
+			val, typ = uint64(uint32(v)), types.ValueTypeI32
+		case i64Const:
+			v, err := wbinary.ReadVarInt64(reader)
+			if err != nil {
+				return 0, 0, err
+			}
+			val, typ = uint64(v), types.ValueTypeI64
+		case f32Const:
+			v, err := wbinary.ReadU32(reader)
+			if err != nil {
+				return 0, 0, err
+			}
+			val, typ = uint64(v), types.ValueTypeF32
+		case f64Const:
+			if val, err = wbinary.ReadU64(reader); err != nil {
+				return 0, 0, err
+			}
+			typ = types.ValueTypeF64
+		case globalGet:
+			index, err := wbinary.ReadVarUint32(reader)
+			if err != nil {
+				return 0, 0, err
+			}
+			if int(index) >= len(vm.globals) {
+				return 0, 0, InvalidGlobalIndexError(index)
+			}
+			g := vm.globals[index]
+			val, typ = g.Value, g.Type
+			if isReference(typ) {
+				val = vm.ExternRef(g.Ref)
+			}
+		case refFunc:
+			index, err := wbinary.ReadVarUint32(reader)
+			if err != nil {
+				return 0, 0, err
+			}
+			if int(index) >= len(vm.funcs) {
+				return 0, 0, fmt.Errorf("wasm: ref.func refers to unknown function %d", index)
+			}
+			val, typ = vm.ExternRef(vm.funcRef(index)), types.ValueTypeFuncRef
+		case refNull:
+			t, err := reader.ReadByte()
+			if err != nil {
+				return 0, 0, err
+			}
+			if typ = types.ValueType(t); typ != types.ValueTypeFuncRef && typ != types.ValueTypeExternRef {
+				return 0, 0, fmt.Errorf("wasm: ref.null of non-reference type %#x", t)
+			}
+		case end:
+			switch len(vals) {
+			case 0:
+				return 0, 0, ErrEmptyInitExpr
+			case 1:
+				return vals[0], typs[0], nil
+			}
+			return 0, 0, ErrInitExprMultipleValues
+		}
+		vals, typs = append(vals, val), append(typs, typ)
+	}
 }
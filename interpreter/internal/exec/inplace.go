@@ -0,0 +1,182 @@
+package exec
+
+import (
+	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
+)
+
+// ExecMode selects how function bodies are executed
+type ExecMode int
+
+const (
+	// ExecModeCompiled runs flat bytecode compiled from function bodies on the first call
+	ExecModeCompiled ExecMode = iota
+	// ExecModeInPlace interprets function bodies as they are in the binary, branches
+	// are resolved with the side table produced by the validator
+	ExecModeInPlace
+)
+
+// inPlaceTable maps opcodes of the wasm binary onto their handlers
+var inPlaceTable [256]func(vm *VM)
+
+// initInPlaceTable derives inPlaceTable from funcTable, so it must run after the latter is built
+func initInPlaceTable() {
+	inPlaceTable = funcTable
+	inPlaceTable[jmpOp] = nil
+	inPlaceTable[jmpIfNotOp] = nil
+
+	inPlaceTable[blockOp] = (*VM).skipBlockType
+	inPlaceTable[loopOp] = (*VM).skipBlockType
+	inPlaceTable[ifOp] = (*VM).inPlaceIf
+	inPlaceTable[elseOp] = (*VM).inPlaceBr
+	inPlaceTable[endOp] = (*VM).nop
+	inPlaceTable[brOp] = (*VM).inPlaceBr
+	inPlaceTable[brIfOp] = (*VM).inPlaceBrIf
+	inPlaceTable[brTableOp] = (*VM).inPlaceBrTable
+	inPlaceTable[returnOp] = (*VM).inPlaceRet
+
+	for _, code := range []Bytecode{callOp, localGetOp, localSetOp, localTeeOp, globalGetOp, globalSetOp,
+		tableGetOp, tableSetOp, refFuncOp} {
+		inPlaceTable[code] = withImmediates((*VM).decodeIndex, funcTable[code])
+	}
+	inPlaceTable[callIndirectOp] = withImmediates((*VM).decodeDoubleIndex, funcTable[callIndirectOp])
+	inPlaceTable[i32ConstOp] = withImmediates((*VM).decodeI32Const, funcTable[i32ConstOp])
+	inPlaceTable[i64ConstOp] = withImmediates((*VM).decodeI64Const, funcTable[i64ConstOp])
+	inPlaceTable[f32ConstOp] = withImmediates((*VM).decodeF32Const, funcTable[f32ConstOp])
+	inPlaceTable[f64ConstOp] = withImmediates((*VM).decodeF64Const, funcTable[f64ConstOp])
+	for _, code := range []Bytecode{i32LoadOp, i64LoadOp, f32LoadOp, f64LoadOp, i32Load8SOp, i32Load8UOp,
+		i32Load16SOp, i32Load16UOp, i64Load8SOp, i64Load8UOp, i64Load16SOp, i64Load16UOp, i64Load32SOp, i64Load32UOp,
+		i32StoreOp, i64StoreOp, f32StoreOp, f64StoreOp, i32Store8Op, i32Store16Op, i64Store8Op, i64Store16Op, i64Store32Op} {
+		inPlaceTable[code] = withImmediates((*VM).decodeMemArg, funcTable[code])
+	}
+	inPlaceTable[memorySizeOp] = withImmediates((*VM).skipReserved, funcTable[memorySizeOp])
+	inPlaceTable[memoryGrowOp] = withImmediates((*VM).skipReserved, funcTable[memoryGrowOp])
+	inPlaceTable[miscPrefix] = withImmediates((*VM).decodeMisc, funcTable[miscPrefix])
+	inPlaceTable[refNullOp] = withImmediates((*VM).skipReserved, funcTable[refNullOp])
+	inPlaceTable[selectTypedOp] = withImmediates((*VM).skipValueTypes, funcTable[selectTypedOp])
+}
+
+// withImmediates makes handler of the compiled bytecode usable in place: immediates
+// of the instruction are decoded into a scratch instruction which handler then reads
+func withImmediates(decode, handler func(vm *VM)) func(vm *VM) {
+	return func(vm *VM) {
+		vm.ctx.ins = &vm.ctx.imm
+		decode(vm)
+		handler(vm)
+	}
+}
+
+// execInPlace runs the body of the current frame until its end is reached
+func (vm *VM) execInPlace() {
+	for vm.ctx.pc < len(vm.ctx.raw) {
+		code := vm.ctx.raw[vm.ctx.pc]
+		vm.ctx.pc++
+		inPlaceTable[code](vm)
+	}
+}
+
+func (vm *VM) fetchVarUint32() uint32 {
+	val, n := wbinary.DecodeVarUint32(vm.ctx.raw[vm.ctx.pc:])
+	vm.ctx.pc += n
+	return val
+}
+
+func (vm *VM) decodeIndex() {
+	vm.ctx.imm.arg0 = vm.fetchVarUint32()
+}
+
+func (vm *VM) decodeDoubleIndex() {
+	vm.ctx.imm.arg0 = vm.fetchVarUint32()
+	vm.ctx.imm.arg1 = vm.fetchVarUint32()
+}
+
+func (vm *VM) decodeI32Const() {
+	val, n := wbinary.DecodeVarInt64(vm.ctx.raw[vm.ctx.pc:])
+	vm.ctx.pc += n
+	vm.ctx.imm.imm = uint64(uint32(val))
+}
+
+func (vm *VM) decodeI64Const() {
+	val, n := wbinary.DecodeVarInt64(vm.ctx.raw[vm.ctx.pc:])
+	vm.ctx.pc += n
+	vm.ctx.imm.imm = uint64(val)
+}
+
+func (vm *VM) decodeF32Const() {
+	vm.ctx.imm.imm = uint64(vm.fetchUint32())
+}
+
+func (vm *VM) decodeF64Const() {
+	vm.ctx.imm.imm = vm.fetchUint64()
+}
+
+// decodeMemArg skips alignment hint and keeps the offset
+func (vm *VM) decodeMemArg() {
+	vm.fetchVarUint32()
+	vm.ctx.imm.arg0 = vm.fetchVarUint32()
+}
+
+// skipReserved steps over a single byte immediate, either reserved or checked by the validator
+func (vm *VM) skipReserved() {
+	vm.ctx.pc++
+}
+
+// skipValueTypes steps over the vector of value types of typed select
+func (vm *VM) skipValueTypes() {
+	n := vm.fetchVarUint32()
+	vm.ctx.pc += int(n)
+}
+
+// skipBlockType steps over the block type, it's checked by the validator already
+func (vm *VM) skipBlockType() {
+	for vm.ctx.raw[vm.ctx.pc]&0x80 != 0 {
+		vm.ctx.pc++
+	}
+	vm.ctx.pc++
+}
+
+// takeBranch continues execution as described by the current side table entry
+func (vm *VM) takeBranch(entry *sideTableEntry) {
+	vm.unwind(int(entry.height), int(entry.arity))
+	if int(entry.pc) < vm.ctx.pc {
+		// back-edge of a loop
+		vm.checkInterrupt()
+	}
+	vm.ctx.pc = int(entry.pc)
+	vm.ctx.stp = int(entry.stp)
+}
+
+func (vm *VM) inPlaceIf() {
+	if vm.popUint32() == 0 {
+		vm.takeBranch(&vm.ctx.sideTable[vm.ctx.stp])
+		return
+	}
+	vm.ctx.stp++
+	vm.skipBlockType()
+}
+
+// inPlaceBr serves both br and else, which is reached at the end of then branch
+func (vm *VM) inPlaceBr() {
+	vm.takeBranch(&vm.ctx.sideTable[vm.ctx.stp])
+}
+
+func (vm *VM) inPlaceBrIf() {
+	if vm.popUint32() != 0 {
+		vm.takeBranch(&vm.ctx.sideTable[vm.ctx.stp])
+		return
+	}
+	vm.ctx.stp++
+	vm.fetchVarUint32()
+}
+
+func (vm *VM) inPlaceBrTable() {
+	n := vm.fetchVarUint32()
+	idx := vm.popUint32()
+	if idx > n {
+		idx = n
+	}
+	vm.takeBranch(&vm.ctx.sideTable[vm.ctx.stp+int(idx)])
+}
+
+func (vm *VM) inPlaceRet() {
+	vm.ctx.pc = len(vm.ctx.raw)
+}
@@ -0,0 +1,319 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// MemoryInstance is a linear memory, it's either defined by a module or provided by host
+// and may be shared between the instances importing it
+type MemoryInstance struct {
+	Data []byte
+	// limit of growth in pages, nil means that memory can grow up to 65535 pages
+	Max *uint32
+	// limit of growth imposed by RuntimeConfig of the instances using memory, zero if there's none
+	limit uint32
+}
+
+// NewMemoryInstance allocates memory of min pages
+func NewMemoryInstance(min uint32, max *uint32) *MemoryInstance {
+	return &MemoryInstance{Data: make([]byte, int(min)*wasmPageSize), Max: max}
+}
+
+// Pages returns current size of memory in pages
+func (mem *MemoryInstance) Pages() uint32 {
+	return uint32(len(mem.Data) / wasmPageSize)
+}
+
+// restrict makes memory grow up to pages at most
+func (mem *MemoryInstance) restrict(pages uint32) {
+	if mem.limit == 0 || pages < mem.limit {
+		mem.limit = pages
+	}
+}
+
+// grow extends memory by delta pages returning its previous size in pages, or -1 if
+// either the limits of memory or limit pages don't permit such an increase
+func (mem *MemoryInstance) grow(delta, limit uint32) int32 {
+	pages := mem.Pages()
+	max := uint64(limit)
+	if mem.limit != 0 && uint64(mem.limit) < max {
+		max = uint64(mem.limit)
+	}
+	if mem.Max != nil && uint64(*mem.Max) < max {
+		max = uint64(*mem.Max)
+	}
+	if uint64(pages)+uint64(delta) > max {
+		return -1
+	}
+	if delta > 0 {
+		data := make([]byte, (int(pages)+int(delta))*wasmPageSize)
+		copy(data, mem.Data)
+		mem.Data = data
+	}
+	return int32(pages)
+}
+
+// FuncRef refers to a function of a particular instance
+type FuncRef struct {
+	vm    *VM
+	index uint32
+}
+
+// funcRef returns reference to function at index, the same one each time, so that
+// the reference gets a single handle while it's passed around the operand stack
+func (vm *VM) funcRef(index uint32) *FuncRef {
+	if vm.funcRefs == nil {
+		vm.funcRefs = make([]*FuncRef, len(vm.funcs))
+	}
+	if vm.funcRefs[index] == nil {
+		vm.funcRefs[index] = &FuncRef{vm: vm, index: index}
+	}
+	return vm.funcRefs[index]
+}
+
+// TableInstance is a table of references of type Type, only one of the slices of elements
+// is used depending on the type. Nil elements are null references
+type TableInstance struct {
+	Type ElementType
+	// elements of a table of function references
+	Elements []*FuncRef
+	// elements of a table of external references
+	Externs []any
+	Max     *uint32
+	// limit of growth imposed by RuntimeConfig of the instances using the table, zero if there's none
+	limit uint32
+}
+
+// NewTableInstance creates table of min null references of type typ
+func NewTableInstance(typ ElementType, min uint32, max *uint32) *TableInstance {
+	table := &TableInstance{Type: typ, Max: max}
+	if typ == ExternRefElementType {
+		table.Externs = make([]any, min)
+	} else {
+		table.Elements = make([]*FuncRef, min)
+	}
+	return table
+}
+
+// GlobalInstance is a global variable, its value is stored the same way as on the operand stack
+// except for references, whose host values are kept in Ref, *FuncRef for function references
+type GlobalInstance struct {
+	Type    types.ValueType
+	Mutable bool
+	Value   uint64
+	Ref     any
+}
+
+func NewGlobalInstance(typ types.ValueType, mutable bool, value uint64) *GlobalInstance {
+	return &GlobalInstance{Type: typ, Mutable: mutable, Value: value}
+}
+
+var ErrImmutableGlobal = errors.New("exec: global is immutable")
+
+// Set assigns value of type typ to the global, which has to be mutable and of the same type
+func (g *GlobalInstance) Set(typ types.ValueType, value uint64) error {
+	if !g.Mutable {
+		return ErrImmutableGlobal
+	}
+	if typ != g.Type {
+		return fmt.Errorf("exec: can't assign %v value to %v global", typ, g.Type)
+	}
+	g.Value = value
+	return nil
+}
+
+// IncompatibleImportError is returned on instantiation of a module whose memory, table or global
+// import is provided with an object not matching the type declared by the module
+type IncompatibleImportError struct {
+	Module, Name string
+	Reason       string
+}
+
+func (e IncompatibleImportError) Error() string {
+	return fmt.Sprintf("exec: incompatible import %s.%s: %s", e.Module, e.Name, e.Reason)
+}
+
+var (
+	ErrDataSegmentDoesNotFit    = errors.New("exec: data segment does not fit")
+	ErrElementSegmentDoesNotFit = errors.New("exec: elements segment does not fit")
+)
+
+// limitsMatch reports whether an object of size and max matches declared limits
+func limitsMatch(size uint32, max *uint32, limits ResizableLimits) bool {
+	if size < limits.Minimum {
+		return false
+	}
+	if limits.Maximum == nil {
+		return true
+	}
+	return max != nil && *max <= *limits.Maximum
+}
+
+// resolveExternals populates memory, table and global index spaces of the instance, imported
+// objects come first and are provided by resolver
+func (vm *VM) resolveExternals(resolver ImportResolver) error {
+	m := vm.module
+	var memories []*MemoryInstance
+	for _, entry := range m.importsOf(MemoryKind) {
+		var mem *MemoryInstance
+		ok := false
+		if resolver != nil {
+			mem, ok = resolver.ResolveMemory(entry.ModuleName, entry.ExportName)
+		}
+		if !ok {
+			return UnresolvedImportError{Module: entry.ModuleName, Name: entry.ExportName}
+		}
+		if !limitsMatch(mem.Pages(), mem.Max, entry.Description.(*MemoryKindDesc).Limits) {
+			return IncompatibleImportError{Module: entry.ModuleName, Name: entry.ExportName, Reason: "memory limits mismatch"}
+		}
+		mem.restrict(vm.config.MaxMemoryPages)
+		memories = append(memories, mem)
+	}
+	if m.MemorySection != nil {
+		for _, entry := range m.MemorySection.Entries {
+			mem := NewMemoryInstance(entry.Limits.Minimum, entry.Limits.Maximum)
+			mem.restrict(vm.config.MaxMemoryPages)
+			memories = append(memories, mem)
+		}
+	}
+	if len(memories) > 1 {
+		return errors.New("newVM: expected to have exactly one instance of memory")
+	}
+	if len(memories) == 1 {
+		vm.memory = memories[0]
+	}
+
+	for _, entry := range m.importsOf(TableKind) {
+		var table *TableInstance
+		ok := false
+		if resolver != nil {
+			table, ok = resolver.ResolveTable(entry.ModuleName, entry.ExportName)
+		}
+		if !ok {
+			return UnresolvedImportError{Module: entry.ModuleName, Name: entry.ExportName}
+		}
+		desc := entry.Description.(*TableKindDesc)
+		if table.Type != desc.Table.ElemType {
+			return IncompatibleImportError{Module: entry.ModuleName, Name: entry.ExportName, Reason: "table element type mismatch"}
+		}
+		if !limitsMatch(table.Size(), table.Max, desc.Table.Limits) {
+			return IncompatibleImportError{Module: entry.ModuleName, Name: entry.ExportName, Reason: "table limits mismatch"}
+		}
+		table.restrict(vm.config.MaxTableSize)
+		vm.tables = append(vm.tables, table)
+	}
+	if m.TableSection != nil {
+		for _, entry := range m.TableSection.Entries {
+			table := NewTableInstance(entry.ElemType, entry.Limits.Minimum, entry.Limits.Maximum)
+			table.restrict(vm.config.MaxTableSize)
+			vm.tables = append(vm.tables, table)
+		}
+	}
+
+	for _, entry := range m.importsOf(GlobalKind) {
+		var global *GlobalInstance
+		ok := false
+		if resolver != nil {
+			global, ok = resolver.ResolveGlobal(entry.ModuleName, entry.ExportName)
+		}
+		if !ok {
+			return UnresolvedImportError{Module: entry.ModuleName, Name: entry.ExportName}
+		}
+		desc := entry.Description.(*GlobalKindDesc)
+		if global.Type != desc.Type || global.Mutable != desc.Mutable {
+			return IncompatibleImportError{Module: entry.ModuleName, Name: entry.ExportName, Reason: fmt.Sprintf(
+				"expected global of type %v (mutable: %v), got %v (mutable: %v)", desc.Type, desc.Mutable, global.Type, global.Mutable)}
+		}
+		vm.globals = append(vm.globals, global)
+	}
+	if m.GlobalSection != nil {
+		for _, entry := range m.GlobalSection.Entries {
+			val, typ, err := vm.execInitExpr(entry.Init)
+			if err != nil {
+				return err
+			}
+			if typ != entry.Description.Type {
+				return InvalidValueTypeInitExprError{Wanted: entry.Description.Type, Got: typ}
+			}
+			global := NewGlobalInstance(typ, entry.Description.Mutable, val)
+			if isReference(typ) {
+				global.Value = 0
+				global.Ref, _ = vm.ExternValue(val)
+			}
+			vm.globals = append(vm.globals, global)
+		}
+	}
+	return nil
+}
+
+// initSegments copies elements and data segments into tables and memory of the instance,
+// all segments are checked to fit before any of them is applied
+func (vm *VM) initSegments() error {
+	m := vm.module
+	var elemOffsets, dataOffsets []uint32
+	if m.ElementSection != nil {
+		for _, entry := range m.ElementSection.Entries {
+			offset, err := vm.segmentOffset(entry.Offset)
+			if err != nil {
+				return err
+			}
+			if int(entry.Index) >= len(vm.tables) {
+				return InvalidTableIndexError(entry.Index)
+			}
+			if vm.tables[entry.Index].Type != FuncRefElementType {
+				return fmt.Errorf("exec: elements segment refers to table %d of non-function references", entry.Index)
+			}
+			if uint64(offset)+uint64(len(entry.Elems)) > uint64(len(vm.tables[entry.Index].Elements)) {
+				return ErrElementSegmentDoesNotFit
+			}
+			for _, index := range entry.Elems {
+				if int(index) >= len(vm.funcs) {
+					return fmt.Errorf("exec: elements segment refers to unknown function %d", index)
+				}
+			}
+			elemOffsets = append(elemOffsets, offset)
+		}
+	}
+	if m.DataSection != nil {
+		for _, entry := range m.DataSection.Entries {
+			offset, err := vm.segmentOffset(entry.Offset)
+			if err != nil {
+				return err
+			}
+			if entry.Index != 0 || vm.memory == nil {
+				return InvalidLinearMemoryIndexError(entry.Index)
+			}
+			if uint64(offset)+uint64(len(entry.Data)) > uint64(len(vm.memory.Data)) {
+				return ErrDataSegmentDoesNotFit
+			}
+			dataOffsets = append(dataOffsets, offset)
+		}
+	}
+
+	for i, offset := range elemOffsets {
+		entry := m.ElementSection.Entries[i]
+		table := vm.tables[entry.Index]
+		for j, index := range entry.Elems {
+			table.Elements[int(offset)+j] = vm.funcRef(index)
+		}
+	}
+	for i, offset := range dataOffsets {
+		entry := m.DataSection.Entries[i]
+		copy(vm.memory.Data[offset:], entry.Data)
+	}
+	return nil
+}
+
+func (vm *VM) segmentOffset(expr []byte) (uint32, error) {
+	val, typ, err := vm.execInitExpr(expr)
+	if err != nil {
+		return 0, err
+	}
+	if typ != types.ValueTypeI32 {
+		return 0, InvalidValueTypeInitExprError{Wanted: types.ValueTypeI32, Got: typ}
+	}
+	return uint32(val), nil
+}
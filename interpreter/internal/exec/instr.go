@@ -1,10 +1,11 @@
 package exec
 
 import (
+	"errors"
 	"fmt"
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
 	"github.com/threadedstream/wasmexperiments/internal/types"
+	"math"
 	"strings"
 )
 
@@ -48,19 +49,19 @@ func newDoubleArgI(op Op, arg0, arg1 any) Instr {
 	}
 	switch op.Code {
 	default:
-		reporter.ReportError("unexpected double arg instruction with opcode %v", op.Code)
-	case i32AddOp:
-		return &I32AddI{inner}
-	case i32SubOp:
-		return &I32SubI{inner}
-	case i32MulOp:
-		return &I32MulI{inner}
-	case i32DivUOp:
-		return &I32DivUI{inner}
-	case i32DivSOp:
-		return &I32DivSI{inner}
+		return inner
+	case i32LoadOp:
+		return &I32LoadI{inner}
+	case i32StoreOp:
+		return &I32StoreI{inner}
+	case callIndirectOp:
+		return &CallIndirectI{inner}
 	}
-	panic("unreachable")
+}
+
+// args returns immediates of an instruction
+func (di doubleArgI) args() (any, any) {
+	return di.arg0, di.arg1
 }
 
 type singleArgI struct {
@@ -85,7 +86,7 @@ func newSingleArgI(op Op, arg0 any) Instr {
 	}
 	switch op.Code {
 	default:
-		reporter.ReportError("unexpected single arg instruction with opcode %v", op.Code)
+		return inner
 	case i32ConstOp:
 		return &I32ConstI{inner}
 	case callOp:
@@ -96,12 +97,18 @@ func newSingleArgI(op Op, arg0 any) Instr {
 		return &GlobalGetI{inner}
 	case localSetOp:
 		return &LocalSetI{inner}
+	case globalSetOp:
+		return &GlobalSetI{inner}
 	case brOp:
-		return &BrI{inner, ""}
+		return &BrI{inner}
 	case brIfOp:
-		return &BrIfI{inner, ""}
+		return &BrIfI{inner}
 	}
-	panic("unreachable")
+}
+
+// args returns immediates of an instruction
+func (si singleArgI) args() (any, any) {
+	return si.arg0, nil
 }
 
 type noArgI struct {
@@ -118,7 +125,17 @@ func newNoArgI(op Op) Instr {
 	}
 	switch op.Code {
 	default:
-		reporter.ReportError("unexpected no arg instruction with opcode %v", op.Code)
+		return inner
+	case i32AddOp:
+		return &I32AddI{inner}
+	case i32SubOp:
+		return &I32SubI{inner}
+	case i32MulOp:
+		return &I32MulI{inner}
+	case i32DivUOp:
+		return &I32DivUI{inner}
+	case i32DivSOp:
+		return &I32DivSI{inner}
 	case i32EqOp:
 		return &I32EqI{inner}
 	case endOp:
@@ -128,7 +145,26 @@ func newNoArgI(op Op) Instr {
 	case i32LtSOp:
 		return &I32LtSI{inner}
 	}
-	panic("unreachable")
+}
+
+// readTypeIndex decodes block type referring to a function type, which is encoded as
+// a non-negative s33 starting with the byte b
+func readTypeIndex(b byte, reader *wasm_reader.WasmReader) (uint32, error) {
+	val := uint64(b & 0x7f)
+	for shift := uint(7); b&0x80 != 0; shift += 7 {
+		if shift > 28 {
+			return 0, errors.New("block type index is too long")
+		}
+		var err error
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+		val |= uint64(b&0x7f) << shift
+	}
+	if b&0x40 != 0 || val > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid block type")
+	}
+	return uint32(val), nil
 }
 
 type blockTypedI struct {
@@ -143,7 +179,11 @@ func (i *blockTypedI) resolveBlockType(reader *wasm_reader.WasmReader) error {
 	}
 	switch valty := types.ValueType(b); valty {
 	default:
-		i.blockType = types.OtherBlockType{X: int64(valty)}
+		index, err := readTypeIndex(b, reader)
+		if err != nil {
+			return err
+		}
+		i.blockType = types.OtherBlockType{X: int64(index)}
 	case types.ValueTypeEmpty:
 		i.blockType = types.EmptyBlockType{}
 	case types.ValueTypeI32, types.ValueTypeF32, types.ValueTypeI64, types.ValueTypeF64, types.ValueTypeVector, types.ValueTypeFuncRef, types.ValueTypeExternRef:
@@ -154,23 +194,23 @@ func (i *blockTypedI) resolveBlockType(reader *wasm_reader.WasmReader) error {
 
 type (
 	I32AddI struct {
-		doubleArgI
+		noArgI
 	}
 
 	I32SubI struct {
-		doubleArgI
+		noArgI
 	}
 
 	I32MulI struct {
-		doubleArgI
+		noArgI
 	}
 
 	I32DivUI struct {
-		doubleArgI
+		noArgI
 	}
 
 	I32DivSI struct {
-		doubleArgI
+		noArgI
 	}
 
 	I32LoadI struct {
@@ -205,6 +245,10 @@ type (
 		singleArgI
 	}
 
+	CallIndirectI struct {
+		doubleArgI
+	}
+
 	I32EqI struct {
 		noArgI
 	}
@@ -239,15 +283,23 @@ type (
 
 	BrI struct {
 		singleArgI
-		context string // useful when executing "br" inside "block" or "loop" constructs
 	}
 
 	BrIfI struct {
 		singleArgI
-		context string // useful when executing "br_if" inside "block" or "loop" constructs
+	}
+
+	BrTableI struct {
+		commonI
+		labels       []uint32
+		defaultLabel uint32
 	}
 )
 
+func (bt BrTableI) String() string {
+	return fmt.Sprintf("%s %v %d", bt.Op().Name, bt.labels, bt.defaultLabel)
+}
+
 func Dump(is []Instr) {
 	s := strings.Builder{}
 	for _, i := range is {
@@ -0,0 +1,142 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrInterrupted = errors.New("exec: execution interrupted")
+
+// ContextDoneError is the trap of a call whose context is canceled or past its deadline,
+// it matches both ErrInterrupted and the error of the context
+type ContextDoneError struct {
+	Err error
+}
+
+func (e ContextDoneError) Error() string {
+	return "exec: execution interrupted: " + e.Err.Error()
+}
+
+func (e ContextDoneError) Unwrap() error {
+	return e.Err
+}
+
+func (e ContextDoneError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+// interruption is requested from other goroutines, execution polls it at loop back-edges and calls.
+// Each outermost call starts a new generation, a request applies to the generation in progress or,
+// if the instance is idle, to the next one, so it's neither lost nor carried over to later calls
+type interruption struct {
+	requested uint32
+	mu        sync.Mutex
+	cause     error
+	// generation the request applies to
+	target uint64
+	// generation of the last outermost call and whether it's in progress
+	gen     uint64
+	running bool
+}
+
+// Interrupt stops the call in progress, which traps with ErrInterrupted on the next iteration
+// of a loop or call. If no call is in progress, the next one is stopped. It may be called
+// from any goroutine
+func (vm *VM) Interrupt() {
+	in := &vm.interruption
+	in.mu.Lock()
+	gen := in.gen
+	if !in.running {
+		gen++
+	}
+	in.request(gen, ErrInterrupted)
+	in.mu.Unlock()
+}
+
+// interruptCall stops the call of generation gen unless it has finished already
+func (vm *VM) interruptCall(gen uint64, cause error) {
+	in := &vm.interruption
+	in.mu.Lock()
+	if in.running && in.gen == gen {
+		in.request(gen, cause)
+	}
+	in.mu.Unlock()
+}
+
+func (in *interruption) request(gen uint64, cause error) {
+	if atomic.LoadUint32(&in.requested) == 0 || in.target < gen {
+		in.cause, in.target = cause, gen
+	}
+	atomic.StoreUint32(&in.requested, 1)
+}
+
+// beginCall starts a new generation for the outermost call, requests made for earlier ones
+// are forgotten
+func (vm *VM) beginCall() uint64 {
+	in := &vm.interruption
+	in.mu.Lock()
+	in.gen++
+	in.running = true
+	if in.target < in.gen {
+		in.cause = nil
+		atomic.StoreUint32(&in.requested, 0)
+	}
+	gen := in.gen
+	in.mu.Unlock()
+	return gen
+}
+
+func (vm *VM) endCall() {
+	vm.interruption.mu.Lock()
+	vm.interruption.running = false
+	vm.interruption.mu.Unlock()
+}
+
+// checkInterrupt traps if the instance or one of its callers down the call chain is interrupted
+func (vm *VM) checkInterrupt() {
+	if atomic.LoadUint32(&vm.interruption.requested) != 0 {
+		vm.interruption.mu.Lock()
+		cause := vm.interruption.cause
+		vm.interruption.mu.Unlock()
+		panic(cause)
+	}
+	if vm.caller != nil {
+		vm.caller.checkInterrupt()
+	}
+}
+
+// ExecFuncContext is the same as ExecFunc, but the call traps with ContextDoneError once
+// ctx is done. Host functions called meanwhile receive ctx
+func (vm *VM) ExecFuncContext(ctx context.Context, index int64, args ...uint64) ([]uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ContextDoneError{Err: err}
+	}
+	var gen uint64
+	if len(vm.frames) == 0 {
+		gen = vm.beginCall()
+		defer vm.endCall()
+	} else {
+		vm.interruption.mu.Lock()
+		gen = vm.interruption.gen
+		vm.interruption.mu.Unlock()
+	}
+	prev := vm.callCtx
+	vm.callCtx = ctx
+	defer func() {
+		vm.callCtx = prev
+	}()
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				vm.interruptCall(gen, ContextDoneError{Err: ctx.Err()})
+			case <-stop:
+			}
+		}()
+	}
+	return vm.execFunc(index, args...)
+}
@@ -0,0 +1,84 @@
+package exec_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// interruptModule imports env.hook, its function "spin" loops forever, "noop" does nothing
+// and "hook" calls env.hook as its last instruction
+func interruptModule(t *testing.T, mode exec.ExecMode, hook exec.HostFunc) *exec.VM {
+	t.Helper()
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, nil)},
+		Imports: [][]byte{ImportFunc("env", "hook", 0)},
+		Funcs: []Func{
+			{Type: 0, Code: B(0x03, 0x40, 0x0c, 0, 0x0b)},
+			{Type: 0},
+			{Type: 0, Code: B(0x10, 0)},
+		},
+		Exports: [][]byte{ExportFunc("spin", 1), ExportFunc("noop", 2), ExportFunc("hook", 3)},
+	}
+	mod, err := exec.ReadModule(m.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := exec.NewHostModule("env")
+	env.AddFunction("hook", &exec.HostFunction{Sig: &exec.FunctionSig{}, Fn: hook})
+	vm, err := exec.NewVM(mod, exec.WithImportResolver(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.SetExecMode(mode)
+	return vm
+}
+
+func TestInterrupt(t *testing.T) {
+	for _, mode := range execModes {
+		vm := interruptModule(t, mode, func(_ context.Context, vm *exec.VM, _ []uint64) {
+			// the call returns without polling the request
+			vm.Interrupt()
+		})
+
+		// request made while the instance is idle stops the next call only
+		vm.Interrupt()
+		if _, err := call(t, vm, "spin"); !errors.Is(err, exec.ErrInterrupted) {
+			t.Fatalf("spin interrupted before the call in %s mode: %v", modeName(mode), err)
+		}
+		if _, err := call(t, vm, "noop"); err != nil {
+			t.Fatalf("noop after interrupted call in %s mode: %v", modeName(mode), err)
+		}
+
+		// request made during a call isn't carried over to the next one
+		if _, err := call(t, vm, "hook"); err != nil {
+			t.Fatalf("hook in %s mode: %v", modeName(mode), err)
+		}
+		if _, err := call(t, vm, "noop"); err != nil {
+			t.Fatalf("noop after call interrupted at its end in %s mode: %v", modeName(mode), err)
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			vm.Interrupt()
+		}()
+		if _, err := call(t, vm, "spin"); !errors.Is(err, exec.ErrInterrupted) {
+			t.Fatalf("spin interrupted by another goroutine in %s mode: %v", modeName(mode), err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		index, _ := vm.QueryFunction("spin")
+		_, err := vm.ExecFuncContext(ctx, int64(index))
+		cancel()
+		if !errors.Is(err, exec.ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("spin past deadline in %s mode: %v", modeName(mode), err)
+		}
+		if _, err := call(t, vm, "noop"); err != nil {
+			t.Fatalf("noop after context is done in %s mode: %v", modeName(mode), err)
+		}
+	}
+}
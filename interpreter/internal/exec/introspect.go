@@ -0,0 +1,160 @@
+package exec
+
+import "fmt"
+
+// ExternType is the type of an import or export, only the fields relevant to Kind are set
+type ExternType struct {
+	Kind ExternalKind
+	// signature of a function
+	Func *FunctionSig
+	// type of elements of a table
+	ElemType ElementType
+	// limits of a table in elements or of a memory in pages
+	Limits ResizableLimits
+	// type of a global
+	Global GlobalKindDesc
+}
+
+func (t ExternType) String() string {
+	switch t.Kind {
+	case FunctionKind:
+		return fmt.Sprintf("func %v -> %v", t.Func.Params, t.Func.Results)
+	case TableKind:
+		return fmt.Sprintf("table %s funcref", limitsString(t.Limits))
+	case MemoryKind:
+		return fmt.Sprintf("memory %s", limitsString(t.Limits))
+	case GlobalKind:
+		if t.Global.Mutable {
+			return fmt.Sprintf("global mut %v", t.Global.Type)
+		}
+		return fmt.Sprintf("global %v", t.Global.Type)
+	}
+	return t.Kind.String()
+}
+
+func limitsString(limits ResizableLimits) string {
+	if limits.Maximum == nil {
+		return fmt.Sprintf("%d", limits.Minimum)
+	}
+	return fmt.Sprintf("%d..%d", limits.Minimum, *limits.Maximum)
+}
+
+type ImportType struct {
+	Module, Name string
+	Type         ExternType
+}
+
+type ExportType struct {
+	Name string
+	Type ExternType
+}
+
+// Imports returns imports of the module in order of their declaration
+func (m *Module) Imports() []ImportType {
+	if m.ImportSection == nil {
+		return nil
+	}
+	imports := make([]ImportType, 0, len(m.ImportSection.Entries))
+	for _, entry := range m.ImportSection.Entries {
+		imports = append(imports, ImportType{Module: entry.ModuleName, Name: entry.ExportName, Type: m.importType(entry)})
+	}
+	return imports
+}
+
+// Import looks up import of field name from module
+func (m *Module) Import(module, name string) (ImportType, bool) {
+	for _, imp := range m.Imports() {
+		if imp.Module == module && imp.Name == name {
+			return imp, true
+		}
+	}
+	return ImportType{}, false
+}
+
+// Exports returns exports of the module in order of their declaration, exports referring
+// to unknown objects are omitted
+func (m *Module) Exports() []ExportType {
+	if m.ExportSection == nil {
+		return nil
+	}
+	exports := make([]ExportType, 0, len(m.ExportSection.Ordered))
+	for _, entry := range m.ExportSection.Ordered {
+		if typ, ok := m.exportType(entry); ok {
+			exports = append(exports, ExportType{Name: entry.Name, Type: typ})
+		}
+	}
+	return exports
+}
+
+// Export looks up export by name
+func (m *Module) Export(name string) (ExportType, bool) {
+	if m.ExportSection == nil {
+		return ExportType{}, false
+	}
+	entry, ok := m.ExportSection.Entries[name]
+	if !ok {
+		return ExportType{}, false
+	}
+	typ, ok := m.exportType(entry)
+	return ExportType{Name: name, Type: typ}, ok
+}
+
+func (m *Module) importType(entry *ImportEntry) ExternType {
+	typ := ExternType{Kind: entry.Description.Kind()}
+	switch desc := entry.Description.(type) {
+	case *FunctionKindDesc:
+		typ.Func, _ = m.sig(desc.SigIndex)
+	case *TableKindDesc:
+		typ.ElemType, typ.Limits = desc.Table.ElemType, desc.Table.Limits
+	case *MemoryKindDesc:
+		typ.Limits = desc.Limits
+	case *GlobalKindDesc:
+		typ.Global = *desc
+	}
+	return typ
+}
+
+func (m *Module) exportType(entry *ExportEntry) (ExternType, bool) {
+	typ := ExternType{Kind: entry.Kind}
+	ok := false
+	switch entry.Kind {
+	case FunctionKind:
+		typ.Func, ok = m.functionSig(entry.Index)
+	case TableKind:
+		var table *Table
+		if table, ok = m.table(entry.Index); ok {
+			typ.ElemType, typ.Limits = table.ElemType, table.Limits
+		}
+	case MemoryKind:
+		typ.Limits, ok = m.memory(entry.Index)
+	case GlobalKind:
+		typ.Global, ok = m.globalType(entry.Index)
+	}
+	return typ, ok
+}
+
+// table returns type of a table from the table index space, imported tables come first
+func (m *Module) table(index uint32) (*Table, bool) {
+	imported := m.importsOf(TableKind)
+	if int(index) < len(imported) {
+		return &imported[index].Description.(*TableKindDesc).Table, true
+	}
+	index -= uint32(len(imported))
+	if m.TableSection == nil || int(index) >= len(m.TableSection.Entries) {
+		return nil, false
+	}
+	return m.TableSection.Entries[index], true
+}
+
+// memory returns limits of a memory from the memory index space, imported memories come first
+func (m *Module) memory(index uint32) (ResizableLimits, bool) {
+	imported := m.importsOf(MemoryKind)
+	if int(index) < len(imported) {
+		return imported[index].Description.(*MemoryKindDesc).Limits, true
+	}
+	index -= uint32(len(imported))
+	if m.MemorySection == nil || int(index) >= len(m.MemorySection.Entries) {
+		return ResizableLimits{}, false
+	}
+	return m.MemorySection.Entries[index].Limits, true
+}
@@ -3,18 +3,52 @@ package exec
 import (
 	"encoding/binary"
 	"errors"
+
 	"github.com/threadedstream/wasmexperiments/internal/types"
 )
 
 var (
-	localGetOp  = newVarargOp("local.get", 0x20)
-	localSetOp  = newVarargOp("local.set", 0x21)
-	globalGetOp = newVarargOp("global.get", 0x23)
-	globalSetOp = newVarargOp("global.set", 0x24)
-	i32LoadOp   = newOp("i32.load", 0x28, types.ValueTypeDoubleI32, types.ValueTypeSingleI32)
-	f32LoadOp   = newOp("f32.load", 0x2a, types.ValueTypeDoubleI32, types.ValueTypeSingleF32)
-	i32StoreOp  = newOp("i32.store", 0x36, types.ValueTypeDoubleI32, types.ValueTypeVoid)
-	f32StoreOp  = newOp("f32.store", 0x38, types.ValueTypeDoubleI32, types.ValueTypeVoid)
+	localGetOp   = newVarargOp("local.get", 0x20)
+	localSetOp   = newVarargOp("local.set", 0x21)
+	localTeeOp   = newVarargOp("local.tee", 0x22)
+	globalGetOp  = newVarargOp("global.get", 0x23)
+	globalSetOp  = newVarargOp("global.set", 0x24)
+	i32LoadOp    = newOp("i32.load", 0x28, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i64LoadOp    = newOp("i64.load", 0x29, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	f32LoadOp    = newOp("f32.load", 0x2A, types.ValueTypeSingleI32, types.ValueTypeSingleF32)
+	f64LoadOp    = newOp("f64.load", 0x2B, types.ValueTypeSingleI32, types.ValueTypeSingleF64)
+	i32Load8SOp  = newOp("i32.load8_s", 0x2C, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32Load8UOp  = newOp("i32.load8_u", 0x2D, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32Load16SOp = newOp("i32.load16_s", 0x2E, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i32Load16UOp = newOp("i32.load16_u", 0x2F, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+	i64Load8SOp  = newOp("i64.load8_s", 0x30, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64Load8UOp  = newOp("i64.load8_u", 0x31, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64Load16SOp = newOp("i64.load16_s", 0x32, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64Load16UOp = newOp("i64.load16_u", 0x33, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64Load32SOp = newOp("i64.load32_s", 0x34, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i64Load32UOp = newOp("i64.load32_u", 0x35, types.ValueTypeSingleI32, types.ValueTypeSingleI64)
+	i32StoreOp   = newOp("i32.store", 0x36, types.ValueTypeDoubleI32, types.ValueTypeVoid)
+	i64StoreOp   = newOp("i64.store", 0x37, types.ValueTypeI32I64, types.ValueTypeVoid)
+	f32StoreOp   = newOp("f32.store", 0x38, types.ValueTypeI32F32, types.ValueTypeVoid)
+	f64StoreOp   = newOp("f64.store", 0x39, types.ValueTypeI32F64, types.ValueTypeVoid)
+	i32Store8Op  = newOp("i32.store8", 0x3A, types.ValueTypeDoubleI32, types.ValueTypeVoid)
+	i32Store16Op = newOp("i32.store16", 0x3B, types.ValueTypeDoubleI32, types.ValueTypeVoid)
+	i64Store8Op  = newOp("i64.store8", 0x3C, types.ValueTypeI32I64, types.ValueTypeVoid)
+	i64Store16Op = newOp("i64.store16", 0x3D, types.ValueTypeI32I64, types.ValueTypeVoid)
+	i64Store32Op = newOp("i64.store32", 0x3E, types.ValueTypeI32I64, types.ValueTypeVoid)
+	memorySizeOp = newOp("memory.size", 0x3F, types.ValueTypeVoid, types.ValueTypeSingleI32)
+	memoryGrowOp = newOp("memory.grow", 0x40, types.ValueTypeSingleI32, types.ValueTypeSingleI32)
+)
+
+const (
+	// number of pages addressable with 32-bit memory, memories may declare it as their maximum
+	addressablePages = 65536
+	// maximum number of pages of memory, one page less than addressable with 32-bit memory
+	// so that the size of memory in bytes fits uint32, see Memory.Size
+	maxMemoryPages = 65535
+	// default limit of memory size, 1GiB, memory is allocated upfront, so a module declaring
+	// its minimum at 32-bit maximum would cost the host 4GiB on each instantiation otherwise
+	defaultMemoryPages = 16384
 )
 
 var (
@@ -22,94 +56,141 @@ var (
 )
 
 func (vm *VM) getLocal() {
-	in := vm.currIns().(*LocalGetI)
-	index := in.arg0.(uint32)
-	vm.pushUint64(vm.ctx.locals[index])
-	vm.ctx.pc++
+	vm.pushUint64(vm.stack[vm.ctx.locals+int(vm.currIns().arg0)])
 }
 
 func (vm *VM) setLocal() {
-	in := vm.currIns().(*LocalSetI)
-	index := in.arg0.(uint32)
-	value := vm.popUint64()
-	vm.ctx.locals[index] = value
-	vm.ctx.pc++
+	vm.stack[vm.ctx.locals+int(vm.currIns().arg0)] = vm.popUint64()
+}
+
+func (vm *VM) teeLocal() {
+	vm.stack[vm.ctx.locals+int(vm.currIns().arg0)] = vm.peekUint64()
 }
 
 func (vm *VM) getGlobal() {
-	in := vm.currIns().(*GlobalGetI)
-	index := in.arg0.(uint32)
-	vm.pushUint64(vm.globals[index])
-	vm.ctx.pc++
+	g := vm.globals[vm.currIns().arg0]
+	if isReference(g.Type) {
+		vm.pushUint64(vm.ExternRef(g.Ref))
+		return
+	}
+	vm.pushUint64(g.Value)
 }
 
 func (vm *VM) setGlobal() {
-	in := vm.currIns().(*GlobalSetI)
-	index := in.arg0.(uint32)
-	value := vm.popUint64()
-	vm.globals[index] = value
-	vm.ctx.pc++
+	g := vm.globals[vm.currIns().arg0]
+	if isReference(g.Type) {
+		g.Ref, _ = vm.ExternValue(vm.popUint64())
+		return
+	}
+	g.Value = vm.popUint64()
 }
 
-func (vm *VM) teeLocal() {
-	panic("unreachable")
+// effectiveAddr pops base address off the stack and returns a slice of memory of size n
+// the current instruction refers to, traps if it happens to be out of bounds
+func (vm *VM) effectiveAddr(n uint64) []byte {
+	addr := uint64(vm.popUint32()) + uint64(vm.currIns().arg0)
+	if !vm.inBounds(addr, n) {
+		panic(ErrOutOfMemory)
+	}
+	return vm.memory.Data[addr : addr+n]
+}
+
+func (vm *VM) inBounds(addr, n uint64) bool {
+	return addr+n <= uint64(len(vm.memory.Data))
 }
 
 func (vm *VM) i32Load() {
-	in := vm.currIns().(*I32LoadI)
-	base := int(vm.peekUint32())
-	ioff := int(in.arg1.(int32))
-	off := 3
-	if !vm.inBounds(base, ioff, off) {
-		panic(ErrOutOfMemory)
-	}
-	valueAt := vm.memory[(base + ioff):]
-	vm.pushUint32(binary.LittleEndian.Uint32(valueAt))
-	vm.ctx.pc++
+	vm.pushUint32(binary.LittleEndian.Uint32(vm.effectiveAddr(4)))
+}
+
+func (vm *VM) i64Load() {
+	vm.pushUint64(binary.LittleEndian.Uint64(vm.effectiveAddr(8)))
+}
+
+func (vm *VM) i32Load8S() {
+	vm.pushInt32(int32(int8(vm.effectiveAddr(1)[0])))
+}
+
+func (vm *VM) i32Load8U() {
+	vm.pushUint32(uint32(vm.effectiveAddr(1)[0]))
+}
+
+func (vm *VM) i32Load16S() {
+	vm.pushInt32(int32(int16(binary.LittleEndian.Uint16(vm.effectiveAddr(2)))))
+}
+
+func (vm *VM) i32Load16U() {
+	vm.pushUint32(uint32(binary.LittleEndian.Uint16(vm.effectiveAddr(2))))
+}
+
+func (vm *VM) i64Load8S() {
+	vm.pushInt64(int64(int8(vm.effectiveAddr(1)[0])))
+}
+
+func (vm *VM) i64Load8U() {
+	vm.pushUint64(uint64(vm.effectiveAddr(1)[0]))
+}
+
+func (vm *VM) i64Load16S() {
+	vm.pushInt64(int64(int16(binary.LittleEndian.Uint16(vm.effectiveAddr(2)))))
+}
+
+func (vm *VM) i64Load16U() {
+	vm.pushUint64(uint64(binary.LittleEndian.Uint16(vm.effectiveAddr(2))))
+}
+
+func (vm *VM) i64Load32S() {
+	vm.pushInt64(int64(int32(binary.LittleEndian.Uint32(vm.effectiveAddr(4)))))
+}
+
+func (vm *VM) i64Load32U() {
+	vm.pushUint64(uint64(binary.LittleEndian.Uint32(vm.effectiveAddr(4))))
 }
 
 func (vm *VM) i32Store() {
-	in := vm.currIns().(*I32StoreI)
 	val := vm.popUint32()
-	base := int(vm.peekUint32())
-	ioff := int(in.arg1.(int32))
-	off := 3
-	if !vm.inBounds(base, ioff, off) {
-		panic(ErrOutOfMemory)
-	}
-	effAddr := vm.memory[(base + ioff):]
-	binary.LittleEndian.PutUint32(effAddr, val)
-	vm.ctx.pc++
+	binary.LittleEndian.PutUint32(vm.effectiveAddr(4), val)
+}
+
+func (vm *VM) i64Store() {
+	val := vm.popUint64()
+	binary.LittleEndian.PutUint64(vm.effectiveAddr(8), val)
+}
+
+func (vm *VM) i32Store8() {
+	val := vm.popUint32()
+	vm.effectiveAddr(1)[0] = byte(val)
+}
+
+func (vm *VM) i32Store16() {
+	val := vm.popUint32()
+	binary.LittleEndian.PutUint16(vm.effectiveAddr(2), uint16(val))
 }
 
-func (vm *VM) f32Load() {
-	panic("unreachable")
-	//if !vm.inBounds(3) {
-	//	panic(ErrOutOfMemory)
-	//}
-	//vm.pushFloat32(math.Float32frombits(binary.LittleEndian.Uint32(vm.currMem())))
+func (vm *VM) i64Store8() {
+	val := vm.popUint64()
+	vm.effectiveAddr(1)[0] = byte(val)
 }
 
-func (vm *VM) f32Store() {
-	panic("unreachable")
-	//if !vm.inBounds(3) {
-	//	panic(ErrOutOfMemory)
-	//}
-	//v := math.Float32bits(vm.popFloat32())
-	//binary.LittleEndian.PutUint32(vm.currMem(), v)
+func (vm *VM) i64Store16() {
+	val := vm.popUint64()
+	binary.LittleEndian.PutUint16(vm.effectiveAddr(2), uint16(val))
 }
 
-func (vm *VM) inBounds(base, ioffset, offset int) bool {
-	addr := uint64(base) + uint64(ioffset)
-	return addr+uint64(offset) < uint64(len(vm.memory))
+func (vm *VM) i64Store32() {
+	val := vm.popUint64()
+	binary.LittleEndian.PutUint32(vm.effectiveAddr(4), uint32(val))
 }
 
-func (vm *VM) fetchEffectiveAddr() int {
-	baseAddr := vm.fetchUint32()
-	offset := vm.popInt32()
-	return int(baseAddr + uint32(offset))
+func (vm *VM) memorySize() {
+	vm.pushUint32(vm.memory.Pages())
 }
 
-func (vm *VM) currMem() []byte {
-	return vm.memory[vm.fetchEffectiveAddr():]
+func (vm *VM) memoryGrow() {
+	delta := vm.popUint32()
+	prev := vm.memory.grow(delta, vm.config.MaxMemoryPages)
+	if prev >= 0 {
+		vm.consumeFuelUnits(memoryGrowOp, uint64(delta))
+	}
+	vm.pushInt32(prev)
 }
@@ -0,0 +1,181 @@
+package exec
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Memory gives the host bounds-checked access to linear memory of an instance. Offsets are
+// in bytes, accessors report false rather than trapping when the access is out of bounds
+type Memory interface {
+	// Size returns current size of memory in bytes
+	Size() uint32
+	// Grow extends memory by delta pages returning its previous size in pages, growth is limited
+	// by MaxMemoryPages of the instances using memory as well
+	Grow(delta uint32) (uint32, bool)
+
+	// Read returns a view of n bytes at offset, which is invalidated once memory grows
+	Read(offset, n uint32) ([]byte, bool)
+	// Write copies bs to memory at offset
+	Write(offset uint32, bs []byte) bool
+	// ReadString returns a copy of n bytes at offset as a string
+	ReadString(offset, n uint32) (string, bool)
+
+	ReadUint8(offset uint32) (uint8, bool)
+	ReadUint16Le(offset uint32) (uint16, bool)
+	ReadUint32Le(offset uint32) (uint32, bool)
+	ReadUint64Le(offset uint32) (uint64, bool)
+	ReadFloat32Le(offset uint32) (float32, bool)
+	ReadFloat64Le(offset uint32) (float64, bool)
+	WriteUint8(offset uint32, v uint8) bool
+	WriteUint16Le(offset uint32, v uint16) bool
+	WriteUint32Le(offset uint32, v uint32) bool
+	WriteUint64Le(offset uint32, v uint64) bool
+	WriteFloat32Le(offset uint32, v float32) bool
+	WriteFloat64Le(offset uint32, v float64) bool
+
+	io.ReaderAt
+	io.WriterAt
+}
+
+var _ Memory = (*MemoryInstance)(nil)
+
+func (mem *MemoryInstance) Size() uint32 {
+	return uint32(len(mem.Data))
+}
+
+func (mem *MemoryInstance) Grow(delta uint32) (uint32, bool) {
+	prev := mem.grow(delta, maxMemoryPages)
+	if prev < 0 {
+		return 0, false
+	}
+	return uint32(prev), true
+}
+
+func (mem *MemoryInstance) Read(offset, n uint32) ([]byte, bool) {
+	if uint64(offset)+uint64(n) > uint64(len(mem.Data)) {
+		return nil, false
+	}
+	return mem.Data[offset : offset+n : offset+n], true
+}
+
+func (mem *MemoryInstance) Write(offset uint32, bs []byte) bool {
+	if uint64(offset)+uint64(len(bs)) > uint64(len(mem.Data)) {
+		return false
+	}
+	copy(mem.Data[offset:], bs)
+	return true
+}
+
+func (mem *MemoryInstance) ReadString(offset, n uint32) (string, bool) {
+	bs, ok := mem.Read(offset, n)
+	if !ok {
+		return "", false
+	}
+	return string(bs), true
+}
+
+func (mem *MemoryInstance) ReadUint8(offset uint32) (uint8, bool) {
+	if offset >= uint32(len(mem.Data)) {
+		return 0, false
+	}
+	return mem.Data[offset], true
+}
+
+func (mem *MemoryInstance) ReadUint16Le(offset uint32) (uint16, bool) {
+	bs, ok := mem.Read(offset, 2)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(bs), true
+}
+
+func (mem *MemoryInstance) ReadUint32Le(offset uint32) (uint32, bool) {
+	bs, ok := mem.Read(offset, 4)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(bs), true
+}
+
+func (mem *MemoryInstance) ReadUint64Le(offset uint32) (uint64, bool) {
+	bs, ok := mem.Read(offset, 8)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(bs), true
+}
+
+func (mem *MemoryInstance) ReadFloat32Le(offset uint32) (float32, bool) {
+	v, ok := mem.ReadUint32Le(offset)
+	return math.Float32frombits(v), ok
+}
+
+func (mem *MemoryInstance) ReadFloat64Le(offset uint32) (float64, bool) {
+	v, ok := mem.ReadUint64Le(offset)
+	return math.Float64frombits(v), ok
+}
+
+func (mem *MemoryInstance) WriteUint8(offset uint32, v uint8) bool {
+	if offset >= uint32(len(mem.Data)) {
+		return false
+	}
+	mem.Data[offset] = v
+	return true
+}
+
+func (mem *MemoryInstance) WriteUint16Le(offset uint32, v uint16) bool {
+	bs, ok := mem.Read(offset, 2)
+	if ok {
+		binary.LittleEndian.PutUint16(bs, v)
+	}
+	return ok
+}
+
+func (mem *MemoryInstance) WriteUint32Le(offset uint32, v uint32) bool {
+	bs, ok := mem.Read(offset, 4)
+	if ok {
+		binary.LittleEndian.PutUint32(bs, v)
+	}
+	return ok
+}
+
+func (mem *MemoryInstance) WriteUint64Le(offset uint32, v uint64) bool {
+	bs, ok := mem.Read(offset, 8)
+	if ok {
+		binary.LittleEndian.PutUint64(bs, v)
+	}
+	return ok
+}
+
+func (mem *MemoryInstance) WriteFloat32Le(offset uint32, v float32) bool {
+	return mem.WriteUint32Le(offset, math.Float32bits(v))
+}
+
+func (mem *MemoryInstance) WriteFloat64Le(offset uint32, v float64) bool {
+	return mem.WriteUint64Le(offset, math.Float64bits(v))
+}
+
+// ReadAt implements io.ReaderAt, reading past the end of memory results in io.EOF
+func (mem *MemoryInstance) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrOutOfMemory
+	}
+	if off >= int64(len(mem.Data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, mem.Data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt, nothing is written unless p fits in memory entirely
+func (mem *MemoryInstance) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(mem.Data)) {
+		return 0, ErrOutOfMemory
+	}
+	return copy(mem.Data[off:], p), nil
+}
@@ -22,11 +22,6 @@ var (
 	nameSectionOnce = sync.Once{}
 )
 
-type TableEntry struct {
-	Index       uint32
-	Initialized bool
-}
-
 type Module struct {
 	TypesSection    *TypesSection
 	ImportSection   *ImportSection
@@ -41,30 +36,33 @@ type Module struct {
 	DataSection     *DataSection
 	CustomSections  CustomSections
 	wr              *wr.WasmReader
+	// limits of declarations enforced while decoding
+	config RuntimeConfig
 
 	FunctionIndexSpace []*Function
-	GlobalIndexSpace   []*GlobalDecl
-
-	TableIndexSpace        [][]*TableEntry
-	LinearMemoryIndexSpace [][]byte
 }
 
 func NewModule(path string) (*Module, error) {
-	module := new(Module)
 	bs, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	r := bytes.NewReader(bs)
-	module.wr = wr.NewWasmReader(r)
+	return ReadModule(bs)
+}
 
-	if err = module.Read(); err != nil {
+// ReadModule decodes module from its binary representation with limits of DefaultRuntimeConfig
+func ReadModule(bs []byte) (*Module, error) {
+	return DecodeModule(bs, DefaultRuntimeConfig())
+}
+
+// DecodeModule decodes module from its binary representation, it fails with LimitError
+// once declarations of the module exceed limits of config
+func DecodeModule(bs []byte, config RuntimeConfig) (*Module, error) {
+	module := &Module{config: config}
+	module.wr = wr.NewWasmReader(bytes.NewReader(bs))
+	if err := module.Read(); err != nil {
 		return nil, err
 	}
-
-	module.LinearMemoryIndexSpace = make([][]byte, 1)
-
-	fmt.Printf("-+-NewModule-+- Addr: %p, Len: %d\n", module.LinearMemoryIndexSpace, len(module.LinearMemoryIndexSpace))
 	return module, nil
 }
 
@@ -89,10 +87,6 @@ func (m *Module) Read() error {
 		return err
 	}
 
-	if m.TableSection != nil {
-		m.TableIndexSpace = make([][]*TableEntry, len(m.TableSection.Entries))
-	}
-
 	return nil
 }
 
@@ -145,16 +139,12 @@ func (m *Module) pushRelevantReader() error {
 	if err != nil {
 		return err
 	}
-	sectionData := new(bytes.Buffer)
-	sectionData.Grow(int(dataLen))
-	sectionReader := io.LimitReader(io.TeeReader(m.wr.Peek().(io.Reader), sectionData), int64(dataLen))
-	m.wr.Push(sectionReader)
-	_, err = m.wr.ReadBytes(int(dataLen))
+	// the length is checked against the rest of the module before the section is read
+	sectionData, err := m.wr.ReadBytes(int(dataLen))
 	if err != nil {
-		return err
+		return fmt.Errorf("section of %d bytes: %w", dataLen, err)
 	}
-	m.wr.Pop()
-	m.wr.Push(sectionData)
+	m.wr.Push(bytes.NewReader(sectionData))
 	return nil
 }
 
@@ -204,7 +194,7 @@ func (m *Module) readImportSection() error {
 		return err
 	}
 	m.ImportSection = is
-	return nil
+	return m.checkFunctions()
 }
 
 func (m *Module) readFunctionSection() error {
@@ -213,7 +203,7 @@ func (m *Module) readFunctionSection() error {
 		return err
 	}
 	m.FunctionSection = fs
-	return nil
+	return m.checkFunctions()
 }
 
 func (m *Module) readTableSection() error {
@@ -221,6 +211,11 @@ func (m *Module) readTableSection() error {
 	if err := ts.Deserialize(m.wr); err != nil {
 		return err
 	}
+	for _, table := range ts.Entries {
+		if table.Limits.Minimum > m.config.MaxTableSize {
+			return LimitError{What: "table elements", Value: uint64(table.Limits.Minimum), Limit: uint64(m.config.MaxTableSize), Unit: "elements"}
+		}
+	}
 	m.TableSection = ts
 	return nil
 }
@@ -230,6 +225,11 @@ func (m *Module) readMemorySection() error {
 	if err := ms.Deserialize(m.wr); err != nil {
 		return err
 	}
+	for _, mem := range ms.Entries {
+		if err := mem.Limits.checkMemory(m.config.MaxMemoryPages); err != nil {
+			return err
+		}
+	}
 	m.MemorySection = ms
 	return nil
 }
@@ -275,6 +275,23 @@ func (m *Module) readCodeSection() error {
 	if err := cs.Deserialize(m.wr); err != nil {
 		return err
 	}
+	for i, body := range cs.Entries {
+		if body.Size > m.config.MaxFunctionSize {
+			return LimitError{What: "bytes of function body", Value: uint64(body.Size), Limit: uint64(m.config.MaxFunctionSize), Unit: "bytes"}
+		}
+		var locals uint64
+		if m.FunctionSection != nil && i < len(m.FunctionSection.Indices) {
+			if sig, ok := m.sig(m.FunctionSection.Indices[i]); ok {
+				locals = uint64(len(sig.Params))
+			}
+		}
+		for _, local := range body.Locals {
+			locals += uint64(local.Count)
+		}
+		if locals > uint64(m.config.MaxLocals) {
+			return LimitError{What: "locals", Value: locals, Limit: uint64(m.config.MaxLocals), Unit: "locals"}
+		}
+	}
 	m.CodeSection = cs
 	return nil
 }
@@ -284,6 +301,25 @@ func (m *Module) readDataSection() error {
 	if err := ds.Deserialize(m.wr); err != nil {
 		return err
 	}
+	var size uint64
+	for _, entry := range ds.Entries {
+		size += uint64(len(entry.Data))
+	}
+	if size > uint64(m.config.MaxDataSize) {
+		return LimitError{What: "bytes of data segments", Value: size, Limit: uint64(m.config.MaxDataSize), Unit: "bytes"}
+	}
 	m.DataSection = ds
 	return nil
 }
+
+// checkFunctions makes sure the number of functions declared so far fits the limit
+func (m *Module) checkFunctions() error {
+	n := uint64(len(m.importsOf(FunctionKind)))
+	if m.FunctionSection != nil {
+		n += uint64(len(m.FunctionSection.Indices))
+	}
+	if n > uint64(m.config.MaxFunctions) {
+		return LimitError{What: "functions", Value: n, Limit: uint64(m.config.MaxFunctions), Unit: "functions"}
+	}
+	return nil
+}
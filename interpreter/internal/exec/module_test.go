@@ -0,0 +1,166 @@
+package exec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+func TestDecoderLimits(t *testing.T) {
+	noop := Func{Type: 0}
+	for _, tc := range []struct {
+		name   string
+		module *Module
+		opts   []exec.VMOption
+	}{
+		{
+			name:   "functions",
+			module: &Module{Types: [][]byte{FuncType(nil, nil)}, Funcs: []Func{noop, noop, noop}},
+			opts:   []exec.VMOption{exec.WithMaxFunctions(2)},
+		},
+		{
+			name:   "locals",
+			module: &Module{Types: [][]byte{FuncType(nil, nil)}, Funcs: []Func{{Type: 0, Locals: [][]byte{Locals(100, I32)}}}},
+			opts:   []exec.VMOption{exec.WithMaxLocals(10)},
+		},
+		{
+			// declarations summing up past 32 bits
+			name: "huge locals",
+			module: &Module{Types: [][]byte{FuncType(nil, nil)}, Funcs: []Func{{Type: 0, Locals: [][]byte{
+				Locals(0xffffffff, I32), Locals(0xffffffff, I64),
+			}}}},
+		},
+		{
+			name:   "function size",
+			module: &Module{Types: [][]byte{FuncType(nil, nil)}, Funcs: []Func{{Type: 0, Code: Cat(I32Const(1), B(0x1a), I32Const(2), B(0x1a), I32Const(3), B(0x1a))}}},
+			opts:   []exec.VMOption{exec.WithMaxFunctionSize(8)},
+		},
+		{
+			name:   "data",
+			module: &Module{Mems: [][]byte{Memory(1, -1)}, Datas: [][]byte{Data(0, make([]byte, 32))}},
+			opts:   []exec.VMOption{exec.WithMaxDataSize(16)},
+		},
+		{
+			name:   "memory",
+			module: &Module{Mems: [][]byte{Memory(4, -1)}},
+			opts:   []exec.VMOption{exec.WithMaxMemoryPages(2)},
+		},
+		{
+			// 4GiB of memory doesn't fit the default limit
+			name:   "default memory",
+			module: &Module{Mems: [][]byte{Memory(65536, -1)}},
+		},
+		{
+			name:   "table",
+			module: &Module{Tables: [][]byte{Table(FuncRef, 100, -1)}},
+			opts:   []exec.VMOption{exec.WithMaxTableSize(10)},
+		},
+	} {
+		_, err := exec.DecodeModule(tc.module.Bytes(), exec.ConfigOf(tc.opts...))
+		var limitErr exec.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: got %v, want LimitError", tc.name, err)
+		}
+	}
+}
+
+func TestDecoderRejectsHugeCounts(t *testing.T) {
+	// a type section claiming 2^32-1 types in a few bytes
+	bin := Cat(B(0, 'a', 's', 'm', 1, 0, 0, 0), B(1, 6), U(0xffffffff), B(0x60))
+	_, err := exec.DecodeModule(bin, exec.DefaultRuntimeConfig())
+	var limitErr exec.LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want LimitError", err)
+	}
+
+	// memory beyond 32-bit address space
+	bin = (&Module{Mems: [][]byte{Memory(1, 65537)}}).Bytes()
+	if _, err = exec.DecodeModule(bin, exec.DefaultRuntimeConfig()); err == nil {
+		t.Fatal("memory of 65537 pages at most decoded")
+	}
+	// while the whole of it may be declared
+	bin = (&Module{Mems: [][]byte{Memory(1, 65536)}}).Bytes()
+	if _, err = exec.DecodeModule(bin, exec.DefaultRuntimeConfig()); err != nil {
+		t.Fatalf("memory of 65536 pages at most: %v", err)
+	}
+}
+
+func TestDisabledFeatures(t *testing.T) {
+	m := &Module{
+		Types:   [][]byte{FuncType(nil, nil)},
+		Funcs:   []Func{{Type: 0, Code: Cat(I32Const(0), I32Const(0), I32Const(0), B(0xfc), U(11), B(0))}},
+		Mems:    [][]byte{Memory(1, -1)},
+		Exports: [][]byte{ExportFunc("fill", 0)},
+	}
+	// functions are validated once they're called first
+	vm := instantiate(t, m.Bytes(), exec.ExecModeCompiled, exec.WithFeatures(exec.FeatureMultiValue))
+	_, err := call(t, vm, "fill")
+	var featureErr exec.DisabledFeatureError
+	if !errors.As(err, &featureErr) || featureErr.Features != exec.FeatureBulkMemory {
+		t.Fatalf("got %v, want DisabledFeatureError of bulk memory", err)
+	}
+}
+
+func TestGrowWithinConfiguredLimits(t *testing.T) {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32), B(I32))},
+		Funcs: []Func{
+			{Type: 0, Code: Cat(B(0xd0, FuncRef), B(0x20, 0), B(0xfc), U(15), U(0))}, // table.grow 0
+			{Type: 0, Code: Cat(B(0x20, 0), B(0x40, 0))},                             // memory.grow
+		},
+		Tables:  [][]byte{Table(FuncRef, 4, -1)},
+		Mems:    [][]byte{Memory(1, -1)},
+		Exports: [][]byte{ExportFunc("table_grow", 0), ExportFunc("memory_grow", 1), ExportTable("table", 0), ExportMemory("memory", 0)},
+	}
+	for _, mode := range execModes {
+		vm := instantiate(t, m.Bytes(), mode, exec.WithMaxMemoryPages(3), exec.WithMaxTableSize(6))
+		mem, _ := vm.ExportedMemory("memory")
+		table, _ := vm.ExportedTable("table")
+
+		// hosts grow up to the limits of the instance
+		if prev, ok := mem.Grow(1); !ok || prev != 1 {
+			t.Fatalf("memory growth by host in %s mode = %d, %v", modeName(mode), prev, ok)
+		}
+		if _, ok := mem.Grow(2); ok {
+			t.Fatalf("memory grown by host past the limit in %s mode", modeName(mode))
+		}
+		if prev, ok := table.Grow(1, nil); !ok || prev != 4 {
+			t.Fatalf("table growth by host in %s mode = %d, %v", modeName(mode), prev, ok)
+		}
+		if _, ok := table.Grow(2, nil); ok {
+			t.Fatalf("table grown by host past the limit in %s mode", modeName(mode))
+		}
+
+		// and so do guests
+		for _, tc := range []struct {
+			fn    string
+			delta uint64
+			want  uint64
+		}{
+			{"memory_grow", 2, 0xffffffff},
+			{"memory_grow", 1, 2},
+			{"table_grow", 2, 0xffffffff},
+			{"table_grow", 1, 5},
+		} {
+			if r, err := call(t, vm, tc.fn, tc.delta); err != nil || r[0] != tc.want {
+				t.Errorf("%s(%d) in %s mode = %v, %v, want %#x", tc.fn, tc.delta, modeName(mode), r, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestMemoryLimitFitsSize(t *testing.T) {
+	m, err := exec.ReadModule((&Module{}).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// size of memory of 65536 pages in bytes doesn't fit uint32
+	if _, err := exec.NewVM(m, exec.WithMaxMemoryPages(65536)); err == nil {
+		t.Fatal("memory limit of 65536 pages accepted")
+	}
+	if _, err := exec.NewVM(m, exec.WithMaxMemoryPages(65535)); err != nil {
+		t.Fatal(err)
+	}
+}
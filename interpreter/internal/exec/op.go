@@ -60,6 +60,14 @@ func newVarargOp(name string, code Bytecode) Bytecode {
 	return code
 }
 
+// countTypes returns number of values described by ts, types.ValueTypeVoid describes none
+func countTypes(ts []types.ValueType) int {
+	if len(ts) == 1 && ts[0] == 0 {
+		return 0
+	}
+	return len(ts)
+}
+
 func lookupOp(code Bytecode) Op {
 	if op, ok := codeLookup[code]; ok {
 		return op
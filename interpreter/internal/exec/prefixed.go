@@ -0,0 +1,295 @@
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
+	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// miscPrefix introduces instructions encoded as the prefix followed by a LEB128 sub-opcode,
+// compiled bytecode keeps the sub-opcode in arg0
+var miscPrefix = newVarargOp("misc", 0xFC)
+
+const (
+	i32TruncSatF32S uint32 = iota
+	i32TruncSatF32U
+	i32TruncSatF64S
+	i32TruncSatF64U
+	i64TruncSatF32S
+	i64TruncSatF32U
+	i64TruncSatF64S
+	i64TruncSatF64U
+	memoryInit
+	dataDrop
+	memoryCopy
+	memoryFill
+	tableInit
+	elemDrop
+	tableCopy
+	tableGrow
+	tableSize
+	tableFill
+	numMiscOps
+)
+
+// miscOps describes prefixed instructions by their sub-opcodes, ones without a name aren't supported
+var miscOps = [numMiscOps]Op{
+	i32TruncSatF32S: miscOp("i32.trunc_sat_f32_s", types.ValueTypeSingleF32, types.ValueTypeSingleI32),
+	i32TruncSatF32U: miscOp("i32.trunc_sat_f32_u", types.ValueTypeSingleF32, types.ValueTypeSingleI32),
+	i32TruncSatF64S: miscOp("i32.trunc_sat_f64_s", types.ValueTypeSingleF64, types.ValueTypeSingleI32),
+	i32TruncSatF64U: miscOp("i32.trunc_sat_f64_u", types.ValueTypeSingleF64, types.ValueTypeSingleI32),
+	i64TruncSatF32S: miscOp("i64.trunc_sat_f32_s", types.ValueTypeSingleF32, types.ValueTypeSingleI64),
+	i64TruncSatF32U: miscOp("i64.trunc_sat_f32_u", types.ValueTypeSingleF32, types.ValueTypeSingleI64),
+	i64TruncSatF64S: miscOp("i64.trunc_sat_f64_s", types.ValueTypeSingleF64, types.ValueTypeSingleI64),
+	i64TruncSatF64U: miscOp("i64.trunc_sat_f64_u", types.ValueTypeSingleF64, types.ValueTypeSingleI64),
+	memoryCopy:      miscOp("memory.copy", types.ValueTypeTripleI32, types.ValueTypeVoid),
+	memoryFill:      miscOp("memory.fill", types.ValueTypeTripleI32, types.ValueTypeVoid),
+	// table instructions take references of the element type of the table, funcref stands for
+	// it here, the validator checks actual types
+	tableCopy: miscOp("table.copy", types.ValueTypeTripleI32, types.ValueTypeVoid),
+	tableGrow: miscOp("table.grow", []types.ValueType{types.ValueTypeFuncRef, types.ValueTypeI32}, types.ValueTypeSingleI32),
+	tableSize: miscOp("table.size", types.ValueTypeVoid, types.ValueTypeSingleI32),
+	tableFill: miscOp("table.fill", []types.ValueType{types.ValueTypeI32, types.ValueTypeFuncRef, types.ValueTypeI32}, types.ValueTypeVoid),
+}
+
+// number of reserved zero bytes following sub-opcodes, they stand for memory indices
+var miscReserved = [numMiscOps]int{memoryCopy: 2, memoryFill: 1}
+
+// number of table indices following sub-opcodes, table.copy takes the destination first
+var miscTables = [numMiscOps]int{tableCopy: 2, tableGrow: 1, tableSize: 1, tableFill: 1}
+
+var miscTable = [numMiscOps]func(vm *VM){
+	i32TruncSatF32S: (*VM).i32TruncSatF32S,
+	i32TruncSatF32U: (*VM).i32TruncSatF32U,
+	i32TruncSatF64S: (*VM).i32TruncSatF64S,
+	i32TruncSatF64U: (*VM).i32TruncSatF64U,
+	i64TruncSatF32S: (*VM).i64TruncSatF32S,
+	i64TruncSatF32U: (*VM).i64TruncSatF32U,
+	i64TruncSatF64S: (*VM).i64TruncSatF64S,
+	i64TruncSatF64U: (*VM).i64TruncSatF64U,
+	memoryCopy:      (*VM).memoryCopy,
+	memoryFill:      (*VM).memoryFill,
+	tableCopy:       (*VM).tableCopy,
+	tableGrow:       (*VM).tableGrow,
+	tableSize:       (*VM).tableSize,
+	tableFill:       (*VM).tableFill,
+}
+
+// MiscI is a prefixed instruction along with its table indices
+type MiscI struct {
+	commonI
+	sub    uint32
+	tables [2]uint32
+}
+
+func (mi *MiscI) String() string {
+	s := mi.Op().Name
+	for i := 0; i < miscTables[mi.sub]; i++ {
+		s += fmt.Sprintf(" %d", mi.tables[i])
+	}
+	return s
+}
+
+func miscOp(name string, inputTypes, outputTypes []types.ValueType) Op {
+	return Op{Name: name, Code: miscPrefix, InputTypes: inputTypes, OutputTypes: outputTypes}
+}
+
+// lookupMiscOp returns prefixed instruction with sub-opcode sub
+func lookupMiscOp(sub uint32) (Op, error) {
+	if sub >= numMiscOps || miscOps[sub].Name == "" {
+		return Op{}, fmt.Errorf("unknown opcode %#x %d", byte(miscPrefix), sub)
+	}
+	return miscOps[sub], nil
+}
+
+// readMiscOp decodes a prefixed instruction following the prefix and checks its reserved bytes
+func readMiscOp(reader *wasm_reader.WasmReader) (*MiscI, error) {
+	sub, err := wbinary.ReadVarUint32(reader)
+	if err != nil {
+		return nil, err
+	}
+	op, err := lookupMiscOp(sub)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < miscReserved[sub]; i++ {
+		if reserved, err := reader.ReadByte(); err != nil {
+			return nil, err
+		} else if reserved != 0 {
+			return nil, fmt.Errorf("%s expects zero memory index", op.Name)
+		}
+	}
+	in := &MiscI{commonI: commonI{op: op}, sub: sub}
+	for i := 0; i < miscTables[sub]; i++ {
+		if in.tables[i], err = wbinary.ReadVarUint32(reader); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+func (vm *VM) execMisc() {
+	miscTable[vm.currIns().arg0](vm)
+}
+
+// decodeMisc decodes sub-opcode and table indices of the instruction in place, reserved bytes
+// are checked by the validator
+func (vm *VM) decodeMisc() {
+	sub := vm.fetchVarUint32()
+	vm.ctx.imm.arg0 = sub
+	vm.ctx.pc += miscReserved[sub]
+	if miscTables[sub] > 0 {
+		vm.ctx.imm.arg1 = vm.fetchVarUint32()
+	}
+	if miscTables[sub] > 1 {
+		vm.ctx.imm.arg2 = vm.fetchVarUint32()
+	}
+}
+
+func (vm *VM) i32TruncSatF32S() {
+	vm.pushInt32(int32(truncSat(float64(vm.popFloat32()), -2147483648, 2147483647)))
+}
+
+func (vm *VM) i32TruncSatF32U() {
+	vm.pushUint32(uint32(truncSat(float64(vm.popFloat32()), 0, 4294967295)))
+}
+
+func (vm *VM) i32TruncSatF64S() {
+	vm.pushInt32(int32(truncSat(vm.popFloat64(), -2147483648, 2147483647)))
+}
+
+func (vm *VM) i32TruncSatF64U() {
+	vm.pushUint32(uint32(truncSat(vm.popFloat64(), 0, 4294967295)))
+}
+
+func (vm *VM) i64TruncSatF32S() {
+	vm.pushInt64(truncSatI64(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i64TruncSatF32U() {
+	vm.pushUint64(truncSatU64(float64(vm.popFloat32())))
+}
+
+func (vm *VM) i64TruncSatF64S() {
+	vm.pushInt64(truncSatI64(vm.popFloat64()))
+}
+
+func (vm *VM) i64TruncSatF64U() {
+	vm.pushUint64(truncSatU64(vm.popFloat64()))
+}
+
+// truncSat truncates x towards zero clamping it to [lo, hi], NaN becomes zero. 32-bit bounds
+// are exact in float64, so it's only used for 32-bit results
+func truncSat(x, lo, hi float64) float64 {
+	switch {
+	case x != x:
+		return 0
+	case x <= lo:
+		return lo
+	case x >= hi:
+		return hi
+	}
+	return math.Trunc(x)
+}
+
+func truncSatI64(x float64) int64 {
+	switch {
+	case x != x:
+		return 0
+	case x < -9223372036854775808:
+		return math.MinInt64
+	case x >= 9223372036854775808:
+		return math.MaxInt64
+	}
+	return int64(x)
+}
+
+func truncSatU64(x float64) uint64 {
+	switch {
+	case x != x || x <= 0:
+		return 0
+	case x >= 18446744073709551616:
+		return math.MaxUint64
+	}
+	return uint64(x)
+}
+
+func (vm *VM) memoryCopy() {
+	n := uint64(vm.popUint32())
+	src := uint64(vm.popUint32())
+	dst := uint64(vm.popUint32())
+	if !vm.inBounds(src, n) || !vm.inBounds(dst, n) {
+		panic(ErrOutOfMemory)
+	}
+	vm.consumeFuelUnits(miscPrefix, bulkUnits(uint32(n)))
+	copy(vm.memory.Data[dst:dst+n], vm.memory.Data[src:src+n])
+}
+
+func (vm *VM) memoryFill() {
+	n := uint64(vm.popUint32())
+	val := byte(vm.popUint32())
+	dst := uint64(vm.popUint32())
+	if !vm.inBounds(dst, n) {
+		panic(ErrOutOfMemory)
+	}
+	vm.consumeFuelUnits(miscPrefix, bulkUnits(uint32(n)))
+	data := vm.memory.Data[dst : dst+n]
+	for i := range data {
+		data[i] = val
+	}
+}
+
+// tableRange traps unless n elements starting at offset are within table
+func tableRange(table *TableInstance, offset, n uint32) {
+	if uint64(offset)+uint64(n) > uint64(table.Size()) {
+		panic(ErrTableIndexOutOfBounds)
+	}
+}
+
+func (vm *VM) tableCopy() {
+	in := vm.currIns()
+	dst, src := vm.tables[in.arg1], vm.tables[in.arg2]
+	n := vm.popUint32()
+	srcOffset := vm.popUint32()
+	dstOffset := vm.popUint32()
+	tableRange(src, srcOffset, n)
+	tableRange(dst, dstOffset, n)
+	vm.consumeFuelUnits(miscPrefix, bulkUnits(n))
+	if dst.Type == ExternRefElementType {
+		copy(dst.Externs[dstOffset:dstOffset+n], src.Externs[srcOffset:])
+	} else {
+		copy(dst.Elements[dstOffset:dstOffset+n], src.Elements[srcOffset:])
+	}
+}
+
+func (vm *VM) tableGrow() {
+	table := vm.tables[vm.currIns().arg1]
+	delta := vm.popUint32()
+	init, _ := vm.ExternValue(vm.popUint64())
+	prev, ok := table.grow(delta, init, vm.config.MaxTableSize)
+	if !ok {
+		vm.pushInt32(-1)
+		return
+	}
+	vm.consumeFuelUnits(miscPrefix, bulkUnits(delta))
+	vm.pushUint32(prev)
+}
+
+func (vm *VM) tableSize() {
+	vm.pushUint32(vm.tables[vm.currIns().arg1].Size())
+}
+
+func (vm *VM) tableFill() {
+	table := vm.tables[vm.currIns().arg1]
+	n := vm.popUint32()
+	v, _ := vm.ExternValue(vm.popUint64())
+	offset := vm.popUint32()
+	tableRange(table, offset, n)
+	vm.consumeFuelUnits(miscPrefix, bulkUnits(n))
+	for i := offset; i < offset+n; i++ {
+		table.Set(i, v)
+	}
+}
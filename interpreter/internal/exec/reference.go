@@ -0,0 +1,53 @@
+package exec
+
+import (
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// references are passed around the operand stack as handles of host values, see ExternRef,
+// function references being *FuncRef
+var (
+	tableGetOp  = newVarargOp("table.get", 0x25)
+	tableSetOp  = newVarargOp("table.set", 0x26)
+	refNullOp   = newVarargOp("ref.null", 0xD0)
+	refIsNullOp = newOp("ref.is_null", 0xD1, []types.ValueType{types.ValueTypeFuncRef}, types.ValueTypeSingleI32)
+	refFuncOp   = newVarargOp("ref.func", 0xD2)
+)
+
+// isReference reports whether values of type t are references
+func isReference(t types.ValueType) bool {
+	return t == types.ValueTypeFuncRef || t == types.ValueTypeExternRef
+}
+
+func (vm *VM) tableGet() {
+	table := vm.tables[vm.currIns().arg0]
+	v, ok := table.Get(vm.popUint32())
+	if !ok {
+		panic(ErrTableIndexOutOfBounds)
+	}
+	vm.pushUint64(vm.ExternRef(v))
+}
+
+func (vm *VM) tableSet() {
+	table := vm.tables[vm.currIns().arg0]
+	v, _ := vm.ExternValue(vm.popUint64())
+	if err := table.Set(vm.popUint32(), v); err != nil {
+		panic(err)
+	}
+}
+
+func (vm *VM) refNull() {
+	vm.pushUint64(0)
+}
+
+func (vm *VM) refIsNull() {
+	if vm.popUint64() == 0 {
+		vm.pushOne()
+	} else {
+		vm.pushZero()
+	}
+}
+
+func (vm *VM) refFunc() {
+	vm.pushUint64(vm.ExternRef(vm.funcRef(vm.currIns().arg0)))
+}
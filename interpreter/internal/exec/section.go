@@ -3,6 +3,7 @@ package exec
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
 	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
@@ -26,6 +27,19 @@ const (
 	DataSectionID
 )
 
+// readCount reads length of a vector of what, every item of the vector takes at least a byte,
+// so the length is bounded by the bytes left in the section before anything is allocated for it
+func readCount(reader *wasm_reader.WasmReader, what string) (uint32, error) {
+	count, err := wbinary.ReadVarUint32(reader)
+	if err != nil {
+		return 0, err
+	}
+	if left := reader.Len(); left >= 0 && uint64(count) > uint64(left) {
+		return 0, LimitError{What: what, Value: uint64(count), Limit: uint64(left), Unit: "bytes left in section"}
+	}
+	return count, nil
+}
+
 type Serializer interface {
 	Serialize() error
 	Deserialize(wr *wasm_reader.WasmReader) error
@@ -41,11 +55,36 @@ type Validatable interface {
 
 type FunctionSig struct {
 	Params  []types.ValueType
-	Results [1]types.ValueType // has at most one element (before rolling support for multiple return types)
+	Results []types.ValueType
 }
 
 func (fs FunctionSig) Serialize() error { return nil }
 
+func (fs *FunctionSig) numResults() int {
+	return len(fs.Results)
+}
+
+func (fs *FunctionSig) results() []types.ValueType {
+	return fs.Results
+}
+
+// Equal reports whether fs and other describe the same function type
+func (fs *FunctionSig) Equal(other *FunctionSig) bool {
+	return equalTypes(fs.Params, other.Params) && equalTypes(fs.Results, other.Results)
+}
+
+func equalTypes(lhs, rhs []types.ValueType) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if lhs[i] != rhs[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (fs *FunctionSig) Deserialize(reader *wasm_reader.WasmReader) error {
 	// force value type to be a func
 	valType, err := wbinary.ReadU8(reader)
@@ -57,7 +96,7 @@ func (fs *FunctionSig) Deserialize(reader *wasm_reader.WasmReader) error {
 	}
 
 	// start filling out function signatures slice
-	paramsLen, err := wbinary.ReadVarUint32(reader)
+	paramsLen, err := readCount(reader, "parameters")
 	if err != nil {
 		return err
 	}
@@ -70,13 +109,11 @@ func (fs *FunctionSig) Deserialize(reader *wasm_reader.WasmReader) error {
 		}
 		fs.Params[i] = types.ValueType(valTyp)
 	}
-	resultsLen, err := wbinary.ReadVarUint32(reader)
+	resultsLen, err := readCount(reader, "results")
 	if err != nil {
 		return err
 	}
-	if resultsLen > 1 {
-		return errors.New("readTypeSection: length of results array can't exceed the value of 1 (yet)")
-	}
+	fs.Results = make([]types.ValueType, resultsLen)
 	for i := 0; i < int(resultsLen); i++ {
 		valTyp, err := wbinary.ReadVarUint32(reader)
 		if err != nil {
@@ -96,7 +133,7 @@ func (ts *TypesSection) Serialize() error { return nil }
 
 func (ts *TypesSection) Deserialize(reader *wasm_reader.WasmReader) error {
 	// read arr length
-	arrLen, err := wbinary.ReadVarUint32(reader)
+	arrLen, err := readCount(reader, "types")
 	if err != nil {
 		return err
 	}
@@ -120,6 +157,8 @@ const (
 	TableKind
 	MemoryKind
 	GlobalKind
+
+	numExternalKinds = iota
 )
 
 func (kind ExternalKind) String() string {
@@ -178,7 +217,10 @@ func (f *FunctionKindDesc) Deserialize(reader *wasm_reader.WasmReader) error {
 
 type ElementType int
 
-const FuncRefElementType ElementType = 0x70
+const (
+	FuncRefElementType   ElementType = 0x70
+	ExternRefElementType ElementType = 0x6f
+)
 
 type Table struct {
 	ElemType ElementType
@@ -187,8 +229,16 @@ type Table struct {
 
 func (_ Table) Serialize() error { return nil }
 
-func (_ *Table) Deserialize(reader *wasm_reader.WasmReader) error {
-	return nil
+func (t *Table) Deserialize(reader *wasm_reader.WasmReader) error {
+	elemType, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	t.ElemType = ElementType(elemType)
+	if t.ElemType != FuncRefElementType && t.ElemType != ExternRefElementType {
+		return fmt.Errorf("section: unsupported table element type %#x", elemType)
+	}
+	return t.Limits.Deserialize(reader)
 }
 
 type TableKindDesc struct {
@@ -240,6 +290,20 @@ func (rl *ResizableLimits) Deserialize(reader *wasm_reader.WasmReader) error {
 	return nil
 }
 
+// checkMemory validates limits of a memory, whose initial size must not exceed limit pages
+func (rl *ResizableLimits) checkMemory(limit uint32) error {
+	if rl.Maximum != nil && *rl.Maximum > addressablePages {
+		return fmt.Errorf("section: memory maximum of %d pages exceeds %d pages", *rl.Maximum, addressablePages)
+	}
+	if rl.Maximum != nil && rl.Minimum > *rl.Maximum {
+		return fmt.Errorf("section: memory minimum of %d pages exceeds its maximum of %d pages", rl.Minimum, *rl.Maximum)
+	}
+	if rl.Minimum > limit {
+		return LimitError{What: "memory pages", Value: uint64(rl.Minimum), Limit: uint64(limit), Unit: "pages"}
+	}
+	return nil
+}
+
 type MemoryKindDesc struct {
 	Limits ResizableLimits
 }
@@ -335,7 +399,7 @@ type ImportSection struct {
 func (i ImportSection) IsSection() bool { return true }
 
 func (i *ImportSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "imports")
 	if err != nil {
 		return err
 	}
@@ -358,7 +422,7 @@ func (_ TableSection) IsSection() bool  { return true }
 func (_ TableSection) Serialize() error { return nil }
 
 func (ts *TableSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "tables")
 	if err != nil {
 		return err
 	}
@@ -382,7 +446,7 @@ func (_ FunctionSection) IsSection() bool  { return true }
 func (_ FunctionSection) Serialize() error { return nil }
 
 func (f *FunctionSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "functions")
 	if err != nil {
 		return err
 	}
@@ -405,7 +469,7 @@ func (_ MemorySection) IsSection() bool  { return true }
 func (_ MemorySection) Serialize() error { return nil }
 
 func (m *MemorySection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "memories")
 	if err != nil {
 		return err
 	}
@@ -451,7 +515,7 @@ func (_ GlobalSection) IsSection() bool  { return true }
 func (_ GlobalSection) Serialize() error { return nil }
 
 func (g *GlobalSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "globals")
 	if err != nil {
 		return err
 	}
@@ -493,6 +557,8 @@ func (e *ExportEntry) Deserialize(reader *wasm_reader.WasmReader) error {
 
 type ExportSection struct {
 	Entries map[string]*ExportEntry
+	// entries in order of their declaration
+	Ordered []*ExportEntry
 }
 
 var ErrDuplicateExport = errors.New("section: duplicate exports not allowed")
@@ -502,11 +568,12 @@ func (_ ExportSection) Serialize() error { return nil }
 func (_ ExportSection) Validate() error  { return nil }
 
 func (e *ExportSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "exports")
 	if err != nil {
 		return err
 	}
 	e.Entries = make(map[string]*ExportEntry, count)
+	e.Ordered = make([]*ExportEntry, 0, count)
 
 	for i := uint32(0); i < count; i++ {
 		entry := new(ExportEntry)
@@ -517,6 +584,7 @@ func (e *ExportSection) Deserialize(reader *wasm_reader.WasmReader) error {
 			return ErrDuplicateExport
 		}
 		e.Entries[entry.Name] = entry
+		e.Ordered = append(e.Ordered, entry)
 	}
 	return nil
 }
@@ -553,7 +621,7 @@ func (t *TableInitializer) Deserialize(reader *wasm_reader.WasmReader) error {
 		return err
 	}
 
-	elemsNum, err := wbinary.ReadVarUint32(reader)
+	elemsNum, err := readCount(reader, "elements")
 	if err != nil {
 		return err
 	}
@@ -577,7 +645,7 @@ func (_ ElementSection) Serialize() error { return nil }
 
 // TODO(threadedstream): has not yet been tested
 func (e *ElementSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "element segments")
 	if err != nil {
 		return err
 	}
@@ -638,7 +706,7 @@ func (fb *FunctionBody) Deserialize(reader *wasm_reader.WasmReader) error {
 
 	// read number of locals
 	var localCount uint32
-	if localCount, err = wbinary.ReadVarUint32(reader); err != nil {
+	if localCount, err = readCount(reader, "local entries"); err != nil {
 		return err
 	}
 	fb.Locals = make([]*LocalEntry, 0, localCount)
@@ -651,7 +719,7 @@ func (fb *FunctionBody) Deserialize(reader *wasm_reader.WasmReader) error {
 	}
 
 	code := bodyReader.Bytes()
-	if code[len(code)-1] != end {
+	if len(code) == 0 || code[len(code)-1] != end {
 		return ErrFunctionNoEnd
 	}
 
@@ -668,9 +736,9 @@ func (_ CodeSection) IsSection() bool  { return true }
 func (_ CodeSection) Serialize() error { return nil }
 
 func (c *CodeSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "function bodies")
 	if err != nil {
-		return nil
+		return err
 	}
 	c.Entries = make([]*FunctionBody, 0, count)
 
@@ -714,7 +782,7 @@ func (_ DataSection) IsSection() bool  { return true }
 func (_ DataSection) Serialize() error { return nil }
 
 func (d *DataSection) Deserialize(reader *wasm_reader.WasmReader) error {
-	count, err := wbinary.ReadVarUint32(reader)
+	count, err := readCount(reader, "data segments")
 	if err != nil {
 		return err
 	}
@@ -795,7 +863,7 @@ type NameMap struct {
 
 func (nm *NameMap) Deserialize(reader *wasm_reader.WasmReader) error {
 	var err error
-	nm.Count, err = wbinary.ReadVarUint32(reader)
+	nm.Count, err = readCount(reader, "names")
 	if err != nil {
 		return err
 	}
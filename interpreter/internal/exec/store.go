@@ -0,0 +1,129 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Store links instances of modules: imports of a module being instantiated are resolved
+// against host modules and exports of the instances created by the store before
+type Store struct {
+	hosts     HostModules
+	instances map[string]*VM
+	// applied to every instance before options of Instantiate
+	opts []VMOption
+	// number of instances created so far and their limit, zero means no limit
+	count, maxInstances int
+}
+
+// NewStore creates a store whose instances are configured with opts
+func NewStore(opts ...VMOption) *Store {
+	return &Store{instances: make(map[string]*VM), opts: opts, maxInstances: ConfigOf(opts...).MaxInstances}
+}
+
+// Config returns configuration of instances created with opts, modules to be instantiated
+// by the store are decoded with it
+func (s *Store) Config(opts ...VMOption) RuntimeConfig {
+	return ConfigOf(append(s.opts[:len(s.opts):len(s.opts)], opts...)...)
+}
+
+var ErrTooManyInstances = errors.New("exec: too many instances")
+
+// AddHostModule makes exports of hm importable by the instances created afterwards
+func (s *Store) AddHostModule(hm *HostModule) error {
+	if s.hasModule(hm.Name()) {
+		return fmt.Errorf("exec: module %s is already defined in store", hm.Name())
+	}
+	s.hosts = append(s.hosts, hm)
+	return nil
+}
+
+// Instantiate creates an instance of m, its exports become importable under name unless
+// the name is empty. Imports are resolved by the store unless opts override the resolver
+func (s *Store) Instantiate(name string, m *Module, opts ...VMOption) (*VM, error) {
+	if name != "" && s.hasModule(name) {
+		return nil, fmt.Errorf("exec: module %s is already defined in store", name)
+	}
+	if s.maxInstances > 0 && s.count >= s.maxInstances {
+		return nil, ErrTooManyInstances
+	}
+	all := append(append([]VMOption{WithImportResolver(s)}, s.opts...), opts...)
+	vm, err := NewVM(m, all...)
+	if err != nil {
+		return nil, err
+	}
+	s.count++
+	if name != "" {
+		s.instances[name] = vm
+	}
+	return vm, nil
+}
+
+// Instance returns instance registered under name
+func (s *Store) Instance(name string) (*VM, bool) {
+	vm, ok := s.instances[name]
+	return vm, ok
+}
+
+func (s *Store) hasModule(name string) bool {
+	if _, ok := s.instances[name]; ok {
+		return true
+	}
+	for _, hm := range s.hosts {
+		if hm.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) ResolveFunction(module, name string) (*HostFunction, bool) {
+	if fn, ok := s.hosts.ResolveFunction(module, name); ok {
+		return fn, true
+	}
+	vm, ok := s.instances[module]
+	if !ok {
+		return nil, false
+	}
+	index, ok := vm.Export(FunctionKind, name)
+	if !ok {
+		return nil, false
+	}
+	return &HostFunction{
+		Sig: vm.funcs[index].sig,
+		Fn: func(_ context.Context, caller *VM, stack []uint64) {
+			vm.callExternal(caller, index, stack)
+		},
+	}, true
+}
+
+func (s *Store) ResolveMemory(module, name string) (*MemoryInstance, bool) {
+	if mem, ok := s.hosts.ResolveMemory(module, name); ok {
+		return mem, true
+	}
+	if vm, ok := s.instances[module]; ok {
+		return vm.ExportedMemory(name)
+	}
+	return nil, false
+}
+
+func (s *Store) ResolveTable(module, name string) (*TableInstance, bool) {
+	if table, ok := s.hosts.ResolveTable(module, name); ok {
+		return table, true
+	}
+	if vm, ok := s.instances[module]; ok {
+		return vm.ExportedTable(name)
+	}
+	return nil, false
+}
+
+func (s *Store) ResolveGlobal(module, name string) (*GlobalInstance, bool) {
+	if global, ok := s.hosts.ResolveGlobal(module, name); ok {
+		return global, true
+	}
+	if vm, ok := s.instances[module]; ok {
+		return vm.ExportedGlobal(name)
+	}
+	return nil, false
+}
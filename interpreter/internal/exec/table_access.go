@@ -0,0 +1,111 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// limit of table growth in elements unless the table declares a lower maximum
+const maxTableSize = 1 << 24
+
+var ErrTableIndexOutOfBounds = errors.New("exec: table index out of bounds")
+
+// Size returns number of elements in the table
+func (table *TableInstance) Size() uint32 {
+	if table.Type == ExternRefElementType {
+		return uint32(len(table.Externs))
+	}
+	return uint32(len(table.Elements))
+}
+
+// Grow extends the table by delta elements set to init returning its previous size, it fails
+// if the limits of the table, MaxTableSize of the instances using it included, don't permit
+// such an increase or init is of a wrong type
+func (table *TableInstance) Grow(delta uint32, init any) (uint32, bool) {
+	return table.grow(delta, init, maxTableSize)
+}
+
+// restrict makes the table grow up to size elements at most
+func (table *TableInstance) restrict(size uint32) {
+	if table.limit == 0 || size < table.limit {
+		table.limit = size
+	}
+}
+
+// grow is Grow limiting size of the table to limit elements as well
+func (table *TableInstance) grow(delta uint32, init any, limit uint32) (uint32, bool) {
+	if !table.accepts(init) {
+		return 0, false
+	}
+	size := table.Size()
+	max := uint64(limit)
+	if table.limit != 0 && uint64(table.limit) < max {
+		max = uint64(table.limit)
+	}
+	if table.Max != nil && uint64(*table.Max) < max {
+		max = uint64(*table.Max)
+	}
+	if uint64(size)+uint64(delta) > max {
+		return 0, false
+	}
+	for i := uint32(0); i < delta; i++ {
+		if table.Type == ExternRefElementType {
+			table.Externs = append(table.Externs, init)
+		} else {
+			ref, _ := init.(*FuncRef)
+			table.Elements = append(table.Elements, ref)
+		}
+	}
+	return size, true
+}
+
+// Get returns element at index, which is either *FuncRef or a host value depending on type
+// of the table. Null references are returned as nil
+func (table *TableInstance) Get(index uint32) (any, bool) {
+	if index >= table.Size() {
+		return nil, false
+	}
+	if table.Type == ExternRefElementType {
+		return table.Externs[index], true
+	}
+	if ref := table.Elements[index]; ref != nil {
+		return ref, true
+	}
+	return nil, true
+}
+
+// Set stores v at index, v has to be *FuncRef for tables of function references,
+// nil stores null reference
+func (table *TableInstance) Set(index uint32, v any) error {
+	if index >= table.Size() {
+		return ErrTableIndexOutOfBounds
+	}
+	if !table.accepts(v) {
+		return fmt.Errorf("exec: can't store %T in table of function references", v)
+	}
+	if table.Type == ExternRefElementType {
+		table.Externs[index] = v
+	} else {
+		ref, _ := v.(*FuncRef)
+		table.Elements[index] = ref
+	}
+	return nil
+}
+
+func (table *TableInstance) accepts(v any) bool {
+	if table.Type == ExternRefElementType || v == nil {
+		return true
+	}
+	_, ok := v.(*FuncRef)
+	return ok
+}
+
+// Sig returns type of the referenced function
+func (ref *FuncRef) Sig() *FunctionSig {
+	return ref.vm.funcs[ref.index].sig
+}
+
+// Call invokes the referenced function in the instance it belongs to, see VM.ExecFunc
+func (ref *FuncRef) Call(args ...uint64) ([]uint64, error) {
+	return ref.vm.ExecFunc(int64(ref.index), args...)
+}
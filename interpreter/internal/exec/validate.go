@@ -0,0 +1,659 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/threadedstream/wasmexperiments/internal/pkg/wasm_reader"
+	"github.com/threadedstream/wasmexperiments/internal/pkg/wbinary"
+	"github.com/threadedstream/wasmexperiments/internal/types"
+)
+
+// unknownType is the type of operands popped off the polymorphic stack of unreachable code
+const unknownType types.ValueType = 0
+
+// sideTableEntry tells the in-place interpreter where a taken branch continues
+// and how it adjusts the operand stack, see "A fast in-place interpreter for WebAssembly"
+type sideTableEntry struct {
+	// offset in the function body and index of side table entry to continue at
+	pc, stp uint32
+	// stack height of the target label and the number of values carried over
+	height, arity uint32
+}
+
+// validatedFunction holds the artifacts of validation needed for execution
+type validatedFunction struct {
+	sideTable      []sideTableEntry
+	maxStackHeight int
+	// proposals the body relies on
+	features Features
+}
+
+type ctrlFrame struct {
+	code        Bytecode
+	params      []types.ValueType
+	results     []types.ValueType
+	height      int
+	unreachable bool
+	// loops are entered at start with the side table pointer being startStp
+	start, startStp int
+	// side table entries waiting for the end of the frame
+	fixups []int
+	// side table entry of the if instruction until else is reached, -1 otherwise
+	elseFixup int
+}
+
+type validator struct {
+	module    *Module
+	locals    []types.ValueType
+	code      []byte
+	src       *bytes.Reader
+	reader    *wasm_reader.WasmReader
+	vals      []types.ValueType
+	ctrls     []*ctrlFrame
+	sideTable []sideTableEntry
+	maxHeight int
+	features  Features
+}
+
+// validateFunction type-checks body of a function with signature sig, and produces
+// a side table for its in-place execution along the way
+func validateFunction(m *Module, sig *FunctionSig, body *FunctionBody) (*validatedFunction, error) {
+	v := &validator{module: m, code: body.Code}
+	v.src = bytes.NewReader(body.Code)
+	v.reader = wasm_reader.NewWasmReader(v.src)
+	v.locals = append(v.locals, sig.Params...)
+	for _, local := range body.Locals {
+		v.features |= valueTypeFeatures(local.Type)
+		for i := uint32(0); i < local.Count; i++ {
+			v.locals = append(v.locals, local.Type)
+		}
+	}
+
+	v.pushCtrl(blockOp, nil, sig.results())
+	for v.src.Len() > 0 {
+		pc := v.pos()
+		b, err := v.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if err = v.validateIns(Bytecode(b)); err != nil {
+			return nil, fmt.Errorf("validate: offset %d: %w", pc, err)
+		}
+	}
+	if len(v.ctrls) != 1 {
+		return nil, fmt.Errorf("validate: %d blocks are not terminated by end", len(v.ctrls)-1)
+	}
+	if _, err := v.popCtrl(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	return &validatedFunction{sideTable: v.sideTable, maxStackHeight: v.maxHeight, features: v.features}, nil
+}
+
+// pos returns offset of the next byte to be read
+func (v *validator) pos() int {
+	return len(v.code) - v.src.Len()
+}
+
+func (v *validator) pushVal(t types.ValueType) {
+	v.vals = append(v.vals, t)
+	if len(v.vals) > v.maxHeight {
+		v.maxHeight = len(v.vals)
+	}
+}
+
+func (v *validator) pushVals(ts []types.ValueType) {
+	for _, t := range ts {
+		v.pushVal(t)
+	}
+}
+
+func (v *validator) popVal() (types.ValueType, error) {
+	top := v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == top.height {
+		if top.unreachable {
+			return unknownType, nil
+		}
+		return unknownType, fmt.Errorf("operand stack underflow")
+	}
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return t, nil
+}
+
+func (v *validator) popExpect(expected types.ValueType) (types.ValueType, error) {
+	actual, err := v.popVal()
+	if err != nil {
+		return unknownType, err
+	}
+	if actual != expected && actual != unknownType && expected != unknownType {
+		return unknownType, fmt.Errorf("type mismatch: expected %v, got %v", expected, actual)
+	}
+	return actual, nil
+}
+
+func (v *validator) popVals(ts []types.ValueType) error {
+	for i := len(ts) - 1; i >= 0; i-- {
+		if _, err := v.popExpect(ts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushCtrl enters a block taking params, which are on top of the operand stack already
+func (v *validator) pushCtrl(code Bytecode, params, results []types.ValueType) *ctrlFrame {
+	f := &ctrlFrame{code: code, params: params, results: results, height: len(v.vals) - len(params), elseFixup: -1}
+	v.ctrls = append(v.ctrls, f)
+	return f
+}
+
+// popCtrl leaves the innermost frame and resolves side table entries targeting its end
+func (v *validator) popCtrl() (*ctrlFrame, error) {
+	f := v.ctrls[len(v.ctrls)-1]
+	if err := v.popVals(f.results); err != nil {
+		return nil, err
+	}
+	if len(v.vals) != f.height {
+		return nil, fmt.Errorf("%d values remain on the operand stack at the end of block", len(v.vals)-f.height)
+	}
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+	if f.elseFixup >= 0 {
+		f.fixups = append(f.fixups, f.elseFixup)
+	}
+	for _, fixup := range f.fixups {
+		v.sideTable[fixup].pc = uint32(v.pos())
+		v.sideTable[fixup].stp = uint32(len(v.sideTable))
+	}
+	return f, nil
+}
+
+func (v *validator) markUnreachable() {
+	top := v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:top.height]
+	top.unreachable = true
+}
+
+func labelTypes(f *ctrlFrame) []types.ValueType {
+	if f.code == loopOp {
+		return f.params
+	}
+	return f.results
+}
+
+func (v *validator) label(depth uint32) (*ctrlFrame, error) {
+	if int(depth) >= len(v.ctrls) {
+		return nil, fmt.Errorf("branch depth %d exceeds number of enclosing blocks", depth)
+	}
+	return v.ctrls[len(v.ctrls)-1-int(depth)], nil
+}
+
+// addEntry appends a side table entry transferring control to the label f
+func (v *validator) addEntry(f *ctrlFrame) {
+	entry := sideTableEntry{height: uint32(f.height), arity: uint32(len(labelTypes(f)))}
+	if f.code == loopOp {
+		entry.pc, entry.stp = uint32(f.start), uint32(f.startStp)
+	} else {
+		f.fixups = append(f.fixups, len(v.sideTable))
+	}
+	v.sideTable = append(v.sideTable, entry)
+}
+
+// readBlockType returns parameters and results of a block
+func (v *validator) readBlockType() ([]types.ValueType, []types.ValueType, error) {
+	b, err := v.reader.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch t := types.ValueType(b); t {
+	case types.ValueTypeEmpty:
+		return nil, nil, nil
+	case types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64,
+		types.ValueTypeVector, types.ValueTypeFuncRef, types.ValueTypeExternRef:
+		v.features |= valueTypeFeatures(t)
+		return nil, []types.ValueType{t}, nil
+	}
+	index, err := readTypeIndex(b, v.reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, ok := v.module.sig(index)
+	if !ok {
+		return nil, nil, fmt.Errorf("block refers to unknown type %d", index)
+	}
+	v.features |= FeatureMultiValue
+	return sig.Params, sig.results(), nil
+}
+
+// accessSize returns number of bytes a load or a store reads or writes
+func accessSize(code Bytecode) uint32 {
+	switch code {
+	case i32Load8SOp, i32Load8UOp, i64Load8SOp, i64Load8UOp, i32Store8Op, i64Store8Op:
+		return 1
+	case i32Load16SOp, i32Load16UOp, i64Load16SOp, i64Load16UOp, i32Store16Op, i64Store16Op:
+		return 2
+	case i32LoadOp, f32LoadOp, i64Load32SOp, i64Load32UOp, i32StoreOp, f32StoreOp, i64Store32Op:
+		return 4
+	}
+	return 8
+}
+
+func (v *validator) applyOp(op Op) error {
+	if countTypes(op.InputTypes) > 0 {
+		if err := v.popVals(op.InputTypes); err != nil {
+			return err
+		}
+	}
+	if countTypes(op.OutputTypes) > 0 {
+		v.pushVals(op.OutputTypes)
+	}
+	return nil
+}
+
+func (v *validator) validateIns(code Bytecode) error {
+	switch code {
+	default:
+		op := lookupOp(code)
+		if !op.IsValid() || op.Vararg {
+			return fmt.Errorf("unknown opcode %#x", byte(code))
+		}
+		return v.applyOp(op)
+	case unreachableOp:
+		v.markUnreachable()
+	case nopOp:
+	case blockOp, loopOp, ifOp:
+		params, results, err := v.readBlockType()
+		if err != nil {
+			return err
+		}
+		if code == ifOp {
+			if _, err = v.popExpect(types.ValueTypeI32); err != nil {
+				return err
+			}
+		}
+		if err = v.popVals(params); err != nil {
+			return err
+		}
+		v.pushVals(params)
+		f := v.pushCtrl(code, params, results)
+		switch code {
+		case loopOp:
+			f.start, f.startStp = v.pos(), len(v.sideTable)
+		case ifOp:
+			// else branch starts with the parameters of the block
+			f.elseFixup = len(v.sideTable)
+			v.sideTable = append(v.sideTable, sideTableEntry{height: uint32(f.height), arity: uint32(len(params))})
+		}
+	case elseOp:
+		f := v.ctrls[len(v.ctrls)-1]
+		if f.code != ifOp {
+			return fmt.Errorf("else doesn't belong to if")
+		}
+		if err := v.popVals(f.results); err != nil {
+			return err
+		}
+		if len(v.vals) != f.height {
+			return fmt.Errorf("%d values remain on the operand stack at the end of if", len(v.vals)-f.height)
+		}
+		// the end of then branch jumps over else branch
+		v.addEntry(f)
+		v.sideTable[f.elseFixup].pc = uint32(v.pos())
+		v.sideTable[f.elseFixup].stp = uint32(len(v.sideTable))
+		f.elseFixup = -1
+		f.code = elseOp
+		f.unreachable = false
+		v.pushVals(f.params)
+	case endOp:
+		if len(v.ctrls) == 1 {
+			return fmt.Errorf("end doesn't belong to any block")
+		}
+		f, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+		if f.code == ifOp && !equalTypes(f.params, f.results) {
+			return fmt.Errorf("if without else branch must produce its parameters")
+		}
+		v.pushVals(f.results)
+	case brOp, brIfOp:
+		depth, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		f, err := v.label(depth)
+		if err != nil {
+			return err
+		}
+		if code == brIfOp {
+			if _, err = v.popExpect(types.ValueTypeI32); err != nil {
+				return err
+			}
+		}
+		if err = v.popVals(labelTypes(f)); err != nil {
+			return err
+		}
+		v.addEntry(f)
+		if code == brOp {
+			v.markUnreachable()
+		} else {
+			v.pushVals(labelTypes(f))
+		}
+	case brTableOp:
+		count, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		if uint64(count) > uint64(v.src.Len()) {
+			return fmt.Errorf("br_table has more labels than bytes left")
+		}
+		labels := make([]*ctrlFrame, 0, count+1)
+		for i := uint32(0); i <= count; i++ {
+			depth, err := wbinary.ReadVarUint32(v.reader)
+			if err != nil {
+				return err
+			}
+			f, err := v.label(depth)
+			if err != nil {
+				return err
+			}
+			labels = append(labels, f)
+		}
+		if _, err = v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+		arity := len(labelTypes(labels[count]))
+		for _, f := range labels {
+			if len(labelTypes(f)) != arity {
+				return fmt.Errorf("br_table labels have inconsistent arity")
+			}
+			v.addEntry(f)
+		}
+		if err = v.popVals(labelTypes(labels[count])); err != nil {
+			return err
+		}
+		v.markUnreachable()
+	case returnOp:
+		if err := v.popVals(v.ctrls[0].results); err != nil {
+			return err
+		}
+		v.markUnreachable()
+	case callOp:
+		index, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		sig, ok := v.module.functionSig(index)
+		if !ok {
+			return fmt.Errorf("call to unknown function %d", index)
+		}
+		if err = v.popVals(sig.Params); err != nil {
+			return err
+		}
+		v.pushVals(sig.results())
+	case callIndirectOp:
+		typeIndex, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		tableIndex, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		sig, ok := v.module.sig(typeIndex)
+		if !ok {
+			return fmt.Errorf("call_indirect refers to unknown type %d", typeIndex)
+		}
+		table, ok := v.module.table(tableIndex)
+		if !ok {
+			return fmt.Errorf("call_indirect refers to unknown table %d", tableIndex)
+		}
+		if table.ElemType != FuncRefElementType {
+			return fmt.Errorf("call_indirect refers to table %d of non-function references", tableIndex)
+		}
+		if _, err = v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+		if err = v.popVals(sig.Params); err != nil {
+			return err
+		}
+		v.pushVals(sig.results())
+	case dropOp:
+		if _, err := v.popVal(); err != nil {
+			return err
+		}
+	case selectTypedOp:
+		ts, err := readValueTypes(v.reader)
+		if err != nil {
+			return err
+		}
+		if len(ts) != 1 {
+			return fmt.Errorf("select expects a single type, got %d", len(ts))
+		}
+		if err = v.checkValueType(ts[0]); err != nil {
+			return err
+		}
+		if _, err = v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+		if err = v.popVals([]types.ValueType{ts[0], ts[0]}); err != nil {
+			return err
+		}
+		v.pushVal(ts[0])
+	case selectOp:
+		if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+		t1, err := v.popVal()
+		if err != nil {
+			return err
+		}
+		t2, err := v.popExpect(t1)
+		if err != nil {
+			return err
+		}
+		if t1 == unknownType {
+			t1 = t2
+		}
+		if isReference(t1) {
+			return fmt.Errorf("select of references requires explicit type")
+		}
+		v.pushVal(t1)
+	case localGetOp, localSetOp, localTeeOp:
+		index, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		if int(index) >= len(v.locals) {
+			return fmt.Errorf("unknown local %d", index)
+		}
+		t := v.locals[index]
+		if code != localGetOp {
+			if _, err = v.popExpect(t); err != nil {
+				return err
+			}
+		}
+		if code != localSetOp {
+			v.pushVal(t)
+		}
+	case globalGetOp, globalSetOp:
+		index, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		global, ok := v.module.globalType(index)
+		if !ok {
+			return fmt.Errorf("unknown global %d", index)
+		}
+		if code == globalGetOp {
+			v.pushVal(global.Type)
+			break
+		}
+		if !global.Mutable {
+			return fmt.Errorf("global %d is immutable", index)
+		}
+		if _, err = v.popExpect(global.Type); err != nil {
+			return err
+		}
+	case i32ConstOp:
+		if _, err := wbinary.ReadVarInt32(v.reader); err != nil {
+			return err
+		}
+		v.pushVal(types.ValueTypeI32)
+	case i64ConstOp:
+		if _, err := wbinary.ReadVarInt64(v.reader); err != nil {
+			return err
+		}
+		v.pushVal(types.ValueTypeI64)
+	case f32ConstOp:
+		if _, err := wbinary.ReadU32(v.reader); err != nil {
+			return err
+		}
+		v.pushVal(types.ValueTypeF32)
+	case f64ConstOp:
+		if _, err := wbinary.ReadU64(v.reader); err != nil {
+			return err
+		}
+		v.pushVal(types.ValueTypeF64)
+	case i32LoadOp, i64LoadOp, f32LoadOp, f64LoadOp, i32Load8SOp, i32Load8UOp, i32Load16SOp, i32Load16UOp,
+		i64Load8SOp, i64Load8UOp, i64Load16SOp, i64Load16UOp, i64Load32SOp, i64Load32UOp,
+		i32StoreOp, i64StoreOp, f32StoreOp, f64StoreOp, i32Store8Op, i32Store16Op, i64Store8Op, i64Store16Op, i64Store32Op:
+		align, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		if _, err = wbinary.ReadVarUint32(v.reader); err != nil {
+			return err
+		}
+		if v.module.numMemories() == 0 {
+			return fmt.Errorf("memory access without memory")
+		}
+		if align > 3 || 1<<align > accessSize(code) {
+			return fmt.Errorf("alignment 2**%d exceeds natural alignment", align)
+		}
+		return v.applyOp(lookupOp(code))
+	case memorySizeOp, memoryGrowOp:
+		if reserved, err := v.reader.ReadByte(); err != nil {
+			return err
+		} else if reserved != 0 {
+			return fmt.Errorf("zero memory index expected")
+		}
+		if v.module.numMemories() == 0 {
+			return fmt.Errorf("%s without memory", lookupOp(code).Name)
+		}
+		return v.applyOp(lookupOp(code))
+	case miscPrefix:
+		in, err := readMiscOp(v.reader)
+		if err != nil {
+			return err
+		}
+		op := in.Op()
+		if miscReserved[in.sub] > 0 {
+			v.features |= FeatureBulkMemory
+			if v.module.numMemories() == 0 {
+				return fmt.Errorf("%s without memory", op.Name)
+			}
+		}
+		if miscTables[in.sub] > 0 {
+			return v.validateTableOp(in)
+		}
+		return v.applyOp(op)
+	case tableGetOp, tableSetOp:
+		index, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		t, err := v.tableType(index)
+		if err != nil {
+			return err
+		}
+		v.features |= FeatureReferenceTypes
+		if code == tableGetOp {
+			return v.applyOp(Op{InputTypes: types.ValueTypeSingleI32, OutputTypes: []types.ValueType{t}})
+		}
+		return v.applyOp(Op{InputTypes: []types.ValueType{types.ValueTypeI32, t}, OutputTypes: types.ValueTypeVoid})
+	case refNullOp:
+		b, err := v.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if t := types.ValueType(b); !isReference(t) {
+			return fmt.Errorf("ref.null of non-reference type %#x", b)
+		}
+		v.features |= FeatureReferenceTypes
+		v.pushVal(types.ValueType(b))
+	case refIsNullOp:
+		t, err := v.popVal()
+		if err != nil {
+			return err
+		}
+		if t != unknownType && !isReference(t) {
+			return fmt.Errorf("ref.is_null expects a reference, got %v", t)
+		}
+		v.features |= FeatureReferenceTypes
+		v.pushVal(types.ValueTypeI32)
+	case refFuncOp:
+		index, err := wbinary.ReadVarUint32(v.reader)
+		if err != nil {
+			return err
+		}
+		if _, ok := v.module.functionSig(index); !ok {
+			return fmt.Errorf("ref.func refers to unknown function %d", index)
+		}
+		v.features |= FeatureReferenceTypes
+		v.pushVal(types.ValueTypeFuncRef)
+	}
+	return nil
+}
+
+// checkValueType fails unless t is a value type and records the proposal introducing it
+func (v *validator) checkValueType(t types.ValueType) error {
+	switch t {
+	case types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64,
+		types.ValueTypeVector, types.ValueTypeFuncRef, types.ValueTypeExternRef:
+		v.features |= valueTypeFeatures(t)
+		return nil
+	}
+	return fmt.Errorf("invalid value type %#x", byte(t))
+}
+
+// tableType returns type of elements of table index
+func (v *validator) tableType(index uint32) (types.ValueType, error) {
+	table, ok := v.module.table(index)
+	if !ok {
+		return unknownType, fmt.Errorf("unknown table %d", index)
+	}
+	return types.ValueType(table.ElemType), nil
+}
+
+// validateTableOp checks prefixed table instruction in, whose operands depend on types of its tables
+func (v *validator) validateTableOp(in *MiscI) error {
+	t, err := v.tableType(in.tables[0])
+	if err != nil {
+		return err
+	}
+	i32 := types.ValueTypeI32
+	switch in.sub {
+	case tableCopy:
+		src, err := v.tableType(in.tables[1])
+		if err != nil {
+			return err
+		}
+		if src != t {
+			return fmt.Errorf("table.copy between tables of %v and %v", src, t)
+		}
+		v.features |= FeatureBulkMemory
+		if in.tables[0] != 0 || in.tables[1] != 0 {
+			v.features |= FeatureReferenceTypes
+		}
+		return v.applyOp(in.Op())
+	case tableGrow:
+		v.features |= FeatureReferenceTypes
+		return v.applyOp(Op{InputTypes: []types.ValueType{t, i32}, OutputTypes: types.ValueTypeSingleI32})
+	case tableFill:
+		v.features |= FeatureReferenceTypes
+		return v.applyOp(Op{InputTypes: []types.ValueType{i32, t, i32}, OutputTypes: types.ValueTypeVoid})
+	}
+	v.features |= FeatureReferenceTypes
+	return v.applyOp(in.Op())
+}
@@ -1,68 +1,113 @@
 package exec
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/threadedstream/wasmexperiments/internal/pkg/reporter"
 )
 
 const (
 	wasmPageSize = 65536
 )
 
-type context struct {
-	parent         *context // useful in blocks
-	pendingContext *context
-	stack          []uint64
-	locals         []uint64
-	raw            []byte
-	ins            []Instr
-	pc             int64
-	curFunc        int64
-	isBlock        bool
-	breakExecuted  bool
+// frame is a call frame of a single function invocation, locals and operands
+// of the frame live in the value stack of VM starting at locals and base respectively
+type frame struct {
+	locals  int
+	base    int
+	raw     []byte
+	code    []CompiledIns
+	pc      int
+	curFunc int64
+	// instruction being executed, see currIns
+	ins *CompiledIns
+	// in-place execution only: side table of the function and pointer into it,
+	// imm holds decoded immediates of the current instruction
+	sideTable []sideTableEntry
+	stp       int
+	imm       CompiledIns
 }
 
 type VM struct {
-	ctx       *context
-	frames    []*context
-	ctxchain  []*context
-	module    *Module
-	globals   []uint64
-	memory    []byte
-	funcs     []Function
-	funcTable map[Bytecode]func()
-	// for quick querying
-	funcMap      map[string]uint32
-	returned     bool
-	blockCounter uint32
+	ctx    *frame
+	frames []frame
+	// value stack shared by all frames, sp points past its topmost value
+	stack   []uint64
+	sp      int
+	results []uint64
+	module  *Module
+	globals []*GlobalInstance
+	memory  *MemoryInstance
+	tables  []*TableInstance
+	// indices of exports by their kind and name
+	exports [numExternalKinds]map[string]uint32
+	config  RuntimeConfig
+	// frames of the instances down the call chain, which called into this instance
+	outerDepth int
+	// instance calling into this one, if any
+	caller       *VM
+	interruption interruption
+	fuel         fuel
+	mode         ExecMode
+	// function index space of the instance, imports are resolved by the instance
+	funcs    []*Function
+	resolver ImportResolver
+	// references to functions of the instance made so far, see funcRef
+	funcRefs []*FuncRef
+	// host values passed to the instance as external references
+	externs externRefs
+	// values attached by the host, see WithHostData
+	hostData map[any]any
+	// passed to host functions
+	callCtx context.Context
 }
 
-func NewVM(m *Module) (*VM, error) {
-	vm := new(VM)
+// VMOption configures a VM on its creation
+type VMOption func(vm *VM)
 
-	vm.initFuncTable()
-	if m.MemorySection != nil && len(m.MemorySection.Entries) != 0 {
-		if len(m.MemorySection.Entries) > 1 {
-			return nil, errors.New("newVM: expected to have exactly one instance of memory")
-		}
-		vm.memory = make([]byte, m.MemorySection.Entries[0].Limits.Minimum*wasmPageSize)
-		fmt.Printf("##NewVM## Addr: %p, Len: %d\n", m.LinearMemoryIndexSpace, len(m.LinearMemoryIndexSpace))
-		copy(vm.memory, m.LinearMemoryIndexSpace[0])
+// WithImportResolver makes VM instantiate imports of the module with the help of resolver
+func WithImportResolver(resolver ImportResolver) VMOption {
+	return func(vm *VM) {
+		vm.resolver = resolver
+	}
+}
+
+func NewVM(m *Module, opts ...VMOption) (*VM, error) {
+	vm := new(VM)
+	vm.config = DefaultRuntimeConfig()
+	vm.callCtx = context.Background()
+	for _, opt := range opts {
+		opt(vm)
+	}
+	if err := vm.config.validate(); err != nil {
+		return nil, err
 	}
 
 	if m.FunctionIndexSpace == nil {
-		m.initializeFunctionIndexSpace()
+		if err := m.initializeFunctionIndexSpace(); err != nil {
+			return nil, err
+		}
 	}
 
-	vm.globals = make([]uint64, len(m.GlobalIndexSpace))
 	vm.module = m
+	if disabled := m.features() &^ vm.config.Features; disabled != 0 {
+		return nil, DisabledFeatureError{Features: disabled}
+	}
+	if err := vm.checkLimits(); err != nil {
+		return nil, err
+	}
+	if err := vm.resolveImports(vm.resolver); err != nil {
+		return nil, err
+	}
+	if err := vm.resolveExternals(vm.resolver); err != nil {
+		return nil, err
+	}
+	if err := vm.initSegments(); err != nil {
+		return nil, err
+	}
 
-	if m.ExportSection != nil {
-		vm.funcMap = make(map[string]uint32)
-		for _, entry := range m.ExportSection.Entries {
-			vm.funcMap[entry.Name] = entry.Index
-		}
+	if err := vm.indexExports(); err != nil {
+		return nil, err
 	}
 
 	if m.StartSection != nil {
@@ -72,87 +117,300 @@ func NewVM(m *Module) (*VM, error) {
 		}
 	}
 
-	vm.frames = make([]*context, 0, maxStackFrameNum)
-
 	return vm, nil
 }
 
-func (vm *VM) initFuncTable() {
-	if vm.funcTable == nil {
-		vm.funcTable = map[Bytecode]func(){
-			blockOp:     vm.execBlock,
-			brIfOp:      vm.execBrIfLoop,
-			brOp:        vm.execBrLoop,
-			loopOp:      vm.execLoop,
-			ifOp:        vm.execIf,
-			returnOp:    vm.ret,
-			i32LtSOp:    vm.i32LtS,
-			i32EqOp:     vm.i32Eq,
-			i32AddOp:    vm.i32Add,
-			i32SubOp:    vm.i32Sub,
-			i32MulOp:    vm.i32Mul,
-			i32DivSOp:   vm.i32DivS,
-			i32DivUOp:   vm.i32DivU,
-			i32RemSOp:   vm.i32RemS,
-			i32RemUOp:   vm.i32RemU,
-			f32AddOp:    vm.f32Add,
-			f32SubOp:    vm.f32Sub,
-			f32MulOp:    vm.f32Mul,
-			callOp:      vm.call,
-			localGetOp:  vm.getLocal,
-			localSetOp:  vm.setLocal,
-			globalGetOp: vm.getGlobal,
-			globalSetOp: vm.setGlobal,
-			i32ConstOp:  vm.i32Const,
-			i32LoadOp:   vm.i32Load,
-			f32LoadOp:   vm.f32Load,
-			i32StoreOp:  vm.i32Store,
-			f32StoreOp:  vm.f32Store,
-		}
+// funcTable maps opcodes of the internal bytecode onto their handlers
+var funcTable [256]func(vm *VM)
+
+func init() {
+	handlers := map[Bytecode]func(vm *VM){
+		unreachableOp:       (*VM).unreachable,
+		nopOp:               (*VM).nop,
+		brOp:                (*VM).execBr,
+		brIfOp:              (*VM).execBrIf,
+		brTableOp:           (*VM).execBrTable,
+		returnOp:            (*VM).ret,
+		callOp:              (*VM).call,
+		callIndirectOp:      (*VM).callIndirect,
+		dropOp:              (*VM).drop,
+		selectOp:            (*VM).sel,
+		selectTypedOp:       (*VM).sel,
+		jmpOp:               (*VM).jmp,
+		jmpIfNotOp:          (*VM).jmpIfNot,
+		localGetOp:          (*VM).getLocal,
+		localSetOp:          (*VM).setLocal,
+		localTeeOp:          (*VM).teeLocal,
+		globalGetOp:         (*VM).getGlobal,
+		globalSetOp:         (*VM).setGlobal,
+		tableGetOp:          (*VM).tableGet,
+		tableSetOp:          (*VM).tableSet,
+		refNullOp:           (*VM).refNull,
+		refIsNullOp:         (*VM).refIsNull,
+		refFuncOp:           (*VM).refFunc,
+		i32LoadOp:           (*VM).i32Load,
+		i64LoadOp:           (*VM).i64Load,
+		f32LoadOp:           (*VM).i32Load,
+		f64LoadOp:           (*VM).i64Load,
+		i32Load8SOp:         (*VM).i32Load8S,
+		i32Load8UOp:         (*VM).i32Load8U,
+		i32Load16SOp:        (*VM).i32Load16S,
+		i32Load16UOp:        (*VM).i32Load16U,
+		i64Load8SOp:         (*VM).i64Load8S,
+		i64Load8UOp:         (*VM).i64Load8U,
+		i64Load16SOp:        (*VM).i64Load16S,
+		i64Load16UOp:        (*VM).i64Load16U,
+		i64Load32SOp:        (*VM).i64Load32S,
+		i64Load32UOp:        (*VM).i64Load32U,
+		i32StoreOp:          (*VM).i32Store,
+		i64StoreOp:          (*VM).i64Store,
+		f32StoreOp:          (*VM).i32Store,
+		f64StoreOp:          (*VM).i64Store,
+		i32Store8Op:         (*VM).i32Store8,
+		i32Store16Op:        (*VM).i32Store16,
+		i64Store8Op:         (*VM).i64Store8,
+		i64Store16Op:        (*VM).i64Store16,
+		i64Store32Op:        (*VM).i64Store32,
+		memorySizeOp:        (*VM).memorySize,
+		memoryGrowOp:        (*VM).memoryGrow,
+		i32ConstOp:          (*VM).constant,
+		i64ConstOp:          (*VM).constant,
+		f32ConstOp:          (*VM).constant,
+		f64ConstOp:          (*VM).constant,
+		i32EqzOp:            (*VM).i32Eqz,
+		i32EqOp:             (*VM).i32Eq,
+		i32NeOp:             (*VM).i32Ne,
+		i32LtSOp:            (*VM).i32LtS,
+		i32LtUOp:            (*VM).i32LtU,
+		i32GtSOp:            (*VM).i32GtS,
+		i32GtUOp:            (*VM).i32GtU,
+		i32LeSOp:            (*VM).i32LeS,
+		i32LeUOp:            (*VM).i32LeU,
+		i32GeSOp:            (*VM).i32GeS,
+		i32GeUOp:            (*VM).i32GeU,
+		i32ClzOp:            (*VM).i32Clz,
+		i32CtzOp:            (*VM).i32Ctz,
+		i32PopcntOp:         (*VM).i32Popcnt,
+		i32AddOp:            (*VM).i32Add,
+		i32SubOp:            (*VM).i32Sub,
+		i32MulOp:            (*VM).i32Mul,
+		i32DivSOp:           (*VM).i32DivS,
+		i32DivUOp:           (*VM).i32DivU,
+		i32RemSOp:           (*VM).i32RemS,
+		i32RemUOp:           (*VM).i32RemU,
+		i32AndOp:            (*VM).i32And,
+		i32OrOp:             (*VM).i32Or,
+		i32XorOp:            (*VM).i32Xor,
+		i32ShlOp:            (*VM).i32Shl,
+		i32ShrSOp:           (*VM).i32ShrS,
+		i32ShrUOp:           (*VM).i32ShrU,
+		i32RotLOp:           (*VM).i32RotL,
+		i32RotROp:           (*VM).i32RotR,
+		f32EqOp:             (*VM).f32Eq,
+		f32NeOp:             (*VM).f32Ne,
+		f32LtOp:             (*VM).f32Lt,
+		f32GtOp:             (*VM).f32Gt,
+		f32LeOp:             (*VM).f32Le,
+		f32GeOp:             (*VM).f32Ge,
+		f32AbsOp:            (*VM).f32Abs,
+		f32NegOp:            (*VM).f32Neg,
+		f32CeilOp:           (*VM).f32Ceil,
+		f32FloorOp:          (*VM).f32Floor,
+		f32TruncOp:          (*VM).f32Trunc,
+		f32NearestOp:        (*VM).f32Nearest,
+		f32SqrtOp:           (*VM).f32Sqrt,
+		f32AddOp:            (*VM).f32Add,
+		f32SubOp:            (*VM).f32Sub,
+		f32MulOp:            (*VM).f32Mul,
+		f32DivOp:            (*VM).f32Div,
+		f32MinOp:            (*VM).f32Min,
+		f32MaxOp:            (*VM).f32Max,
+		f32CopysignOp:       (*VM).f32Copysign,
+		i64EqzOp:            (*VM).i64Eqz,
+		i64EqOp:             (*VM).i64Eq,
+		i64NeOp:             (*VM).i64Ne,
+		i64LtSOp:            (*VM).i64LtS,
+		i64LtUOp:            (*VM).i64LtU,
+		i64GtSOp:            (*VM).i64GtS,
+		i64GtUOp:            (*VM).i64GtU,
+		i64LeSOp:            (*VM).i64LeS,
+		i64LeUOp:            (*VM).i64LeU,
+		i64GeSOp:            (*VM).i64GeS,
+		i64GeUOp:            (*VM).i64GeU,
+		i64ClzOp:            (*VM).i64Clz,
+		i64CtzOp:            (*VM).i64Ctz,
+		i64PopcntOp:         (*VM).i64Popcnt,
+		i64AddOp:            (*VM).i64Add,
+		i64SubOp:            (*VM).i64Sub,
+		i64MulOp:            (*VM).i64Mul,
+		i64DivSOp:           (*VM).i64DivS,
+		i64DivUOp:           (*VM).i64DivU,
+		i64RemSOp:           (*VM).i64RemS,
+		i64RemUOp:           (*VM).i64RemU,
+		i64AndOp:            (*VM).i64And,
+		i64OrOp:             (*VM).i64Or,
+		i64XorOp:            (*VM).i64Xor,
+		i64ShlOp:            (*VM).i64Shl,
+		i64ShrSOp:           (*VM).i64ShrS,
+		i64ShrUOp:           (*VM).i64ShrU,
+		i64RotLOp:           (*VM).i64RotL,
+		i64RotROp:           (*VM).i64RotR,
+		f64EqOp:             (*VM).f64Eq,
+		f64NeOp:             (*VM).f64Ne,
+		f64LtOp:             (*VM).f64Lt,
+		f64GtOp:             (*VM).f64Gt,
+		f64LeOp:             (*VM).f64Le,
+		f64GeOp:             (*VM).f64Ge,
+		f64AbsOp:            (*VM).f64Abs,
+		f64NegOp:            (*VM).f64Neg,
+		f64CeilOp:           (*VM).f64Ceil,
+		f64FloorOp:          (*VM).f64Floor,
+		f64TruncOp:          (*VM).f64Trunc,
+		f64NearestOp:        (*VM).f64Nearest,
+		f64SqrtOp:           (*VM).f64Sqrt,
+		f64AddOp:            (*VM).f64Add,
+		f64SubOp:            (*VM).f64Sub,
+		f64MulOp:            (*VM).f64Mul,
+		f64DivOp:            (*VM).f64Div,
+		f64MinOp:            (*VM).f64Min,
+		f64MaxOp:            (*VM).f64Max,
+		f64CopysignOp:       (*VM).f64Copysign,
+		i32WrapI64Op:        (*VM).i32WrapI64,
+		i64ExtendI32SOp:     (*VM).i64ExtendI32S,
+		i64ExtendI32UOp:     (*VM).i64ExtendI32U,
+		miscPrefix:          (*VM).execMisc,
+		i32Extend8SOp:       (*VM).i32Extend8S,
+		i32Extend16SOp:      (*VM).i32Extend16S,
+		i64Extend8SOp:       (*VM).i64Extend8S,
+		i64Extend16SOp:      (*VM).i64Extend16S,
+		i64Extend32SOp:      (*VM).i64Extend32S,
+		i32TruncF32SOp:      (*VM).i32TruncF32S,
+		f32ConvertI32SOp:    (*VM).f32ConvertI32S,
+		i32TruncF32UOp:      (*VM).i32TruncF32U,
+		f32ConvertI32UOp:    (*VM).f32ConvertI32U,
+		i32TruncF64SOp:      (*VM).i32TruncF64S,
+		f64ConvertI32SOp:    (*VM).f64ConvertI32S,
+		i32TruncF64UOp:      (*VM).i32TruncF64U,
+		f64ConvertI32UOp:    (*VM).f64ConvertI32U,
+		i64TruncF32SOp:      (*VM).i64TruncF32S,
+		f32ConvertI64SOp:    (*VM).f32ConvertI64S,
+		i64TruncF32UOp:      (*VM).i64TruncF32U,
+		f32ConvertI64UOp:    (*VM).f32ConvertI64U,
+		i64TruncF64SOp:      (*VM).i64TruncF64S,
+		f64ConvertI64SOp:    (*VM).f64ConvertI64S,
+		i64TruncF64UOp:      (*VM).i64TruncF64U,
+		f64ConvertI64UOp:    (*VM).f64ConvertI64U,
+		f32DemoteF64Op:      (*VM).f32DemoteF64,
+		f64PromoteF32Op:     (*VM).f64PromoteF32,
+		i32ReinterpretF32Op: (*VM).reinterpret,
+		i64ReinterpretF64Op: (*VM).reinterpret,
+		f32ReinterpretI32Op: (*VM).reinterpret,
+		f64ReinterpretI64Op: (*VM).reinterpret,
+	}
+	for code, handler := range handlers {
+		funcTable[code] = handler
 	}
+	initInPlaceTable()
 }
 
-func (vm *VM) ExecFunc(index int64, args ...uint64) (ret any, err error) {
-	//// some validation of input parameters
-	//if int(index) > len(vm.funcs) {
-	//	return nil, fmt.Errorf("attempting to call a function with an index %d with length of funcs being %d", index, len(vm.funcs))
-	//}
-
-	// TODO(threadedstream): resolves to nil should the function of the following form be called
-	// (func $fac (export "fac") (param $x i32) (result i32)
-	fn := vm.module.GetFunction(int(index))
+// SetMaxCallDepth limits the number of nested calls, including the ones made by other instances
+// down the call chain, exceeding it traps with ErrCallStackExhausted
+func (vm *VM) SetMaxCallDepth(depth int) {
+	vm.config.MaxCallDepth = depth
+}
 
-	return fn.call(vm, index, args...)
+// ExecFunc calls function at index with args, the returned results are only valid
+// until the next call. Host functions may call it re-entrantly, traps of nested calls are
+// returned to the host function then
+func (vm *VM) ExecFunc(index int64, args ...uint64) ([]uint64, error) {
+	if len(vm.frames) == 0 {
+		vm.beginCall()
+		defer vm.endCall()
+	}
+	return vm.execFunc(index, args...)
 }
 
-func (vm *VM) execCode() any {
-	for int(vm.ctx.pc) < len(vm.ctx.ins) {
-		if vm.returned || (vm.ctx.breakExecuted && vm.ctx.isBlock) {
-			break
-		}
+func (vm *VM) execFunc(index int64, args ...uint64) (results []uint64, err error) {
+	if index < 0 || int(index) >= len(vm.funcs) {
+		return nil, fmt.Errorf("attempting to call a function with an index %d with length of funcs being %d", index, len(vm.funcs))
+	}
+	fn := vm.funcs[index]
+	if len(args) != fn.numParams {
+		return nil, errors.New("number of arguments do not match")
+	}
 
-		currCode := vm.ctx.ins[vm.ctx.pc].Op().Code
-		if currCode == endOp {
-			vm.ctx.pc++
-			break
-		}
-		if handler, ok := vm.funcTable[currCode]; ok {
-			handler()
-			if vm.ctx.breakExecuted {
-				vm.ctx = vm.ctx.pendingContext
-				if vm.ctx.isBlock {
-					vm.ctx.breakExecuted = true
-					vm.ctx = vm.ctx.parent
-					goto end
-				}
-				vm.ctx.breakExecuted = false
-				vm.ctx.pc = 0
+	sp, depth := vm.sp, len(vm.frames)
+	defer func() {
+		if r := recover(); r != nil {
+			trap, ok := r.(error)
+			if !ok {
+				panic(r)
 			}
-			continue
+			vm.restore(sp, depth)
+			results, err = nil, trap
 		}
-		reporter.ReportError("execCode: unknown instruction with code %v\n", currCode)
+	}()
+
+	vm.growStack(sp + len(args))
+	vm.sp += copy(vm.stack[sp:], args)
+	vm.collectExternRefs()
+	fn.call(vm, index)
+	vm.results = append(vm.results[:0], vm.stack[sp:vm.sp]...)
+	vm.sp = sp
+	return vm.results, nil
+}
+
+// restore unwinds frames above depth after a trap
+func (vm *VM) restore(sp, depth int) {
+	vm.sp, vm.frames = sp, vm.frames[:depth]
+	if depth > 0 {
+		vm.ctx = &vm.frames[depth-1]
+	} else {
+		vm.ctx = nil
+	}
+}
+
+// growStack makes room for at least n values in the value stack
+func (vm *VM) growStack(n int) {
+	if n <= len(vm.stack) {
+		return
+	}
+	limit := vm.config.MaxStackSize
+	if n > limit {
+		panic(ErrOperandStackExhausted)
+	}
+	size := 2 * len(vm.stack)
+	if size < n {
+		size = n
 	}
+	if size > limit {
+		size = limit
+	}
+	stack := make([]uint64, size)
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+}
 
-end:
-	return nil
+// SetExecMode switches between execution of compiled bytecode and in-place interpretation,
+// it takes effect for calls made afterwards
+func (vm *VM) SetExecMode(mode ExecMode) {
+	vm.mode = mode
+}
+
+// execCode runs the code of the current frame until its end is reached
+func (vm *VM) execCode() {
+	if vm.fuel.costs != nil {
+		vm.execMetered()
+		return
+	}
+	if vm.ctx.raw != nil {
+		vm.execInPlace()
+		return
+	}
+	for vm.ctx.pc < len(vm.ctx.code) {
+		in := &vm.ctx.code[vm.ctx.pc]
+		vm.ctx.pc++
+		vm.ctx.ins = in
+		funcTable[in.code](vm)
+	}
 }
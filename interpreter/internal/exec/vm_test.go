@@ -0,0 +1,282 @@
+package exec_test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/internal/exec"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+var execModes = []exec.ExecMode{exec.ExecModeCompiled, exec.ExecModeInPlace}
+
+func modeName(mode exec.ExecMode) string {
+	if mode == exec.ExecModeInPlace {
+		return "in-place"
+	}
+	return "compiled"
+}
+
+// instantiate decodes bin with the configuration of opts and instantiates it in mode
+func instantiate(t testing.TB, bin []byte, mode exec.ExecMode, opts ...exec.VMOption) *exec.VM {
+	t.Helper()
+	m, err := exec.DecodeModule(bin, exec.ConfigOf(opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := exec.NewVM(m, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.SetExecMode(mode)
+	return vm
+}
+
+func call(t testing.TB, vm *exec.VM, name string, args ...uint64) ([]uint64, error) {
+	t.Helper()
+	index, err := vm.QueryFunction(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm.ExecFunc(int64(index), args...)
+}
+
+func TestFactorial(t *testing.T) {
+	for _, mode := range execModes {
+		bin, err := os.ReadFile("../../testdata/wat-basic/fac.wasm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := instantiate(t, bin, mode)
+		for n, want := range map[uint64]uint64{0: 1, 1: 1, 5: 120, 10: 3628800, 12: 479001600} {
+			if r, err := call(t, vm, "fac", n); err != nil || r[0] != want {
+				t.Errorf("fac(%d) in %s mode = %v, %v, want %d", n, modeName(mode), r, err, want)
+			}
+		}
+
+		vm.SetMaxCallDepth(5)
+		if _, err := call(t, vm, "fac", 10); !errors.Is(err, exec.ErrCallStackExhausted) {
+			t.Fatalf("fac(10) with depth of 5 in %s mode: %v", modeName(mode), err)
+		}
+		// the frames of the trapped call are gone
+		if r, err := call(t, vm, "fac", 4); err != nil || r[0] != 24 {
+			t.Fatalf("fac(4) after trap in %s mode = %v, %v", modeName(mode), r, err)
+		}
+	}
+}
+
+// module of functions exercising most kinds of instructions
+func testModule() []byte {
+	m := &Module{
+		Types: [][]byte{
+			FuncType(B(I32), B(I32)),
+			FuncType(nil, B(I32)),
+			FuncType(B(I32, I32), B(I32)),
+			FuncType(B(F64), B(I32)),
+			FuncType(nil, nil),
+		},
+		Funcs: []Func{
+			// 0: fac, recursive
+			{Type: 0, Code: Cat(
+				B(0x20, 0), I32Const(2), B(0x48), // local.get 0; i32.const 2; i32.lt_s
+				B(0x04, I32), I32Const(1), // if (result i32) i32.const 1
+				B(0x05), B(0x20, 0), B(0x20, 0), I32Const(1), B(0x6b), B(0x10, 0), B(0x6c), // else n * fac(n-1)
+				B(0x0b),
+			)},
+			// 1: sum of 0..n-1 in a loop
+			{Type: 0, Locals: [][]byte{Locals(2, I32)}, Code: Cat(
+				B(0x03, 0x40),                               // loop
+				B(0x20, 2), B(0x20, 1), B(0x6a), B(0x21, 2), // s += i
+				B(0x20, 1), I32Const(1), B(0x6a), B(0x22, 1), // i++
+				B(0x20, 0), B(0x48), B(0x0d, 0), // br_if 0 (i < n)
+				B(0x0b),
+				B(0x20, 2),
+			)},
+			// 2: br_table mapping 0, 1, 2 to 10, 20, 30 and the rest to 40
+			{Type: 0, Code: Cat(
+				B(0x02, 0x40), B(0x02, 0x40), B(0x02, 0x40), B(0x02, 0x40),
+				B(0x20, 0), B(0x0e), Vec(U(0), U(1), U(2)), U(3),
+				B(0x0b), I32Const(10), B(0x0f),
+				B(0x0b), I32Const(20), B(0x0f),
+				B(0x0b), I32Const(30), B(0x0f),
+				B(0x0b), I32Const(40),
+			)},
+			// 3: stores x at 8 and loads its low byte sign-extended
+			{Type: 0, Code: Cat(
+				I32Const(8), B(0x20, 0), B(0x36, 2, 0), // i32.store
+				I32Const(8), B(0x2c, 0, 0), // i32.load8_s
+			)},
+			// 4: fills 16 bytes at 100 with x, copies them to 104 and returns the word at 116
+			{Type: 0, Code: Cat(
+				I32Const(100), B(0x20, 0), I32Const(16), B(0xfc), U(11), B(0), // memory.fill
+				I32Const(104), I32Const(100), I32Const(16), B(0xfc), U(10), B(0, 0), // memory.copy
+				I32Const(116), B(0x28, 2, 0), // i32.load
+			)},
+			// 5: i32.trunc_sat_f64_s
+			{Type: 3, Code: Cat(B(0x20, 0), B(0xfc), U(2))},
+			// 6: i32.extend8_s
+			{Type: 0, Code: Cat(B(0x20, 0), B(0xc0))},
+			// 7: call_indirect of type 0 of table element x with argument 5
+			{Type: 0, Code: Cat(I32Const(5), B(0x20, 0), B(0x11, 0, 0))},
+			// 8: ref.func 0 stored into table element x, returns ref.is_null of table element x + 1
+			{Type: 0, Code: Cat(
+				B(0x20, 0), B(0xd2, 0), B(0x26, 0), // table.set 0
+				B(0x20, 0), I32Const(1), B(0x6a), B(0x25, 0), B(0xd1), // table.get 0; ref.is_null
+			)},
+			// 9: grows table by x null elements, returns the old size plus the new one shifted
+			{Type: 0, Code: Cat(
+				B(0xd0, FuncRef), B(0x20, 0), B(0xfc), U(15), U(0), // table.grow 0
+				B(0xfc), U(16), U(0), I32Const(16), B(0x74), B(0x6a), // table.size 0 << 16 +
+			)},
+			// 10: typed select of x and y by x < y
+			{Type: 2, Code: Cat(B(0x20, 0), B(0x20, 1), B(0x20, 0), B(0x20, 1), B(0x48), B(0x1c), Vec(B(I32)))},
+			// 11: unreachable
+			{Type: 1, Code: B(0x00)},
+			// 12: i32.div_s
+			{Type: 2, Code: B(0x20, 0, 0x20, 1, 0x6d)},
+			// 13: i32.trunc_f64_s
+			{Type: 3, Code: Cat(B(0x20, 0), B(0xaa))},
+			// 14: i32.load at x
+			{Type: 0, Code: Cat(B(0x20, 0), B(0x28, 2, 0))},
+			// 15: memory.fill of x bytes at 0
+			{Type: 0, Code: Cat(I32Const(0), I32Const(0), B(0x20, 0), B(0xfc), U(11), B(0), I32Const(0))},
+			// 16: infinite recursion
+			{Type: 4, Code: B(0x10, 16)},
+			// 17: the function of type 4 called from table by call_indirect of type 0
+			{Type: 4},
+			// 18: returns memory.grow of x pages
+			{Type: 0, Code: Cat(B(0x20, 0), B(0x40, 0))},
+		},
+		Tables: [][]byte{Table(FuncRef, 4, -1)},
+		Mems:   [][]byte{Memory(1, 4)},
+		Elems:  [][]byte{Elem(0, 0, 6, 17)},
+	}
+	names := []string{"fac", "sum", "switch", "store_load", "fill_copy", "trunc_sat", "extend8",
+		"call_indirect", "ref_func", "table_grow", "select", "unreachable", "div", "trunc",
+		"load", "fill", "recurse", "noop", "memory_grow"}
+	for i, name := range names {
+		m.Exports = append(m.Exports, ExportFunc(name, uint32(i)))
+	}
+	return m.Bytes()
+}
+
+func f64(v float64) uint64 {
+	return math.Float64bits(v)
+}
+
+func TestExecModesAgree(t *testing.T) {
+	for _, tc := range []struct {
+		fn   string
+		args []uint64
+		want uint64
+	}{
+		{"fac", []uint64{10}, 3628800},
+		{"sum", []uint64{100000}, 4999950000 & math.MaxUint32},
+		{"switch", []uint64{0}, 10},
+		{"switch", []uint64{2}, 30},
+		{"switch", []uint64{7}, 40},
+		{"store_load", []uint64{0x1ff}, math.MaxUint32},
+		{"fill_copy", []uint64{0xab}, 0xabababab},
+		{"trunc_sat", []uint64{f64(1e20)}, math.MaxInt32},
+		{"trunc_sat", []uint64{f64(math.NaN())}, 0},
+		{"trunc_sat", []uint64{f64(-3.9)}, uint64(uint32(0xfffffffd))},
+		{"extend8", []uint64{0x80}, uint64(uint32(0xffffff80))},
+		{"call_indirect", []uint64{0}, 120},
+		{"call_indirect", []uint64{1}, 5},
+		{"ref_func", []uint64{1}, 0},
+		{"ref_func", []uint64{2}, 1},
+		{"table_grow", []uint64{3}, 4 + 7<<16},
+		{"select", []uint64{3, 9}, 3},
+		{"select", []uint64{9, 3}, 3},
+		{"memory_grow", []uint64{2}, 1},
+		{"memory_grow", []uint64{4}, math.MaxUint32},
+	} {
+		var results [][]uint64
+		for _, mode := range execModes {
+			vm := instantiate(t, testModule(), mode)
+			r, err := call(t, vm, tc.fn, tc.args...)
+			if err != nil {
+				t.Fatalf("%s%v in %s mode: %v", tc.fn, tc.args, modeName(mode), err)
+			}
+			results = append(results, r)
+		}
+		for i, r := range results {
+			if len(r) != 1 || r[0] != tc.want {
+				t.Errorf("%s%v in %s mode = %v, want %d", tc.fn, tc.args, modeName(execModes[i]), r, tc.want)
+			}
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	for _, mode := range execModes {
+		vm := instantiate(t, testModule(), mode, exec.WithMaxCallDepth(1000))
+		for _, tc := range []struct {
+			fn   string
+			args []uint64
+			want error
+		}{
+			{"unreachable", nil, exec.ErrUnreachable},
+			{"div", []uint64{1, 0}, exec.ErrIntegerDivideByZero},
+			{"div", []uint64{1 << 31, math.MaxUint32}, exec.ErrIntegerOverflow},
+			{"trunc", []uint64{f64(math.NaN())}, exec.ErrInvalidConversion},
+			{"trunc", []uint64{f64(1e20)}, exec.ErrIntegerOverflow},
+			{"load", []uint64{65536 - 2}, exec.ErrOutOfMemory},
+			{"fill", []uint64{65536 + 1}, exec.ErrOutOfMemory},
+			{"recurse", nil, exec.ErrCallStackExhausted},
+			{"call_indirect", []uint64{2}, exec.ErrIndirectCallTypeMismatch},
+			{"call_indirect", []uint64{3}, exec.UninitializedTableEntryError(3)},
+			{"call_indirect", []uint64{4}, exec.ErrUndefinedElement},
+			{"ref_func", []uint64{4}, exec.ErrTableIndexOutOfBounds},
+		} {
+			if _, err := call(t, vm, tc.fn, tc.args...); !errors.Is(err, tc.want) {
+				t.Errorf("%s%v in %s mode: got %v, want %v", tc.fn, tc.args, modeName(mode), err, tc.want)
+			}
+			// the instance stays usable after traps
+			if r, err := call(t, vm, "fac", 5); err != nil || r[0] != 120 {
+				t.Fatalf("fac after trap of %s in %s mode = %v, %v", tc.fn, modeName(mode), r, err)
+			}
+		}
+	}
+}
+
+func TestCallsDontAllocate(t *testing.T) {
+	for _, mode := range execModes {
+		vm := instantiate(t, testModule(), mode)
+		index, _ := vm.QueryFunction("fac")
+		// the first call compiles the function and grows the stack
+		vm.ExecFunc(int64(index), 10)
+		if allocs := testing.AllocsPerRun(100, func() { vm.ExecFunc(int64(index), 10) }); allocs != 0 {
+			t.Errorf("recursive calls in %s mode allocate %v times", modeName(mode), allocs)
+		}
+	}
+}
+
+// References to the same function or table element share a handle, so that loops taking
+// them over and over don't use up handles of the instance
+func TestReferencesShareHandles(t *testing.T) {
+	m := &Module{
+		Types: [][]byte{FuncType(B(I32), nil)},
+		Funcs: []Func{
+			{Type: 0, Code: B(
+				0x02, 0x40, 0x03, 0x40, // block loop
+				0x20, 0, 0x45, 0x0d, 1, // br_if 1 (x == 0)
+				0xd2, 0, 0x1a, // ref.func 0; drop
+				0x41, 0, 0x25, 0, 0x1a, // table.get 0 of element 0; drop
+				0x20, 0, 0x41, 1, 0x6b, 0x21, 0, // x -= 1
+				0x0c, 0, 0x0b, 0x0b, // br 0; end; end
+			)},
+		},
+		Tables:  [][]byte{Table(FuncRef, 1, -1)},
+		Exports: [][]byte{ExportFunc("spin", 0)},
+		Elems:   [][]byte{Elem(0, 0)},
+	}
+	for _, mode := range execModes {
+		vm := instantiate(t, m.Bytes(), mode)
+		if _, err := call(t, vm, "spin", 2_000_000); err != nil {
+			t.Fatalf("spin in %s mode: %v", modeName(mode), err)
+		}
+	}
+}
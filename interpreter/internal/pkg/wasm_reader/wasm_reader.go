@@ -50,10 +50,26 @@ func (wr *WasmReader) ReadByte() (byte, error) {
 	return bs[0], nil
 }
 
+// Len returns number of bytes left in the current reader, or -1 if it's unknown
+func (wr *WasmReader) Len() int {
+	if r, ok := wr.Peek().(interface{ Len() int }); ok {
+		return r.Len()
+	}
+	return -1
+}
+
+// ReadBytes reads exactly n bytes, n is checked against the bytes left before allocating
+// anything, so that lengths read from malformed input can't exhaust memory
 func (wr *WasmReader) ReadBytes(n int) ([]byte, error) {
+	if left := wr.Len(); left >= 0 && n > left {
+		if left == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
 	r := wr.Peek().(io.Reader)
 	bs := make([]byte, n, n)
-	if _, err := r.Read(bs); err != nil {
+	if _, err := io.ReadFull(r, bs); err != nil {
 		return nil, err
 	}
 	return bs, nil
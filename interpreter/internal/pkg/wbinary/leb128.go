@@ -95,3 +95,40 @@ func ReadVarInt33(wr *wasm_reader.WasmReader) (int64, error) {
 func ReadVarInt64(wr *wasm_reader.WasmReader) (int64, error) {
 	return readVarInt(wr, 64)
 }
+
+// DecodeVarUint32 decodes unsigned LEB128 at the start of bs returning the value and the number
+// of bytes it occupies, bs is expected to hold a valid encoding
+func DecodeVarUint32(bs []byte) (uint32, int) {
+	var (
+		res   uint32
+		shift uint
+	)
+	for i, b := range bs {
+		res |= uint32(b&0x7f) << shift
+		if b < 1<<7 {
+			return res, i + 1
+		}
+		shift += 7
+	}
+	return res, len(bs)
+}
+
+// DecodeVarInt64 decodes signed LEB128 at the start of bs returning the value and the number
+// of bytes it occupies, bs is expected to hold a valid encoding
+func DecodeVarInt64(bs []byte) (int64, int) {
+	var (
+		res   int64
+		shift uint
+	)
+	for i, b := range bs {
+		res |= int64(b&0x7f) << shift
+		shift += 7
+		if b < 1<<7 {
+			if shift < 64 && b&0x40 != 0 {
+				res |= -1 << shift
+			}
+			return res, i + 1
+		}
+	}
+	return res, len(bs)
+}
@@ -21,8 +21,16 @@ var (
 	ValueTypeVoid      = []ValueType{0x0}
 	ValueTypeSingleI32 = []ValueType{ValueTypeI32}
 	ValueTypeDoubleI32 = []ValueType{ValueTypeI32, ValueTypeI32}
+	ValueTypeTripleI32 = []ValueType{ValueTypeI32, ValueTypeI32, ValueTypeI32}
 	ValueTypeSingleF32 = []ValueType{ValueTypeF32}
 	ValueTypeDoubleF32 = []ValueType{ValueTypeF32, ValueTypeF32}
+	ValueTypeSingleI64 = []ValueType{ValueTypeI64}
+	ValueTypeDoubleI64 = []ValueType{ValueTypeI64, ValueTypeI64}
+	ValueTypeSingleF64 = []ValueType{ValueTypeF64}
+	ValueTypeDoubleF64 = []ValueType{ValueTypeF64, ValueTypeF64}
+	ValueTypeI32I64    = []ValueType{ValueTypeI32, ValueTypeI64}
+	ValueTypeI32F32    = []ValueType{ValueTypeI32, ValueTypeF32}
+	ValueTypeI32F64    = []ValueType{ValueTypeI32, ValueTypeF64}
 )
 
 var (
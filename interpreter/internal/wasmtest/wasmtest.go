@@ -0,0 +1,255 @@
+// Package wasmtest assembles WebAssembly binaries for tests of the interpreter. Code of
+// functions is written as raw bytes, it's up to tests to keep it valid
+package wasmtest
+
+import "math"
+
+// value types
+const (
+	I32       = 0x7f
+	I64       = 0x7e
+	F32       = 0x7d
+	F64       = 0x7c
+	FuncRef   = 0x70
+	ExternRef = 0x6f
+)
+
+// U encodes v as unsigned LEB128
+func U(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// S encodes v as signed LEB128
+func S(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// Str encodes a name
+func Str(s string) []byte {
+	return append(U(uint64(len(s))), s...)
+}
+
+// Vec encodes a vector of items
+func Vec(items ...[]byte) []byte {
+	out := U(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// Cat concatenates pieces of code
+func Cat(bs ...[]byte) []byte {
+	var out []byte
+	for _, b := range bs {
+		out = append(out, b...)
+	}
+	return out
+}
+
+// B returns bytes as they are, e.g. opcodes
+func B(bs ...byte) []byte {
+	return bs
+}
+
+// I32Const encodes i32.const v
+func I32Const(v int32) []byte {
+	return append([]byte{0x41}, S(int64(v))...)
+}
+
+// I64Const encodes i64.const v
+func I64Const(v int64) []byte {
+	return append([]byte{0x42}, S(v)...)
+}
+
+// F32Const encodes f32.const v
+func F32Const(v float32) []byte {
+	u := math.Float32bits(v)
+	return []byte{0x43, byte(u), byte(u >> 8), byte(u >> 16), byte(u >> 24)}
+}
+
+// F64Const encodes f64.const v
+func F64Const(v float64) []byte {
+	u := math.Float64bits(v)
+	out := []byte{0x44}
+	for i := 0; i < 8; i++ {
+		out = append(out, byte(u>>(8*i)))
+	}
+	return out
+}
+
+// FuncType encodes a function type
+func FuncType(params, results []byte) []byte {
+	return Cat(B(0x60), Vec(split(params)...), Vec(split(results)...))
+}
+
+func split(ts []byte) [][]byte {
+	out := make([][]byte, len(ts))
+	for i := range ts {
+		out[i] = ts[i : i+1]
+	}
+	return out
+}
+
+// Locals declares n locals of type t
+func Locals(n uint32, t byte) []byte {
+	return Cat(U(uint64(n)), B(t))
+}
+
+// Func is a function defined by the module
+type Func struct {
+	Type uint32
+	// local declarations made with Locals
+	Locals [][]byte
+	// body without the final end
+	Code []byte
+}
+
+// Module holds sections of a module, entries of sections are encoded already
+type Module struct {
+	Types   [][]byte
+	Imports [][]byte
+	Funcs   []Func
+	Tables  [][]byte
+	Mems    [][]byte
+	Globals [][]byte
+	Exports [][]byte
+	Start   *uint32
+	Elems   [][]byte
+	Datas   [][]byte
+}
+
+// ImportFunc imports a function of type typ
+func ImportFunc(module, name string, typ uint32) []byte {
+	return Cat(Str(module), Str(name), B(0), U(uint64(typ)))
+}
+
+// ImportMemory imports a memory of at least min pages
+func ImportMemory(module, name string, min uint32) []byte {
+	return Cat(Str(module), Str(name), B(2, 0), U(uint64(min)))
+}
+
+// ImportTable imports a table of elements of type t of at least min elements
+func ImportTable(module, name string, t byte, min uint32) []byte {
+	return Cat(Str(module), Str(name), B(1, t), Limits(min, -1))
+}
+
+// ImportGlobal imports a global of type t
+func ImportGlobal(module, name string, t byte, mutable bool) []byte {
+	m := byte(0)
+	if mutable {
+		m = 1
+	}
+	return Cat(Str(module), Str(name), B(3, t, m))
+}
+
+// ExportFunc exports function index
+func ExportFunc(name string, index uint32) []byte {
+	return Cat(Str(name), B(0), U(uint64(index)))
+}
+
+// ExportTable exports table index
+func ExportTable(name string, index uint32) []byte {
+	return Cat(Str(name), B(1), U(uint64(index)))
+}
+
+// ExportMemory exports memory index
+func ExportMemory(name string, index uint32) []byte {
+	return Cat(Str(name), B(2), U(uint64(index)))
+}
+
+// ExportGlobal exports global index
+func ExportGlobal(name string, index uint32) []byte {
+	return Cat(Str(name), B(3), U(uint64(index)))
+}
+
+// Limits encodes limits, max is ignored if it's negative
+func Limits(min uint32, max int64) []byte {
+	if max < 0 {
+		return Cat(B(0), U(uint64(min)))
+	}
+	return Cat(B(1), U(uint64(min)), U(uint64(max)))
+}
+
+// Memory declares a memory, max is ignored if it's negative
+func Memory(min uint32, max int64) []byte {
+	return Limits(min, max)
+}
+
+// Table declares a table of elements of type t
+func Table(t byte, min uint32, max int64) []byte {
+	return Cat(B(t), Limits(min, max))
+}
+
+// Global declares a global of type t initialized with constant instruction init
+func Global(t byte, mutable bool, init []byte) []byte {
+	m := byte(0)
+	if mutable {
+		m = 1
+	}
+	return Cat(B(t, m), init, B(0x0b))
+}
+
+// Elem places funcs into table 0 at offset
+func Elem(offset int32, funcs ...uint32) []byte {
+	indices := make([][]byte, len(funcs))
+	for i, f := range funcs {
+		indices[i] = U(uint64(f))
+	}
+	return Cat(B(0), I32Const(offset), B(0x0b), Vec(indices...))
+}
+
+// Data places data into memory 0 at offset
+func Data(offset int32, data []byte) []byte {
+	return Cat(B(0), I32Const(offset), B(0x0b), U(uint64(len(data))), data)
+}
+
+func section(id byte, body []byte) []byte {
+	return Cat(B(id), U(uint64(len(body))), body)
+}
+
+// Bytes encodes the module
+func (m *Module) Bytes() []byte {
+	out := []byte{0, 'a', 's', 'm', 1, 0, 0, 0}
+	add := func(id byte, entries [][]byte) {
+		if len(entries) > 0 {
+			out = append(out, section(id, Vec(entries...))...)
+		}
+	}
+	var funcs, bodies [][]byte
+	for _, f := range m.Funcs {
+		funcs = append(funcs, U(uint64(f.Type)))
+		body := Cat(Vec(f.Locals...), f.Code, B(0x0b))
+		bodies = append(bodies, Cat(U(uint64(len(body))), body))
+	}
+	add(1, m.Types)
+	add(2, m.Imports)
+	add(3, funcs)
+	add(4, m.Tables)
+	add(5, m.Mems)
+	add(6, m.Globals)
+	add(7, m.Exports)
+	if m.Start != nil {
+		out = append(out, section(8, U(uint64(*m.Start)))...)
+	}
+	add(9, m.Elems)
+	add(10, bodies)
+	add(11, m.Datas)
+	return out
+}
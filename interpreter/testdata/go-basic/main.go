@@ -14,7 +14,7 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	res, err := wapi.Call("tricky_loop_test", 0)
+	res, err := wapi.Call("tricky_loop_test", api.ValueI32(0))
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,9 @@
+use std::env;
+
+fn main() {
+    let sum = env::args()
+        .skip(1)
+        .filter_map(|arg| arg.parse().ok())
+        .fold(0, |sum, x| basic::_add(sum, x));
+    println!("sum is {}", sum);
+}
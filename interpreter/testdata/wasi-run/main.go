@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	"github.com/threadedstream/wasmexperiments/wasi"
+)
+
+// runs a binary built for wasm32-wasi or GOOS=wasip1, e.g. of testdata/rust-basic:
+// wasi-run sum.wasm 1 2 3
+func main() {
+	dir := flag.String("dir", "", "host directory preopened read-only as /data")
+	listen := flag.String("listen", "", "TCP address of a listener passed to the guest, its fd follows preopens")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("usage: wasi-run [-dir path] [-listen addr] module.wasm [args...]")
+	}
+
+	rt := api.NewRuntime()
+	b := wasi.NewBuilder().
+		WithArgs(flag.Args()...).
+		WithStdin(os.Stdin).
+		WithStdout(os.Stdout).
+		WithStderr(os.Stderr)
+	if *dir != "" {
+		fsys, err := wasi.HostDir(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.WithPreopen("/data", wasi.ReadOnly(fsys))
+	}
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.WithListener(l)
+	}
+	if _, err := b.Instantiate(rt); err != nil {
+		log.Fatal(err)
+	}
+	wapi, err := rt.InstantiateFile("", flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = wapi.Call("_start")
+	wapi.Close()
+	var exit wasi.ExitError
+	switch {
+	case errors.As(err, &exit):
+		os.Exit(int(exit.Code))
+	case err != nil:
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+(module
+    (func $fac (param $x i32) (result i32)
+        (if (result i32)
+            (i32.lt_s
+                (local.get $x)
+                (i32.const 2)
+            )
+            (then
+                (i32.const 1)
+            )
+            (else
+                (i32.mul
+                    (local.get $x)
+                    (call $fac
+                        (i32.sub
+                            (local.get $x)
+                            (i32.const 1)
+                        )
+                    )
+                )
+            )
+        )
+    )
+	(export "fac" (func $fac))
+)
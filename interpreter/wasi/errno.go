@@ -0,0 +1,177 @@
+package wasi
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Errno is an error code returned to guests by WASI functions
+type Errno uint16
+
+const (
+	ErrnoSuccess Errno = iota
+	Errno2big
+	ErrnoAcces
+	ErrnoAddrinuse
+	ErrnoAddrnotavail
+	ErrnoAfnosupport
+	ErrnoAgain
+	ErrnoAlready
+	ErrnoBadf
+	ErrnoBadmsg
+	ErrnoBusy
+	ErrnoCanceled
+	ErrnoChild
+	ErrnoConnaborted
+	ErrnoConnrefused
+	ErrnoConnreset
+	ErrnoDeadlk
+	ErrnoDestaddrreq
+	ErrnoDom
+	ErrnoDquot
+	ErrnoExist
+	ErrnoFault
+	ErrnoFbig
+	ErrnoHostunreach
+	ErrnoIdrm
+	ErrnoIlseq
+	ErrnoInprogress
+	ErrnoIntr
+	ErrnoInval
+	ErrnoIo
+	ErrnoIsconn
+	ErrnoIsdir
+	ErrnoLoop
+	ErrnoMfile
+	ErrnoMlink
+	ErrnoMsgsize
+	ErrnoMultihop
+	ErrnoNametoolong
+	ErrnoNetdown
+	ErrnoNetreset
+	ErrnoNetunreach
+	ErrnoNfile
+	ErrnoNobufs
+	ErrnoNodev
+	ErrnoNoent
+	ErrnoNoexec
+	ErrnoNolck
+	ErrnoNolink
+	ErrnoNomem
+	ErrnoNomsg
+	ErrnoNoprotoopt
+	ErrnoNospc
+	ErrnoNosys
+	ErrnoNotconn
+	ErrnoNotdir
+	ErrnoNotempty
+	ErrnoNotrecoverable
+	ErrnoNotsock
+	ErrnoNotsup
+	ErrnoNotty
+	ErrnoNxio
+	ErrnoOverflow
+	ErrnoOwnerdead
+	ErrnoPerm
+	ErrnoPipe
+	ErrnoProto
+	ErrnoProtonosupport
+	ErrnoPrototype
+	ErrnoRange
+	ErrnoRofs
+	ErrnoSpipe
+	ErrnoSrch
+	ErrnoStale
+	ErrnoTimedout
+	ErrnoTxtbsy
+	ErrnoXdev
+	ErrnoNotcapable
+)
+
+var errnoNames = [...]string{"success", "2big", "acces", "addrinuse", "addrnotavail", "afnosupport", "again",
+	"already", "badf", "badmsg", "busy", "canceled", "child", "connaborted", "connrefused", "connreset", "deadlk",
+	"destaddrreq", "dom", "dquot", "exist", "fault", "fbig", "hostunreach", "idrm", "ilseq", "inprogress", "intr",
+	"inval", "io", "isconn", "isdir", "loop", "mfile", "mlink", "msgsize", "multihop", "nametoolong", "netdown",
+	"netreset", "netunreach", "nfile", "nobufs", "nodev", "noent", "noexec", "nolck", "nolink", "nomem", "nomsg",
+	"noprotoopt", "nospc", "nosys", "notconn", "notdir", "notempty", "notrecoverable", "notsock", "notsup", "notty",
+	"nxio", "overflow", "ownerdead", "perm", "pipe", "proto", "protonosupport", "prototype", "range", "rofs", "spipe",
+	"srch", "stale", "timedout", "txtbsy", "xdev", "notcapable"}
+
+func (e Errno) String() string {
+	if int(e) < len(errnoNames) {
+		return "errno " + errnoNames[e]
+	}
+	return "errno unknown"
+}
+
+// Error makes Errno usable as an error by FS implementations, which may return it to
+// report a particular code to guests
+func (e Errno) Error() string {
+	return "wasi: " + e.String()
+}
+
+// ErrReadOnly is returned by read-only filesystems on attempts to modify them
+var ErrReadOnly = errors.New("wasi: read-only filesystem")
+
+// errnoOf maps errors of filesystems and host I/O onto error codes
+func errnoOf(err error) Errno {
+	var errno Errno
+	switch {
+	case err == nil:
+		return ErrnoSuccess
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, ErrReadOnly):
+		return ErrnoRofs
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrnoNoent
+	// ENOTEMPTY is ErrExist too
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return ErrnoNotempty
+	case errors.Is(err, fs.ErrExist):
+		return ErrnoExist
+	case errors.Is(err, fs.ErrPermission):
+		return ErrnoPerm
+	case errors.Is(err, fs.ErrInvalid):
+		return ErrnoInval
+	case errors.Is(err, fs.ErrClosed), errors.Is(err, os.ErrClosed):
+		return ErrnoBadf
+	case errors.Is(err, net.ErrClosed):
+		return ErrnoBadf
+	case errors.Is(err, syscall.ECONNRESET):
+		return ErrnoConnreset
+	case errors.Is(err, syscall.ECONNABORTED):
+		return ErrnoConnaborted
+	case errors.Is(err, syscall.ENOTCONN):
+		return ErrnoNotconn
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrnoAgain
+	case errors.Is(err, io.ErrClosedPipe), errors.Is(err, syscall.EPIPE):
+		return ErrnoPipe
+	case errors.Is(err, syscall.EBADF):
+		return ErrnoBadf
+	case errors.Is(err, syscall.EBUSY):
+		return ErrnoBusy
+	case errors.Is(err, syscall.ENOTDIR):
+		return ErrnoNotdir
+	case errors.Is(err, syscall.EISDIR):
+		return ErrnoIsdir
+	case errors.Is(err, syscall.EXDEV):
+		return ErrnoXdev
+	case errors.Is(err, syscall.ELOOP):
+		return ErrnoLoop
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return ErrnoNametoolong
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrnoNospc
+	case errors.Is(err, syscall.EFBIG):
+		return ErrnoFbig
+	case errors.Is(err, syscall.EINVAL):
+		return ErrnoInval
+	}
+	return ErrnoIo
+}
@@ -0,0 +1,591 @@
+package wasi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// optional operations of File
+type (
+	truncater interface {
+		Truncate(size int64) error
+	}
+	syncer interface {
+		Sync() error
+	}
+)
+
+const (
+	// limit of iovec structures passed at once, IOV_MAX of Linux
+	maxIovecs = 1024
+	// limit of data fd_write gathers from several buffers into a single write
+	maxGathered = 64 << 10
+)
+
+// iovecs returns views of n buffers described by iovec structures at ptr
+func iovecs(mem api.Memory, ptr, n uint32) ([][]byte, Errno) {
+	if n > maxIovecs {
+		return nil, ErrnoInval
+	}
+	// n comes from the guest, it's checked against memory before allocating
+	if uint64(ptr)+8*uint64(n) > uint64(mem.Size()) {
+		return nil, ErrnoFault
+	}
+	bufs := make([][]byte, 0, n)
+	for i := uint32(0); i < n; i++ {
+		offset, ok1 := mem.ReadUint32Le(ptr + 8*i)
+		size, ok2 := mem.ReadUint32Le(ptr + 8*i + 4)
+		if !ok1 || !ok2 {
+			return nil, ErrnoFault
+		}
+		buf, ok := mem.Read(offset, size)
+		if !ok {
+			return nil, ErrnoFault
+		}
+		bufs = append(bufs, buf)
+	}
+	return bufs, ErrnoSuccess
+}
+
+func writeSize(mem api.Memory, ptr uint32, n int) Errno {
+	if !mem.WriteUint32Le(ptr, uint32(n)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+// reader returns the stream fd_read reads from
+func (f *file) reader() (io.Reader, Errno) {
+	switch {
+	case f.r != nil:
+		return f.r, ErrnoSuccess
+	case f.typ == fileTypeDirectory:
+		return nil, ErrnoIsdir
+	case f.f != nil:
+		return f.f, ErrnoSuccess
+	}
+	return nil, ErrnoBadf
+}
+
+// writer returns the stream fd_write writes to
+func (f *file) writer() (io.Writer, Errno) {
+	switch {
+	case f.w != nil:
+		return f.w, ErrnoSuccess
+	case f.conn != nil:
+		return f.conn.c, ErrnoSuccess
+	case f.typ == fileTypeDirectory:
+		return nil, ErrnoIsdir
+	}
+	if w, ok := f.f.(io.Writer); ok {
+		return w, ErrnoSuccess
+	}
+	return nil, ErrnoBadf
+}
+
+// seeker returns the file if it supports random access
+func (f *file) seeker() (io.Seeker, Errno) {
+	switch {
+	case f.typ == fileTypeDirectory:
+		return nil, ErrnoIsdir
+	case f.f == nil:
+		return nil, ErrnoSpipe
+	}
+	if sk, ok := f.f.(io.Seeker); ok {
+		return sk, ErrnoSuccess
+	}
+	return nil, ErrnoSpipe
+}
+
+func (s *system) fdWrite(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	w, errno := f.writer()
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.flags&fdflagAppend != 0 {
+		if sk, ok := f.f.(io.Seeker); ok {
+			if _, err := sk.Seek(0, io.SeekEnd); err != nil {
+				return errnoOf(err)
+			}
+		}
+	}
+	total, err := writeBuffers(w, bufs)
+	if err != nil && total == 0 {
+		return errnoOf(err)
+	}
+	return writeSize(mem, uint32(stack[3]), total)
+}
+
+// writeBuffers writes bufs to w in order. Small buffers are gathered, so that a line printed
+// in pieces reaches the stream in a single write, larger ones are written as they are since
+// each of them may span the whole memory
+func writeBuffers(w io.Writer, bufs [][]byte) (int, error) {
+	size := 0
+	for _, buf := range bufs {
+		if size += len(buf); size > maxGathered {
+			size = maxGathered
+			break
+		}
+	}
+	gathered := make([]byte, 0, size)
+	total := 0
+	write := func(p []byte) error {
+		n, err := w.Write(p)
+		total += n
+		return err
+	}
+	for _, buf := range bufs {
+		if len(gathered)+len(buf) <= maxGathered {
+			gathered = append(gathered, buf...)
+			continue
+		}
+		if err := write(gathered); err != nil {
+			return total, err
+		}
+		gathered = gathered[:0]
+		if len(buf) > maxGathered {
+			if err := write(buf); err != nil {
+				return total, err
+			}
+			continue
+		}
+		gathered = append(gathered, buf...)
+	}
+	if len(gathered) == 0 {
+		return total, nil
+	}
+	return total, write(gathered)
+}
+
+func (s *system) fdRead(ctx context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.conn != nil {
+		n, errno := s.recv(ctx, f, bufs, 0)
+		if errno != ErrnoSuccess {
+			return errno
+		}
+		return writeSize(mem, uint32(stack[3]), n)
+	}
+	r, errno := f.reader()
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	total := 0
+	for _, buf := range bufs {
+		n, err := r.Read(buf)
+		total += n
+		if err != nil && err != io.EOF && total == 0 {
+			return errnoOf(err)
+		}
+		// a short read ends the call, the guest asks for the rest again
+		if err != nil || n < len(buf) {
+			break
+		}
+	}
+	return writeSize(mem, uint32(stack[3]), total)
+}
+
+func (s *system) fdPread(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if _, errno = f.seeker(); errno != ErrnoSuccess {
+		return errno
+	}
+	ra, ok := f.f.(io.ReaderAt)
+	if !ok {
+		return ErrnoNotsup
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	offset := int64(stack[3])
+	total := 0
+	for _, buf := range bufs {
+		n, err := ra.ReadAt(buf, offset+int64(total))
+		total += n
+		if err != nil && err != io.EOF && total == 0 {
+			return errnoOf(err)
+		}
+		if err != nil || n < len(buf) {
+			break
+		}
+	}
+	return writeSize(mem, uint32(stack[4]), total)
+}
+
+func (s *system) fdPwrite(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if _, errno = f.seeker(); errno != ErrnoSuccess {
+		return errno
+	}
+	wa, ok := f.f.(io.WriterAt)
+	if !ok {
+		return ErrnoNotsup
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	var data []byte
+	for _, buf := range bufs {
+		data = append(data, buf...)
+	}
+	n, err := wa.WriteAt(data, int64(stack[3]))
+	if err != nil && n == 0 {
+		return errnoOf(err)
+	}
+	return writeSize(mem, uint32(stack[4]), n)
+}
+
+func (s *system) fdSeek(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	sk, errno := f.seeker()
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	whence := int(uint32(stack[2]))
+	if whence > io.SeekEnd {
+		return ErrnoInval
+	}
+	offset, err := sk.Seek(int64(stack[1]), whence)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if !mem.WriteUint64Le(uint32(stack[3]), uint64(offset)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdTell(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	sk, errno := f.seeker()
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	offset, err := sk.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if !mem.WriteUint64Le(uint32(stack[1]), uint64(offset)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdClose(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	fd := uint32(stack[0])
+	s.mu.Lock()
+	f, ok := s.fds[fd]
+	delete(s.fds, fd)
+	s.mu.Unlock()
+	if !ok {
+		return ErrnoBadf
+	}
+	return f.close()
+}
+
+// close releases the file, host streams of stdio and host listeners are left open
+func (f *file) close() Errno {
+	switch {
+	case f.f != nil:
+		return errnoOf(f.f.Close())
+	case f.conn != nil:
+		return errnoOf(f.conn.close())
+	case f.listener != nil:
+		f.listener.close()
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdRenumber(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	from, to := uint32(stack[0]), uint32(stack[1])
+	s.mu.Lock()
+	f, ok := s.fds[from]
+	old, ok2 := s.fds[to]
+	if !ok || !ok2 {
+		s.mu.Unlock()
+		return ErrnoBadf
+	}
+	delete(s.fds, from)
+	s.fds[to] = f
+	s.mu.Unlock()
+	if old != f {
+		old.close()
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdFdstatGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	ptr := uint32(stack[1])
+	if !mem.Write(ptr, make([]byte, 24)) ||
+		!mem.WriteUint8(ptr, uint8(f.typ)) ||
+		!mem.WriteUint16Le(ptr+2, f.flags) ||
+		!mem.WriteUint64Le(ptr+8, rightsAll) ||
+		!mem.WriteUint64Le(ptr+16, rightsAll) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdFdstatSetFlags(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	flags := uint16(stack[1])
+	if flags&^(fdflagAppend|fdflagNonblock) != 0 {
+		return ErrnoNotsup
+	}
+	f.flags = flags
+	return ErrnoSuccess
+}
+
+// fdFdstatSetRights accepts any rights since all of them are granted to begin with
+func (s *system) fdFdstatSetRights(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	_, errno := s.file(uint32(stack[0]))
+	return errno
+}
+
+func (s *system) fdAdvise(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	_, errno := s.file(uint32(stack[0]))
+	return errno
+}
+
+func (s *system) fdAllocate(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	if _, errno := s.file(uint32(stack[0])); errno != ErrnoSuccess {
+		return errno
+	}
+	return ErrnoNotsup
+}
+
+func (s *system) fdSync(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if sy, ok := f.f.(syncer); ok {
+		return errnoOf(sy.Sync())
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdPrestatGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.preopen == "" {
+		return ErrnoBadf
+	}
+	ptr := uint32(stack[1])
+	// the only kind of prestat is a directory, tagged with zero
+	if !mem.WriteUint32Le(ptr, 0) || !mem.WriteUint32Le(ptr+4, uint32(len(f.preopen))) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) fdPrestatDirName(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.preopen == "" {
+		return ErrnoBadf
+	}
+	if uint32(stack[2]) < uint32(len(f.preopen)) {
+		return ErrnoNametoolong
+	}
+	if !mem.Write(uint32(stack[1]), []byte(f.preopen)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+// stat returns information about the file, stdio has none
+func (f *file) stat() (fs.FileInfo, Errno) {
+	var info fs.FileInfo
+	var err error
+	switch {
+	case f.f != nil:
+		info, err = f.f.Stat()
+	case f.fsys != nil:
+		info, err = f.fsys.Stat(f.name)
+	default:
+		return nil, ErrnoSuccess
+	}
+	if err != nil {
+		return nil, errnoOf(err)
+	}
+	return info, ErrnoSuccess
+}
+
+func (s *system) fdFilestatGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	info, errno := f.stat()
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	return writeFilestat(mem, uint32(stack[1]), f.typ, info)
+}
+
+// writeFilestat stores filestat structure of info at ptr, info is nil for streams
+func writeFilestat(mem api.Memory, ptr uint32, typ fileType, info fs.FileInfo) Errno {
+	var size, mtime uint64
+	if info != nil {
+		typ = fileTypeOf(info.Mode())
+		size = uint64(info.Size())
+		mtime = uint64(info.ModTime().UnixNano())
+	}
+	if !mem.Write(ptr, make([]byte, 64)) ||
+		!mem.WriteUint8(ptr+16, uint8(typ)) ||
+		!mem.WriteUint64Le(ptr+24, 1) ||
+		!mem.WriteUint64Le(ptr+32, size) ||
+		!mem.WriteUint64Le(ptr+40, mtime) ||
+		!mem.WriteUint64Le(ptr+48, mtime) ||
+		!mem.WriteUint64Le(ptr+56, mtime) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func fileTypeOf(mode fs.FileMode) fileType {
+	switch {
+	case mode.IsDir():
+		return fileTypeDirectory
+	case mode.IsRegular():
+		return fileTypeRegularFile
+	case mode&fs.ModeSymlink != 0:
+		return fileTypeSymbolicLink
+	case mode&fs.ModeSocket != 0:
+		return fileTypeSocketStream
+	case mode&fs.ModeCharDevice != 0:
+		return fileTypeCharacterDevice
+	case mode&fs.ModeDevice != 0:
+		return fileTypeBlockDevice
+	}
+	return fileTypeUnknown
+}
+
+func (s *system) fdFilestatSetSize(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.typ == fileTypeDirectory {
+		return ErrnoIsdir
+	}
+	t, ok := f.f.(truncater)
+	if !ok {
+		return ErrnoInval
+	}
+	return errnoOf(t.Truncate(int64(stack[1])))
+}
+
+func (s *system) fdFilestatSetTimes(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	if _, errno := s.file(uint32(stack[0])); errno != ErrnoSuccess {
+		return errno
+	}
+	return ErrnoNosys
+}
+
+func (s *system) fdReaddir(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.typ != fileTypeDirectory {
+		return ErrnoNotdir
+	}
+	buf, size, cookie := uint32(stack[1]), uint32(stack[2]), stack[3]
+	// the listing is taken once the guest starts reading from the beginning
+	if cookie == 0 || f.dirents == nil {
+		entries, err := readDir(f.fsys, f.name)
+		if err != nil {
+			return errnoOf(err)
+		}
+		f.dirents = entries
+	}
+
+	var out []byte
+	for i := cookie; i < uint64(len(f.dirents)) && uint32(len(out)) < size; i++ {
+		entry := f.dirents[i]
+		dirent := make([]byte, 24, 24+len(entry.Name()))
+		binary.LittleEndian.PutUint64(dirent[0:], i+1)
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(entry.Name())))
+		dirent[20] = byte(fileTypeOf(entry.Type()))
+		out = append(out, append(dirent, entry.Name()...)...)
+	}
+	// the last entry may be truncated, the guest is to call again with a bigger buffer
+	if uint32(len(out)) > size {
+		out = out[:size]
+	}
+	if !mem.Write(buf, out) {
+		return ErrnoFault
+	}
+	return writeSize(mem, uint32(stack[4]), len(out))
+}
+
+// readDir lists directory name of fsys sorted by name
+func readDir(fsys FS, name string) ([]fs.DirEntry, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not implemented")}
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	if entries == nil {
+		entries = []fs.DirEntry{}
+	}
+	return entries, nil
+}
@@ -0,0 +1,61 @@
+package wasi
+
+import (
+	"io/fs"
+	"os"
+)
+
+// FS is a directory tree preopened for guests. Names are slash-separated paths relative
+// to the root of FS, the root itself is ".". They are cleaned before being passed to FS
+// and never contain ".." elements, symbolic links have to be confined by FS itself
+type FS interface {
+	// OpenFile opens file name with flags of os.OpenFile
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Mkdir(name string, perm fs.FileMode) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// File is an open file of FS. Operations beyond reading are available to guests if
+// the file implements them: io.Writer, io.Seeker, io.ReaderAt, io.WriterAt,
+// Truncate(size int64) error and Sync() error, os.File implements all of them
+type File interface {
+	fs.File
+}
+
+// ReadOnly exposes fsys to guests, which are allowed to read it only. Note that os.DirFS
+// follows symbolic links leading out of its directory, ReadOnly of HostDir doesn't
+func ReadOnly(fsys fs.FS) FS {
+	return readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+func (r readOnlyFS) OpenFile(name string, flag int, _ fs.FileMode) (File, error) {
+	if flag&writeFlags != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	return r.fsys.Open(name)
+}
+
+func (r readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+func (r readOnlyFS) Mkdir(name string, _ fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) Rename(oldname, _ string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: ErrReadOnly}
+}
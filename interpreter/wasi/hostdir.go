@@ -0,0 +1,193 @@
+package wasi
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maximum number of symbolic links followed while resolving a name
+const maxSymlinks = 40
+
+// HostDirFS is a directory of the host exposed to guests with write access. Names are
+// resolved within the directory component by component: ".." can't climb above it and
+// symbolic links are followed only if their targets are relative and stay within the
+// directory, others fail with ErrnoNotcapable. Resolution doesn't guard against concurrent
+// modification of the tree by the host, the directory shouldn't be shared with untrusted
+// processes. Wrap it with ReadOnly to deny writes
+type HostDirFS struct {
+	root string
+}
+
+var (
+	_ FS    = (*HostDirFS)(nil)
+	_ fs.FS = (*HostDirFS)(nil)
+)
+
+// HostDir returns directory root of the host, which has to exist
+func HostDir(root string) (*HostDirFS, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: root, Err: syscall.ENOTDIR}
+	}
+	return &HostDirFS{root: root}, nil
+}
+
+// resolve returns path of the host for name, which contains no symbolic links except
+// for the last element unless follow is set
+func (d *HostDirFS) resolve(op, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	elems := strings.Split(name, "/")
+	var resolved []string
+	links := 0
+	for len(elems) > 0 {
+		elem := elems[0]
+		elems = elems[1:]
+		switch elem {
+		case ".", "":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", &fs.PathError{Op: op, Path: name, Err: ErrnoNotcapable}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		if len(elems) == 0 && !follow {
+			resolved = append(resolved, elem)
+			break
+		}
+		hostPath := d.join(append(resolved, elem))
+		info, err := os.Lstat(hostPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && len(elems) == 0:
+			// the last element may be created
+			resolved = append(resolved, elem)
+			continue
+		case err != nil:
+			return "", &fs.PathError{Op: op, Path: name, Err: unwrap(err)}
+		case info.Mode()&fs.ModeSymlink == 0:
+			resolved = append(resolved, elem)
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: unwrap(err)}
+		}
+		target = filepath.ToSlash(target)
+		if path.IsAbs(target) || filepath.IsAbs(target) {
+			return "", &fs.PathError{Op: op, Path: name, Err: ErrnoNotcapable}
+		}
+		elems = append(strings.Split(target, "/"), elems...)
+	}
+	return d.join(resolved), nil
+}
+
+func (d *HostDirFS) join(elems []string) string {
+	return filepath.Join(append([]string{d.root}, elems...)...)
+}
+
+// unwrap strips host paths from errors passed to guests
+func unwrap(err error) error {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		return pathErr.Err
+	case errors.As(err, &linkErr):
+		return linkErr.Err
+	}
+	return err
+}
+
+func (d *HostDirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	hostPath, err := d.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(hostPath, flag, perm)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrap(err)}
+	}
+	return f, nil
+}
+
+// Open opens name for reading
+func (d *HostDirFS) Open(name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (d *HostDirFS) Stat(name string) (fs.FileInfo, error) {
+	hostPath, err := d.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: unwrap(err)}
+	}
+	return info, nil
+}
+
+func (d *HostDirFS) Mkdir(name string, perm fs.FileMode) error {
+	hostPath, err := d.resolve("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	if err = os.Mkdir(hostPath, perm); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: unwrap(err)}
+	}
+	return nil
+}
+
+func (d *HostDirFS) Remove(name string) error {
+	hostPath, err := d.resolve("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if hostPath == d.root {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	if err = os.Remove(hostPath); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: unwrap(err)}
+	}
+	return nil
+}
+
+func (d *HostDirFS) Rename(oldname, newname string) error {
+	oldpath, err := d.resolve("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	newpath, err := d.resolve("rename", newname, false)
+	if err != nil {
+		return err
+	}
+	if oldpath == d.root || newpath == d.root {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EBUSY}
+	}
+	if err = os.Rename(oldpath, newpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: unwrap(err)}
+	}
+	return nil
+}
@@ -0,0 +1,508 @@
+package wasi
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is a writable directory tree kept in memory. Hosts fill it with inputs before
+// running guests and collect outputs afterwards, it implements io/fs interfaces to
+// make the latter easy, e.g. with fs.WalkDir. It's safe for concurrent use
+type MemFS struct {
+	mu   sync.Mutex
+	root *memNode
+	// total size of files and its limit in bytes, zero means no limit
+	size, limit int64
+}
+
+// default limit of the total size of files of MemFS
+const defaultMemFSLimit = 64 << 20
+
+type memNode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+	// entries of a directory, nil for regular files
+	children map[string]*memNode
+	// number of open files of the node, data of a removed node is accounted until they're closed
+	open    int
+	removed bool
+}
+
+var (
+	_ FS            = (*MemFS)(nil)
+	_ fs.ReadFileFS = (*MemFS)(nil)
+	_ fs.ReadDirFS  = (*MemFS)(nil)
+	_ fs.StatFS     = (*MemFS)(nil)
+)
+
+// NewMemFS returns an empty tree, whose files may take 64MiB in total
+func NewMemFS() *MemFS {
+	return &MemFS{root: newDirNode(0o755), limit: defaultMemFSLimit}
+}
+
+// SetLimit limits the total size of files to n bytes, zero means no limit. Writes beyond it
+// fail with syscall.ENOSPC or syscall.EFBIG if the file alone would exceed it. Files stored
+// already are kept even if they exceed the new limit
+func (m *MemFS) SetLimit(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limit = n
+}
+
+// resize changes size of file node, it's called with mu held
+func (m *MemFS) resize(op, name string, n *memNode, size int64) error {
+	delta := size - int64(len(n.data))
+	switch {
+	case size < 0 || m.limit > 0 && delta > 0 && size > m.limit:
+		return &fs.PathError{Op: op, Path: name, Err: syscall.EFBIG}
+	case m.limit > 0 && delta > 0 && m.size+delta > m.limit:
+		return &fs.PathError{Op: op, Path: name, Err: syscall.ENOSPC}
+	}
+	m.size += delta
+	if size <= int64(cap(n.data)) {
+		n.data = n.data[:size]
+		return nil
+	}
+	capacity := size + size/2
+	if m.limit > 0 && capacity > m.limit {
+		capacity = size
+	}
+	data := make([]byte, size, capacity)
+	copy(data, n.data)
+	n.data = data
+	return nil
+}
+
+// unlink accounts removal of node from the tree, it's called with mu held
+func (m *MemFS) unlink(n *memNode) {
+	n.removed = true
+	if n.open == 0 {
+		m.size -= int64(len(n.data))
+	}
+}
+
+func newDirNode(perm fs.FileMode) *memNode {
+	return &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now(), children: make(map[string]*memNode)}
+}
+
+func (n *memNode) info(name string) fs.FileInfo {
+	return memInfo{name: path.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// lookup returns the parent directory of name and the node of name itself, which is nil
+// if there's no such file. It's called with mu held
+func (m *MemFS) lookup(op, name string) (parent, node *memNode, err error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, m.root, nil
+	}
+	elems := strings.Split(name, "/")
+	parent = m.root
+	for _, elem := range elems[:len(elems)-1] {
+		next, ok := parent.children[elem]
+		switch {
+		case !ok:
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		case next.children == nil:
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		parent = next
+	}
+	return parent, parent.children[elems[len(elems)-1]], nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent, node, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case node == nil:
+		node = &memNode{mode: perm & fs.ModePerm, modTime: time.Now()}
+		parent.children[path.Base(name)] = node
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case node.children != nil && writable:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case flag&os.O_TRUNC != 0 && writable:
+		m.size -= int64(len(node.data))
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	node.open++
+	return &memFile{fsys: m, node: node, name: name, flag: flag}, nil
+}
+
+// Open opens name for reading
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, node, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return node.info(name), nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent, node, err := m.lookup("mkdir", name)
+	switch {
+	case err != nil:
+		return err
+	case node != nil:
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	parent.children[path.Base(name)] = newDirNode(perm)
+	return nil
+}
+
+// MkdirAll creates directory name along with missing parents
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	elems := strings.Split(name, "/")
+	for i := range elems {
+		err := m.Mkdir(strings.Join(elems[:i+1], "/"), perm)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	info, err := m.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent, node, err := m.lookup("remove", name)
+	switch {
+	case err != nil:
+		return err
+	case node == nil:
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	case parent == nil:
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	case len(node.children) > 0:
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(parent.children, path.Base(name))
+	m.unlink(node)
+	return nil
+}
+
+// Rename moves oldname to newname replacing newname unless it's a non-empty directory
+// or a file of another kind
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldparent, node, err := m.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newparent, target, err := m.lookup("rename", newname)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	switch {
+	case node == nil:
+		return fail(fs.ErrNotExist)
+	case oldparent == nil || newparent == nil:
+		return fail(syscall.EBUSY)
+	case target == node:
+		return nil
+	case node.children != nil && strings.HasPrefix(newname, oldname+"/"):
+		return fail(syscall.EINVAL)
+	case target != nil && target.children != nil && node.children == nil:
+		return fail(syscall.EISDIR)
+	case target != nil && target.children == nil && node.children != nil:
+		return fail(syscall.ENOTDIR)
+	case target != nil && len(target.children) > 0:
+		return fail(syscall.ENOTEMPTY)
+	}
+	delete(oldparent.children, path.Base(oldname))
+	newparent.children[path.Base(newname)] = node
+	if target != nil {
+		m.unlink(target)
+	}
+	return nil
+}
+
+// WriteFile creates or truncates file name and writes data to it, like os.WriteFile
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.(*memFile).Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, node, err := m.lookup("read", name)
+	switch {
+	case err != nil:
+		return nil, err
+	case node == nil:
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	case node.children != nil:
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+// ReadDir returns entries of directory name sorted by name
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, node, err := m.lookup("readdir", name)
+	switch {
+	case err != nil:
+		return nil, err
+	case node == nil:
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	case node.children == nil:
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return node.entries(), nil
+}
+
+// entries lists a directory, it's called with mu held
+func (n *memNode) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for name, child := range n.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info(name)))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memFile is an open file of MemFS, it stays usable once removed from the tree
+type memFile struct {
+	fsys   *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+	// entries of a directory not returned by ReadDir yet
+	dirents []fs.DirEntry
+}
+
+// check makes sure operation op may be applied to the file, it's called with mu held
+func (f *memFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case f.node.children != nil:
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0, !write && f.flag&os.O_WRONLY != 0:
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	return f.writeAt(p, off)
+}
+
+func (f *memFile) writeAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) || end < off {
+		if err := f.fsys.resize("write", f.name, f.node, end); err != nil {
+			return 0, err
+		}
+	}
+	f.node.modTime = time.Now()
+	return copy(f.node.data[off:], p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	old := int64(len(f.node.data))
+	if err := f.fsys.resize("truncate", f.name, f.node, size); err != nil {
+		return err
+	}
+	// bytes past the old end are zeroed as they may be left from a previous truncation
+	for i := old; i < size; i++ {
+		f.node.data[i] = 0
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.node.info(f.name), nil
+}
+
+// ReadDir lists a directory following fs.ReadDirFile
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	switch {
+	case f.closed:
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrClosed}
+	case f.node.children == nil:
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	if f.dirents == nil {
+		f.dirents = f.node.entries()
+	}
+	if n > 0 && len(f.dirents) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.dirents) {
+		n = len(f.dirents)
+	}
+	entries := f.dirents[:n:n]
+	f.dirents = f.dirents[n:]
+	return entries, nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.node.open--; f.node.open == 0 && f.node.removed {
+		f.fsys.size -= int64(len(f.node.data))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package wasi
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func create(t *testing.T, m *MemFS, name string) *memFile {
+	t.Helper()
+	f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.(*memFile)
+}
+
+func TestMemFSLimit(t *testing.T) {
+	m := NewMemFS()
+	m.SetLimit(100)
+	f := create(t, m, "a")
+	// offsets beyond the limit fail before allocating
+	for _, off := range []int64{1 << 40, 1<<63 - 1} {
+		if _, err := f.WriteAt([]byte("x"), off); !errors.Is(err, syscall.EFBIG) {
+			t.Fatalf("write at %d: %v", off, err)
+		}
+	}
+	if err := f.Truncate(60); err != nil {
+		t.Fatal(err)
+	}
+	g := create(t, m, "b")
+	if err := g.Truncate(50); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("truncate past the limit: %v", err)
+	}
+	// removed files count until they're closed
+	if err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Truncate(50); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("truncate past the limit with removed file open: %v", err)
+	}
+	f.Close()
+	if err := g.Truncate(50); err != nil {
+		t.Fatal(err)
+	}
+	// so do files replaced by rename
+	if err := m.WriteFile("c", make([]byte, 40), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rename("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Truncate(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("d", make([]byte, 20), 0o644); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("write past the limit with replaced file open: %v", err)
+	}
+	g.Close()
+	if err := m.WriteFile("d", make([]byte, 20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
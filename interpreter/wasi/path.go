@@ -0,0 +1,218 @@
+package wasi
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// flags of path_open
+const (
+	oflagCreat = 1 << iota
+	oflagDirectory
+	oflagExcl
+	oflagTrunc
+)
+
+// rights deciding whether path_open opens a file for reading or writing
+const (
+	rightFdRead  = 1 << 1
+	rightFdWrite = 1 << 6
+)
+
+// resolve returns the FS of directory dirfd and the name within it of the path at ptr,
+// paths escaping the directory aren't allowed
+func (s *system) resolve(mem api.Memory, dirfd, ptr, n uint32) (FS, string, Errno) {
+	dir, errno := s.file(dirfd)
+	if errno != ErrnoSuccess {
+		return nil, "", errno
+	}
+	if dir.typ != fileTypeDirectory {
+		return nil, "", ErrnoNotdir
+	}
+	p, ok := mem.ReadString(ptr, n)
+	if !ok {
+		return nil, "", ErrnoFault
+	}
+	name, errno := join(dir.name, p)
+	return dir.fsys, name, errno
+}
+
+// join resolves p relative to directory dir of FS
+func join(dir, p string) (string, Errno) {
+	switch {
+	case p == "":
+		return "", ErrnoNoent
+	case strings.IndexByte(p, 0) >= 0:
+		return "", ErrnoInval
+	case strings.HasPrefix(p, "/"):
+		return "", ErrnoNotcapable
+	}
+	name := path.Join(dir, p)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", ErrnoNotcapable
+	}
+	return name, ErrnoSuccess
+}
+
+func (s *system) pathOpen(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	fsys, name, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[2]), uint32(stack[3]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	oflags, rights, fdflags := uint16(stack[4]), stack[5], uint16(stack[7])
+
+	info, err := fsys.Stat(name)
+	exists := err == nil
+	switch {
+	case err != nil && errnoOf(err) != ErrnoNoent:
+		return errnoOf(err)
+	case exists && oflags&oflagCreat != 0 && oflags&oflagExcl != 0:
+		return ErrnoExist
+	case exists && info.IsDir():
+		if oflags&oflagTrunc != 0 {
+			return ErrnoIsdir
+		}
+		fd, errno := s.open(&file{typ: fileTypeDirectory, flags: fdflags, fsys: fsys, name: name})
+		if errno != ErrnoSuccess {
+			return errno
+		}
+		return writeSize(mem, uint32(stack[8]), int(fd))
+	case exists && oflags&oflagDirectory != 0:
+		return ErrnoNotdir
+	case !exists && oflags&oflagCreat == 0:
+		return ErrnoNoent
+	case !exists && oflags&oflagDirectory != 0:
+		return ErrnoNoent
+	}
+
+	flag := os.O_RDONLY
+	if rights&rightFdWrite != 0 || oflags&(oflagCreat|oflagTrunc) != 0 || fdflags&fdflagAppend != 0 {
+		flag = os.O_WRONLY
+		if rights&rightFdRead != 0 {
+			flag = os.O_RDWR
+		}
+	}
+	if oflags&oflagCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if oflags&oflagExcl != 0 {
+		flag |= os.O_EXCL
+	}
+	if oflags&oflagTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if fdflags&fdflagAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	f, err := fsys.OpenFile(name, flag, 0o644)
+	if err != nil {
+		return errnoOf(err)
+	}
+	fd, errno := s.open(&file{typ: fileTypeRegularFile, flags: fdflags, fsys: fsys, name: name, f: f})
+	if errno != ErrnoSuccess {
+		f.Close()
+		return errno
+	}
+	return writeSize(mem, uint32(stack[8]), int(fd))
+}
+
+func (s *system) pathFilestatGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	fsys, name, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[2]), uint32(stack[3]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	return writeFilestat(mem, uint32(stack[4]), fileTypeUnknown, info)
+}
+
+func (s *system) pathFilestatSetTimes(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	if _, _, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[2]), uint32(stack[3])); errno != ErrnoSuccess {
+		return errno
+	}
+	return ErrnoNosys
+}
+
+func (s *system) pathCreateDirectory(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	fsys, name, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	return errnoOf(fsys.Mkdir(name, 0o755))
+}
+
+func (s *system) pathRemoveDirectory(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return s.remove(mem, stack, true)
+}
+
+func (s *system) pathUnlinkFile(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return s.remove(mem, stack, false)
+}
+
+// remove removes a directory or a file, which has to be of the kind requested
+func (s *system) remove(mem api.Memory, stack []uint64, dir bool) Errno {
+	fsys, name, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if name == "." {
+		return ErrnoBusy
+	}
+	info, err := fsys.Stat(name)
+	switch {
+	case err != nil:
+		return errnoOf(err)
+	case dir && !info.IsDir():
+		return ErrnoNotdir
+	case !dir && info.IsDir():
+		return ErrnoIsdir
+	}
+	return errnoOf(fsys.Remove(name))
+}
+
+func (s *system) pathRename(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	oldfs, oldname, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	newfs, newname, errno := s.resolve(mem, uint32(stack[3]), uint32(stack[4]), uint32(stack[5]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if oldfs != newfs {
+		return ErrnoXdev
+	}
+	return errnoOf(oldfs.Rename(oldname, newname))
+}
+
+// pathReadlink fails since FS has no symbolic links visible to guests
+func (s *system) pathReadlink(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	fsys, name, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if _, err := fsys.Stat(name); err != nil {
+		return errnoOf(err)
+	}
+	return ErrnoInval
+}
+
+func (s *system) pathLink(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	if _, _, errno := s.resolve(mem, uint32(stack[0]), uint32(stack[2]), uint32(stack[3])); errno != ErrnoSuccess {
+		return errno
+	}
+	return ErrnoNotsup
+}
+
+func (s *system) pathSymlink(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	if _, _, errno := s.resolve(mem, uint32(stack[2]), uint32(stack[3]), uint32(stack[4])); errno != ErrnoSuccess {
+		return errno
+	}
+	return ErrnoNotsup
+}
@@ -0,0 +1,140 @@
+package wasi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+var pathFunctions = []string{"path_open", "path_create_directory", "path_unlink_file",
+	"fd_write", "fd_read", "fd_pwrite", "fd_filestat_set_size", "fd_close"}
+
+// openPath calls path_open of name relative to dirfd, the name is placed at 512 and
+// the descriptor is stored at 600
+func openPath(t *testing.T, inst *api.WasmApi, dirfd uint32, name string, oflags uint16) (uint32, Errno) {
+	t.Helper()
+	write(t, inst, 512, []byte(name))
+	errno := call(t, inst, "path_open", uint64(dirfd), 0, 512, uint64(len(name)), uint64(oflags),
+		rightsAll, rightsAll, 0, 600)
+	return readUint32(t, inst, 600), errno
+}
+
+func TestMemFSPaths(t *testing.T) {
+	m := NewMemFS()
+	inst := instantiate(t, NewBuilder().WithPreopen("/data", m), guest(false, pathFunctions...))
+
+	write(t, inst, 700, []byte("dir"))
+	if errno := call(t, inst, "path_create_directory", 3, 700, 3); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	fd, errno := openPath(t, inst, 3, "dir/file.txt", oflagCreat|oflagExcl)
+	if errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	write(t, inst, 100, []byte("stored"))
+	writeIovec(t, inst, 0, 100, 6)
+	if errno := call(t, inst, "fd_write", uint64(fd), 0, 1, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if errno := call(t, inst, "fd_close", uint64(fd)); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if data, err := m.ReadFile("dir/file.txt"); err != nil || string(data) != "stored" {
+		t.Fatalf("file = %q, %v", data, err)
+	}
+
+	if _, errno := openPath(t, inst, 3, "dir/file.txt", oflagCreat|oflagExcl); errno != ErrnoExist {
+		t.Fatalf("exclusive creation of existing file: %v", errno)
+	}
+	fd, errno = openPath(t, inst, 3, "dir/../dir/file.txt", 0)
+	if errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	writeIovec(t, inst, 0, 300, 64)
+	if errno := call(t, inst, "fd_read", uint64(fd), 0, 1, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if data, _ := inst.Memory().ReadString(300, readUint32(t, inst, 200)); data != "stored" {
+		t.Fatalf("fd_read = %q", data)
+	}
+
+	write(t, inst, 700, []byte("dir/file.txt"))
+	if errno := call(t, inst, "path_unlink_file", 3, 700, 12); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if _, errno := openPath(t, inst, 3, "dir/file.txt", 0); errno != ErrnoNoent {
+		t.Fatalf("open of removed file: %v", errno)
+	}
+}
+
+func TestMemFSLimitOfGuests(t *testing.T) {
+	m := NewMemFS()
+	m.SetLimit(1 << 20)
+	inst := instantiate(t, NewBuilder().WithPreopen("/", m), guest(false, pathFunctions...))
+	a, _ := openPath(t, inst, 3, "a", oflagCreat)
+	b, _ := openPath(t, inst, 3, "b", oflagCreat)
+
+	// a sparse file far beyond the limit mustn't be allocated
+	writeIovec(t, inst, 0, 100, 1)
+	if errno := call(t, inst, "fd_pwrite", uint64(a), 0, 1, 1<<40, 200); errno != ErrnoFbig {
+		t.Fatalf("fd_pwrite far beyond the limit: %v", errno)
+	}
+	if errno := call(t, inst, "fd_filestat_set_size", uint64(a), 1<<40); errno != ErrnoFbig {
+		t.Fatalf("fd_filestat_set_size far beyond the limit: %v", errno)
+	}
+	// files share the limit
+	if errno := call(t, inst, "fd_filestat_set_size", uint64(a), 768<<10); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if errno := call(t, inst, "fd_filestat_set_size", uint64(b), 512<<10); errno != ErrnoNospc {
+		t.Fatalf("files past the limit together: %v", errno)
+	}
+	if errno := call(t, inst, "fd_filestat_set_size", uint64(a), 0); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if errno := call(t, inst, "fd_filestat_set_size", uint64(b), 512<<10); errno != ErrnoSuccess {
+		t.Fatalf("file after the other shrank: %v", errno)
+	}
+}
+
+func TestHostDirEscapes(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "file"), []byte("file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../"+filepath.Base(outside)+"/secret", filepath.Join(root, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	d, err := HostDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := instantiate(t, NewBuilder().WithPreopen("/", d), guest(false, pathFunctions...))
+
+	if _, errno := openPath(t, inst, 3, "sub/../sub/file", 0); errno != ErrnoSuccess {
+		t.Fatalf("open inside the root: %v", errno)
+	}
+	for _, name := range []string{"../secret", "sub/../../secret", "/etc/passwd", "out/secret", "sub/up"} {
+		if _, errno := openPath(t, inst, 3, name, 0); errno != ErrnoNotcapable {
+			t.Errorf("open of %s: %v, want %v", name, errno, ErrnoNotcapable)
+		}
+		if _, errno := openPath(t, inst, 3, name, oflagCreat|oflagTrunc); errno != ErrnoNotcapable {
+			t.Errorf("creation of %s: %v, want %v", name, errno, ErrnoNotcapable)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "secret" {
+		t.Fatalf("file outside the root changed to %q", data)
+	}
+}
@@ -0,0 +1,173 @@
+package wasi
+
+import (
+	"context"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// types of events and subscriptions
+const (
+	eventClock = iota
+	eventFdRead
+	eventFdWrite
+)
+
+// flag of clock subscriptions with absolute timeouts
+const subclockAbstime = 1
+
+// flag of fd events reporting the end of a stream
+const eventHangup = 1
+
+const (
+	subscriptionSize = 48
+	eventSize        = 32
+)
+
+type event struct {
+	userdata uint64
+	errno    Errno
+	typ      uint8
+	nbytes   uint64
+	flags    uint16
+}
+
+type subscription struct {
+	event
+	// timeout of a clock subscription
+	deadline time.Time
+	// file of an fd subscription
+	f *file
+}
+
+// poll returns the event of the subscription unless the file isn't ready yet. Files other
+// than sockets never block
+func (sub *subscription) poll() (event, bool) {
+	ev := sub.event
+	switch f := sub.f; {
+	case f.conn != nil && ev.typ == eventFdRead:
+		ready, nbytes, hangup := f.conn.pollRead()
+		ev.nbytes = uint64(nbytes)
+		if hangup {
+			ev.flags = eventHangup
+		}
+		return ev, ready
+	case f.listener != nil && ev.typ == eventFdRead:
+		return ev, f.listener.ready()
+	case f.listener != nil:
+		ev.errno = ErrnoNotsup
+	}
+	return ev, true
+}
+
+func (s *system) pollOneoff(ctx context.Context, mem api.Memory, stack []uint64) Errno {
+	in, out, nsubs := uint32(stack[0]), uint32(stack[1]), uint32(stack[2])
+	if nsubs == 0 {
+		return ErrnoInval
+	}
+	// nsubs comes from the guest, it's checked against memory before allocating
+	if uint64(in)+uint64(nsubs)*subscriptionSize > uint64(mem.Size()) {
+		return ErrnoFault
+	}
+
+	subs := make([]subscription, nsubs)
+	start := time.Now()
+	for i := range subs {
+		sub := &subs[i]
+		ptr := in + uint32(i)*subscriptionSize
+		userdata, ok1 := mem.ReadUint64Le(ptr)
+		typ, ok2 := mem.ReadUint8(ptr + 8)
+		if !ok1 || !ok2 {
+			return ErrnoFault
+		}
+		sub.userdata, sub.typ = userdata, typ
+		switch typ {
+		case eventClock:
+			id, ok1 := mem.ReadUint32Le(ptr + 16)
+			timeout, ok2 := mem.ReadUint64Le(ptr + 24)
+			flags, ok3 := mem.ReadUint16Le(ptr + 40)
+			if !ok1 || !ok2 || !ok3 {
+				return ErrnoFault
+			}
+			if sub.errno = checkClock(id); sub.errno != ErrnoSuccess {
+				continue
+			}
+			wait := time.Duration(timeout)
+			if flags&subclockAbstime != 0 {
+				wait = 0
+				if now := s.clock(id); timeout > now {
+					wait = time.Duration(timeout - now)
+				}
+			}
+			sub.deadline = start.Add(wait)
+		case eventFdRead, eventFdWrite:
+			fd, ok := mem.ReadUint32Le(ptr + 16)
+			if !ok {
+				return ErrnoFault
+			}
+			sub.f, sub.errno = s.file(fd)
+		default:
+			return ErrnoInval
+		}
+	}
+
+	var events []event
+	for {
+		now := time.Now()
+		// the earliest timeout not reached yet
+		var next time.Time
+		for i := range subs {
+			sub := &subs[i]
+			switch {
+			case sub.errno != ErrnoSuccess:
+				events = append(events, sub.event)
+			case sub.typ == eventClock && !now.Before(sub.deadline):
+				events = append(events, sub.event)
+			case sub.typ == eventClock:
+				if next.IsZero() || sub.deadline.Before(next) {
+					next = sub.deadline
+				}
+			default:
+				if ev, ok := sub.poll(); ok {
+					events = append(events, ev)
+				}
+			}
+		}
+		if len(events) > 0 {
+			break
+		}
+
+		// sockets wake the guest up once they become ready
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-s.wake:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ErrnoIntr
+		}
+	}
+
+	for i, ev := range events {
+		ptr := out + uint32(i)*eventSize
+		if !mem.Write(ptr, make([]byte, eventSize)) ||
+			!mem.WriteUint64Le(ptr, ev.userdata) ||
+			!mem.WriteUint16Le(ptr+8, uint16(ev.errno)) ||
+			!mem.WriteUint8(ptr+10, ev.typ) ||
+			!mem.WriteUint64Le(ptr+16, ev.nbytes) ||
+			!mem.WriteUint16Le(ptr+24, ev.flags) {
+			return ErrnoFault
+		}
+	}
+	return writeSize(mem, uint32(stack[3]), len(events))
+}
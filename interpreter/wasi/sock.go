@@ -0,0 +1,447 @@
+package wasi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// flags of sock_recv and sock_shutdown
+const (
+	recvPeek = 1 << iota
+	recvWaitall
+)
+
+const (
+	shutRd = 1 << iota
+	shutWr
+)
+
+// acceptor accepts connections of a host listener on behalf of all instances of the host
+// module. Accepting is lazy: a connection is only accepted once a guest asks for one with
+// sock_accept or poll_oneoff, and it's handed to whichever instance calls sock_accept first
+type acceptor struct {
+	l net.Listener
+
+	mu sync.Mutex
+	// instances holding the listener open, they're notified once a connection is accepted
+	users     map[*system]int
+	accepting bool
+	// connection accepted, but not taken by a guest yet
+	pending net.Conn
+	// error ending accepting connections
+	err error
+}
+
+func newAcceptor(l net.Listener) *acceptor {
+	return &acceptor{l: l, users: make(map[*system]int)}
+}
+
+// deadliner is implemented by listeners of package net, deadlines interrupt accepting
+// once no instance uses the listener
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// acquire makes s a user of the listener
+func (a *acceptor) acquire(s *system) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users[s]++
+}
+
+// release ends use of the listener by s. Once it's not used by any instance, the pending
+// connection is closed and accepting is interrupted if the listener supports deadlines,
+// otherwise the connection accepted next is dropped
+func (a *acceptor) release(s *system) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.users[s]--; a.users[s] <= 0 {
+		delete(a.users, s)
+	}
+	if len(a.users) > 0 {
+		return
+	}
+	if a.pending != nil {
+		a.pending.Close()
+		a.pending = nil
+	}
+	if d, ok := a.l.(deadliner); ok && a.accepting {
+		d.SetDeadline(time.Now())
+	}
+}
+
+// start accepts a connection in the background unless there's one already, it's called
+// with mu held
+func (a *acceptor) start() {
+	if a.accepting || a.pending != nil || a.err != nil {
+		return
+	}
+	a.accepting = true
+	if d, ok := a.l.(deadliner); ok {
+		d.SetDeadline(time.Time{})
+	}
+	go a.run()
+}
+
+func (a *acceptor) run() {
+	conn, err := a.l.Accept()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accepting = false
+	switch {
+	case len(a.users) == 0:
+		if conn != nil {
+			conn.Close()
+		}
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// interrupted, but the listener is in use again
+		a.start()
+	default:
+		a.pending, a.err = conn, err
+		for s := range a.users {
+			s.notify()
+		}
+	}
+}
+
+// ready reports whether accept doesn't block, it starts accepting otherwise
+func (a *acceptor) ready() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.start()
+	return a.pending != nil || a.err != nil
+}
+
+// accept takes the connection accepted in the background, it fails with ErrnoAgain
+// if there's none and starts accepting
+func (a *acceptor) accept() (net.Conn, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.pending != nil:
+		conn := a.pending
+		a.pending = nil
+		return conn, nil
+	case a.err != nil:
+		return nil, a.err
+	}
+	a.start()
+	return nil, ErrnoAgain
+}
+
+// listener is a host listener open in an instance
+type listener struct {
+	a *acceptor
+	s *system
+}
+
+func newListener(a *acceptor, s *system) *listener {
+	a.acquire(s)
+	return &listener{a: a, s: s}
+}
+
+func (ln *listener) ready() bool {
+	return ln.a.ready()
+}
+
+func (ln *listener) accept() (net.Conn, error) {
+	return ln.a.accept()
+}
+
+func (ln *listener) close() {
+	ln.a.release(ln.s)
+}
+
+// conn receives data of a connection in the background, so that guests may wait for it
+// with poll_oneoff. Writes are passed to the connection directly
+type conn struct {
+	c      net.Conn
+	notify func()
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	started bool
+	closed  bool
+	// received data not read by the guest yet, more is received once it's drained
+	buf []byte
+	// error ending the stream, io.EOF once the peer is done writing
+	err      error
+	readShut bool
+}
+
+func newConn(c net.Conn, notify func()) *conn {
+	cn := &conn{c: c, notify: notify}
+	cn.cond = sync.NewCond(&cn.mu)
+	return cn
+}
+
+func (cn *conn) run() {
+	chunk := make([]byte, 32<<10)
+	for {
+		n, err := cn.c.Read(chunk)
+		cn.mu.Lock()
+		cn.buf = append(cn.buf, chunk[:n]...)
+		if err != nil {
+			cn.err = err
+		}
+		cn.notify()
+		for len(cn.buf) > 0 && cn.err == nil && !cn.closed {
+			cn.cond.Wait()
+		}
+		done := cn.err != nil || cn.closed
+		cn.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// readable reports whether read doesn't block, it's called with mu held
+func (cn *conn) readable() bool {
+	if !cn.started {
+		cn.started = true
+		go cn.run()
+	}
+	return len(cn.buf) > 0 || cn.err != nil || cn.readShut
+}
+
+// pollRead reports readiness for poll_oneoff: the number of bytes available and whether
+// the stream is over
+func (cn *conn) pollRead() (ready bool, nbytes int, hangup bool) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if !cn.readable() {
+		return false, 0, false
+	}
+	return true, len(cn.buf), cn.err != nil || cn.readShut
+}
+
+func (cn *conn) ready() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.readable()
+}
+
+// read fills bufs with data received so far, it fails with ErrnoAgain if there's none.
+// The end of the stream is reported as io.EOF
+func (cn *conn) read(bufs [][]byte, peek bool) (int, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if !cn.readable() {
+		return 0, ErrnoAgain
+	}
+	if len(cn.buf) == 0 {
+		if cn.readShut {
+			return 0, io.EOF
+		}
+		return 0, cn.err
+	}
+	total := 0
+	for _, buf := range bufs {
+		total += copy(buf, cn.buf[total:])
+	}
+	if !peek {
+		cn.buf = cn.buf[total:]
+		if len(cn.buf) == 0 {
+			cn.buf = nil
+			cn.cond.Signal()
+		}
+	}
+	return total, nil
+}
+
+func (cn *conn) shutdown(how uint32) Errno {
+	if how&shutRd != 0 {
+		cn.mu.Lock()
+		cn.readShut = true
+		cn.mu.Unlock()
+		if c, ok := cn.c.(interface{ CloseRead() error }); ok {
+			c.CloseRead()
+		}
+	}
+	if how&shutWr != 0 {
+		c, ok := cn.c.(interface{ CloseWrite() error })
+		if !ok {
+			return ErrnoNotsup
+		}
+		return errnoOf(c.CloseWrite())
+	}
+	return ErrnoSuccess
+}
+
+func (cn *conn) close() error {
+	cn.mu.Lock()
+	cn.closed = true
+	cn.cond.Signal()
+	cn.mu.Unlock()
+	return cn.c.Close()
+}
+
+// notify wakes up the guest waiting for sockets of the instance
+func (s *system) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until ready reports true or ctx is done
+func (s *system) wait(ctx context.Context, ready func() bool) Errno {
+	for !ready() {
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return ErrnoIntr
+		}
+	}
+	return ErrnoSuccess
+}
+
+// recv reads data received by connection f into bufs, it blocks unless f is non-blocking
+// or there's data already
+func (s *system) recv(ctx context.Context, f *file, bufs [][]byte, flags uint16) (int, Errno) {
+	total := 0
+	for {
+		if f.flags&fdflagNonblock == 0 {
+			if errno := s.wait(ctx, f.conn.ready); errno != ErrnoSuccess {
+				return total, errno
+			}
+		}
+		n, err := f.conn.read(skip(bufs, total), flags&recvPeek != 0)
+		total += n
+		switch {
+		case err == io.EOF:
+			return total, ErrnoSuccess
+		case err != nil && total > 0:
+			return total, ErrnoSuccess
+		case err != nil:
+			return 0, errnoOf(err)
+		case flags&recvWaitall == 0 || total == size(bufs):
+			return total, ErrnoSuccess
+		}
+	}
+}
+
+// skip returns bufs without the first n bytes
+func skip(bufs [][]byte, n int) [][]byte {
+	for len(bufs) > 0 && n >= len(bufs[0]) {
+		n -= len(bufs[0])
+		bufs = bufs[1:]
+	}
+	if len(bufs) == 0 {
+		return nil
+	}
+	return append([][]byte{bufs[0][n:]}, bufs[1:]...)
+}
+
+func size(bufs [][]byte) int {
+	n := 0
+	for _, buf := range bufs {
+		n += len(buf)
+	}
+	return n
+}
+
+func (s *system) sockAccept(ctx context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.listener == nil {
+		return ErrnoNotsock
+	}
+	flags := uint16(stack[1])
+	if flags&^fdflagNonblock != 0 {
+		return ErrnoInval
+	}
+	if f.flags&fdflagNonblock == 0 {
+		if errno = s.wait(ctx, f.listener.ready); errno != ErrnoSuccess {
+			return errno
+		}
+	}
+	c, err := f.listener.accept()
+	if err != nil {
+		return errnoOf(err)
+	}
+	fd, errno := s.open(&file{typ: fileTypeSocketStream, flags: flags, conn: newConn(c, s.notify)})
+	if errno != ErrnoSuccess {
+		c.Close()
+		return errno
+	}
+	return writeSize(mem, uint32(stack[2]), int(fd))
+}
+
+func (s *system) sockRecv(ctx context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.conn == nil {
+		return ErrnoNotsock
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	flags := uint16(stack[3])
+	if flags&^(recvPeek|recvWaitall) != 0 {
+		return ErrnoInval
+	}
+	n, errno := s.recv(ctx, f, bufs, flags)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if !mem.WriteUint16Le(uint32(stack[5]), 0) {
+		return ErrnoFault
+	}
+	return writeSize(mem, uint32(stack[4]), n)
+}
+
+func (s *system) sockSend(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	if f.conn == nil {
+		return ErrnoNotsock
+	}
+	bufs, errno := iovecs(mem, uint32(stack[1]), uint32(stack[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	var data []byte
+	for _, buf := range bufs {
+		data = append(data, buf...)
+	}
+	n, err := f.conn.c.Write(data)
+	if err != nil && n == 0 {
+		return errnoOf(err)
+	}
+	return writeSize(mem, uint32(stack[4]), n)
+}
+
+func (s *system) sockShutdown(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	f, errno := s.file(uint32(stack[0]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+	how := uint32(stack[1])
+	switch {
+	case f.listener != nil:
+		return ErrnoNotconn
+	case f.conn == nil:
+		return ErrnoNotsock
+	case how == 0 || how&^(shutRd|shutWr) != 0:
+		return ErrnoInval
+	}
+	errno = f.conn.shutdown(how)
+	s.notify()
+	return errno
+}
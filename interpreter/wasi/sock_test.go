@@ -0,0 +1,118 @@
+package wasi
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var sockFunctions = []string{"poll_oneoff", "sock_accept", "sock_recv", "sock_send", "sock_shutdown", "fd_close"}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestSocketEcho(t *testing.T) {
+	l := listen(t)
+	inst := instantiate(t, NewBuilder().WithListener(l), guest(false, sockFunctions...))
+
+	// listeners are non-blocking
+	if errno := call(t, inst, "sock_accept", 3, 0, 600); errno != ErrnoAgain {
+		t.Fatalf("sock_accept without connections: %v", errno)
+	}
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFdSubscription(t, inst, 0, 1, 3)
+	writeClockSubscription(t, inst, subscriptionSize, 2, time.Minute)
+	if errno := call(t, inst, "poll_oneoff", 0, 1024, 2, 2048); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if userdata, _ := inst.Memory().ReadUint64Le(1024); userdata != 1 {
+		t.Fatalf("poll_oneoff reported subscription %d, want the listener", userdata)
+	}
+	if errno := call(t, inst, "sock_accept", 3, 0, 600); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	fd := uint64(readUint32(t, inst, 600))
+
+	writeIovec(t, inst, 0, 100, 64)
+	if errno := call(t, inst, "sock_recv", fd, 0, 1, 0, 200, 204); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	n := readUint32(t, inst, 200)
+	if data, _ := inst.Memory().ReadString(100, n); data != "ping" {
+		t.Fatalf("sock_recv = %q", data)
+	}
+	writeIovec(t, inst, 0, 100, n)
+	if errno := call(t, inst, "sock_send", fd, 0, 1, 0, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if errno := call(t, inst, "sock_shutdown", fd, shutWr); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	client.SetDeadline(time.Now().Add(time.Minute))
+	if data, err := io.ReadAll(client); err != nil || string(data) != "ping" {
+		t.Fatalf("client received %q, %v", data, err)
+	}
+	if errno := call(t, inst, "sock_shutdown", 3, shutRd); errno != ErrnoNotconn {
+		t.Fatalf("sock_shutdown of the listener: %v", errno)
+	}
+}
+
+func TestListenerReleasedByClose(t *testing.T) {
+	l := listen(t)
+	inst := instantiate(t, NewBuilder().WithListener(l), guest(false, sockFunctions...))
+	// starts accepting in the background
+	if errno := call(t, inst, "sock_accept", 3, 0, 600); errno != ErrnoAgain {
+		t.Fatalf("sock_accept without connections: %v", errno)
+	}
+	if err := inst.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// connections go to the host once the instance is closed
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if d, ok := l.(deadliner); ok {
+			d.SetDeadline(time.Now().Add(time.Minute))
+		}
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+	// the accept interrupted by Close may still be starting and take a connection, which
+	// is dropped then
+	for i := 0; i < 100; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case c := <-accepted:
+			client.Close()
+			if c != nil {
+				c.Close()
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+			client.Close()
+		}
+	}
+	t.Fatal("host didn't accept connections after the instance was closed")
+}
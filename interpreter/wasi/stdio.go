@@ -0,0 +1,183 @@
+package wasi
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// Stdio are standard streams of an instance, nil ones are taken from Builder
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type stdioKey struct{}
+
+// WithStdio sets standard streams of the instance being created, e.g.
+//
+//	var out wasi.Buffer
+//	inst, err := rt.InstantiateFile("", "guest.wasm", wasi.WithStdio(wasi.Stdio{Stdout: &out}))
+func WithStdio(stdio Stdio) api.Option {
+	return api.WithHostData(stdioKey{}, lockStreams(stdio))
+}
+
+// syncWriter is implemented by writers serializing writes themselves
+type syncWriter interface {
+	io.Writer
+	serializesWrites()
+}
+
+// lockedWriter serializes writes to a stream, which may be shared by instances running
+// concurrently, so that output of a single write, such as a line gathered by fd_write, is
+// never interleaved with others.
+// Writers blocking for long hold up output of all instances sharing them
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (w *lockedWriter) serializesWrites() {}
+
+// lockedReader serializes reads from a stream
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (r *lockedReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Read(p)
+}
+
+// lockStreams wraps streams of stdio, so that reads and writes of each of them are serialized.
+// A writer set as both Stdout and Stderr gets a single lock
+func lockStreams(stdio Stdio) Stdio {
+	out := lockWriter(stdio.Stdout)
+	if sameWriter(stdio.Stdout, stdio.Stderr) {
+		stdio.Stderr = out
+	} else {
+		stdio.Stderr = lockWriter(stdio.Stderr)
+	}
+	stdio.Stdout = out
+	stdio.Stdin = lockReader(stdio.Stdin)
+	return stdio
+}
+
+// lockReader wraps r unless it's nil or wrapped already
+func lockReader(r io.Reader) io.Reader {
+	if _, ok := r.(*lockedReader); ok || r == nil {
+		return r
+	}
+	return &lockedReader{r: r}
+}
+
+// lockWriter wraps w unless it's nil or serializes writes itself
+func lockWriter(w io.Writer) io.Writer {
+	if _, ok := w.(syncWriter); ok || w == nil {
+		return w
+	}
+	return &lockedWriter{w: w}
+}
+
+func sameWriter(a, b io.Writer) bool {
+	t := reflect.TypeOf(a)
+	return a != nil && t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// Buffer captures output of guests, unlike bytes.Buffer it's safe for concurrent use. The
+// zero value is an empty buffer
+type Buffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *Buffer) serializesWrites() {}
+
+// Bytes returns a copy of the output captured so far
+func (b *Buffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *Buffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func (b *Buffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// LineWriter passes output of guests to a callback line by line
+type LineWriter struct {
+	mu sync.Mutex
+	fn func(line string)
+	// the last line written so far, which lacks newline yet
+	partial []byte
+}
+
+// NewLineWriter returns a writer calling fn with every line written to it without the
+// trailing newline, lines are passed one at a time in order they're written. The last
+// line lacking newline is passed on Close. fn must not write to the writer itself
+func NewLineWriter(fn func(line string)) *LineWriter {
+	return &LineWriter{fn: fn}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			break
+		}
+		w.partial = append(w.partial, p[:i]...)
+		w.fn(string(w.partial))
+		w.partial = w.partial[:0]
+		p = p[i+1:]
+	}
+	w.partial = append(w.partial, p...)
+	return n, nil
+}
+
+func (w *LineWriter) serializesWrites() {}
+
+// Close flushes the last line, if any
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.fn(string(w.partial))
+		w.partial = w.partial[:0]
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package wasi
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks writes until it's released
+type blockingWriter struct {
+	started, release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	return len(p), nil
+}
+
+func TestStreamsLockedSeparately(t *testing.T) {
+	out := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	var errOut Buffer
+	b := NewBuilder().WithStdout(out).WithStderr(&errOut)
+	bin := guest(false, "fd_write")
+	blocked, inst := instantiate(t, b, bin), instantiate(t, b, bin)
+
+	write(t, blocked, 100, []byte("out"))
+	writeIovec(t, blocked, 0, 100, 3)
+	done := make(chan Errno)
+	go func() {
+		r, err := blocked.CallRaw("fd_write", 1, 0, 1, 200)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- Errno(r[0])
+	}()
+	<-out.started
+
+	// stdout of another instance blocking doesn't hold up stderr
+	write(t, inst, 100, []byte("err"))
+	writeIovec(t, inst, 0, 100, 3)
+	written := make(chan Errno)
+	go func() { written <- call(t, inst, "fd_write", 2, 0, 1, 200) }()
+	select {
+	case errno := <-written:
+		if errno != ErrnoSuccess || errOut.String() != "err" {
+			t.Fatalf("fd_write to stderr = %v, stderr %q", errno, errOut.String())
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("fd_write to stderr blocked by stdout")
+	}
+	close(out.release)
+	if errno := <-done; errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+}
@@ -0,0 +1,264 @@
+package wasi
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+type fileType uint8
+
+const (
+	fileTypeUnknown fileType = iota
+	fileTypeBlockDevice
+	fileTypeCharacterDevice
+	fileTypeDirectory
+	fileTypeRegularFile
+	fileTypeSocketDgram
+	fileTypeSocketStream
+	fileTypeSymbolicLink
+)
+
+// flags of file descriptors
+const (
+	fdflagAppend = 1 << iota
+	fdflagDsync
+	fdflagNonblock
+	fdflagRsync
+	fdflagSync
+)
+
+// all rights defined by preview1, they are granted to every file descriptor
+const rightsAll = 1<<29 - 1
+
+// file is an open file descriptor of guests
+type file struct {
+	typ   fileType
+	flags uint16
+	// stdio streams
+	r io.Reader
+	w io.Writer
+	// files and directories opened by path_open, f is nil for directories
+	fsys FS
+	name string
+	f    File
+	// guest path of a preopened directory
+	preopen string
+	// entries of a directory listed by fd_readdir
+	dirents []fs.DirEntry
+	// sockets
+	listener *listener
+	conn     *conn
+}
+
+// system is the state of an instance importing the host module
+type system struct {
+	args    []string
+	environ []string
+	now     func() time.Time
+	start   time.Time
+	random  io.Reader
+
+	mu  sync.Mutex
+	fds map[uint32]*file
+	// limit of open file descriptors, stdio and preopens included
+	maxFds int
+	// signalled once a socket of the instance becomes ready
+	wake chan struct{}
+}
+
+// newSystem creates state of an instance configured by b, streams set in stdio take
+// precedence over the ones of b. Listeners of b are opened with acceptors
+func newSystem(b *Builder, acceptors []*acceptor, stdio Stdio) *system {
+	s := &system{
+		args:    b.args,
+		environ: b.environ,
+		now:     b.now,
+		start:   b.now(),
+		random:  b.random,
+		fds:     make(map[uint32]*file),
+		maxFds:  b.maxOpenFiles,
+		wake:    make(chan struct{}, 1),
+	}
+	if stdio.Stdin == nil {
+		stdio.Stdin = b.stdin
+	}
+	if stdio.Stdout == nil {
+		stdio.Stdout = b.stdout
+	}
+	if stdio.Stderr == nil {
+		stdio.Stderr = b.stderr
+	}
+	s.fds[0] = &file{typ: fileTypeCharacterDevice, r: stdio.Stdin}
+	s.fds[1] = &file{typ: fileTypeCharacterDevice, w: stdio.Stdout}
+	s.fds[2] = &file{typ: fileTypeCharacterDevice, w: stdio.Stderr}
+	for i, p := range b.preopens {
+		s.fds[uint32(3+i)] = &file{typ: fileTypeDirectory, fsys: p.fsys, name: ".", preopen: p.path}
+	}
+	// listeners are non-blocking, so that guests wait for connections with poll_oneoff
+	for i, a := range acceptors {
+		s.fds[uint32(3+len(b.preopens)+i)] = &file{typ: fileTypeSocketStream, flags: fdflagNonblock, listener: newListener(a, s)}
+	}
+	return s
+}
+
+// Close closes file descriptors of the instance once it's torn down, connections of sockets
+// included. Host streams of stdio and host listeners are left open
+func (s *system) Close() error {
+	s.mu.Lock()
+	fds := s.fds
+	s.fds = make(map[uint32]*file)
+	s.mu.Unlock()
+	for _, f := range fds {
+		f.close()
+	}
+	return nil
+}
+
+// file returns open file descriptor fd
+func (s *system) file(fd uint32) (*file, Errno) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.fds[fd]
+	if !ok {
+		return nil, ErrnoBadf
+	}
+	return f, ErrnoSuccess
+}
+
+// open allocates the lowest free file descriptor for f, it fails with ErrnoNfile once
+// the limit of open file descriptors is reached
+func (s *system) open(f *file) (uint32, Errno) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.fds) >= s.maxFds {
+		return 0, ErrnoNfile
+	}
+	fd := uint32(0)
+	for s.fds[fd] != nil {
+		fd++
+	}
+	s.fds[fd] = f
+	return fd, ErrnoSuccess
+}
+
+func (s *system) argsGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return writeStrings(mem, s.args, uint32(stack[0]), uint32(stack[1]))
+}
+
+func (s *system) argsSizesGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return writeSizes(mem, s.args, uint32(stack[0]), uint32(stack[1]))
+}
+
+func (s *system) environGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return writeStrings(mem, s.environ, uint32(stack[0]), uint32(stack[1]))
+}
+
+func (s *system) environSizesGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	return writeSizes(mem, s.environ, uint32(stack[0]), uint32(stack[1]))
+}
+
+// writeStrings stores pointers to strs at ptrs and the strings themselves terminated
+// with zero bytes at buf. Offsets are computed in 64 bits, so that the ones past
+// the 32-bit address space fault rather than wrap around
+func writeStrings(mem api.Memory, strs []string, ptrs, buf uint32) Errno {
+	ptr, offset := uint64(ptrs), uint64(buf)
+	for _, str := range strs {
+		if ptr+4 > 1<<32 || offset+uint64(len(str))+1 > 1<<32 {
+			return ErrnoFault
+		}
+		if !mem.WriteUint32Le(uint32(ptr), uint32(offset)) || !mem.Write(uint32(offset), append([]byte(str), 0)) {
+			return ErrnoFault
+		}
+		ptr += 4
+		offset += uint64(len(str)) + 1
+	}
+	return ErrnoSuccess
+}
+
+func writeSizes(mem api.Memory, strs []string, countPtr, sizePtr uint32) Errno {
+	size := 0
+	for _, str := range strs {
+		size += len(str) + 1
+	}
+	if !mem.WriteUint32Le(countPtr, uint32(len(strs))) || !mem.WriteUint32Le(sizePtr, uint32(size)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+// clock identifiers
+const (
+	clockRealtime = iota
+	clockMonotonic
+	clockProcessCPUTime
+	clockThreadCPUTime
+)
+
+func (s *system) clockResGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	if errno := checkClock(uint32(stack[0])); errno != ErrnoSuccess {
+		return errno
+	}
+	if !mem.WriteUint64Le(uint32(stack[1]), 1) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) clockTimeGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	id := uint32(stack[0])
+	if errno := checkClock(id); errno != ErrnoSuccess {
+		return errno
+	}
+	if !mem.WriteUint64Le(uint32(stack[2]), s.clock(id)) {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
+
+// clock returns time of clock id in nanoseconds
+func (s *system) clock(id uint32) uint64 {
+	if id == clockRealtime {
+		return uint64(s.now().UnixNano())
+	}
+	return uint64(s.now().Sub(s.start))
+}
+
+func checkClock(id uint32) Errno {
+	switch id {
+	case clockRealtime, clockMonotonic:
+		return ErrnoSuccess
+	case clockProcessCPUTime, clockThreadCPUTime:
+		return ErrnoNotsup
+	}
+	return ErrnoInval
+}
+
+func (s *system) randomGet(_ context.Context, mem api.Memory, stack []uint64) Errno {
+	buf, ok := mem.Read(uint32(stack[0]), uint32(stack[1]))
+	if !ok {
+		return ErrnoFault
+	}
+	if _, err := io.ReadFull(s.random, buf); err != nil {
+		return ErrnoIo
+	}
+	return ErrnoSuccess
+}
+
+func (s *system) procExit(_ context.Context, _ api.Memory, stack []uint64) Errno {
+	panic(ExitError{Code: uint32(stack[0])})
+}
+
+func (s *system) procRaise(context.Context, api.Memory, []uint64) Errno {
+	return ErrnoNosys
+}
+
+func (s *system) schedYield(context.Context, api.Memory, []uint64) Errno {
+	runtime.Gosched()
+	return ErrnoSuccess
+}
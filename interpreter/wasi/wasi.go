@@ -0,0 +1,287 @@
+// Package wasi implements wasi_snapshot_preview1 host module, which lets modules compiled
+// for wasm32-wasi or GOOS=wasip1 run in the interpreter. Guests see only what the host
+// configures with Builder: arguments, environment variables, stdio and preopened directories
+package wasi
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+)
+
+// ModuleName is the name guests import WASI functions from
+const ModuleName = "wasi_snapshot_preview1"
+
+const defaultMaxOpenFiles = 1024
+
+// ExitError is the trap of a guest calling proc_exit, it's returned by the call that
+// was running, e.g. of _start. Code is the exit code, zero included
+type ExitError struct {
+	Code uint32
+}
+
+func (e ExitError) Error() string {
+	return fmt.Sprintf("wasi: exit code %d", e.Code)
+}
+
+// Builder configures the environment of guests importing the host module it builds,
+// by default guests get no arguments, no environment, empty stdin and discarded output.
+// Streams of a particular instance are set with WithStdio on its instantiation
+type Builder struct {
+	args     []string
+	environ  []string
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	preopens []preopen
+	// listeners get file descriptors following preopened directories
+	listeners []net.Listener
+	now       func() time.Time
+	random    io.Reader
+	// limit of file descriptors open at once
+	maxOpenFiles int
+}
+
+type preopen struct {
+	path string
+	fsys FS
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		stdin:        strings.NewReader(""),
+		stdout:       io.Discard,
+		stderr:       io.Discard,
+		now:          time.Now,
+		random:       rand.Reader,
+		maxOpenFiles: defaultMaxOpenFiles,
+	}
+}
+
+// WithArgs sets command line arguments, the first of them is the name of the program
+func (b *Builder) WithArgs(args ...string) *Builder {
+	b.args = args
+	return b
+}
+
+// WithEnv adds environment variable key
+func (b *Builder) WithEnv(key, value string) *Builder {
+	b.environ = append(b.environ, key+"="+value)
+	return b
+}
+
+func (b *Builder) WithStdin(r io.Reader) *Builder {
+	b.stdin = r
+	return b
+}
+
+func (b *Builder) WithStdout(w io.Writer) *Builder {
+	b.stdout = w
+	return b
+}
+
+func (b *Builder) WithStderr(w io.Writer) *Builder {
+	b.stderr = w
+	return b
+}
+
+// WithPreopen makes fsys available to guests as directory path, preopened directories
+// get file descriptors from 3 on in order of their addition
+func (b *Builder) WithPreopen(path string, fsys FS) *Builder {
+	b.preopens = append(b.preopens, preopen{path: path, fsys: fsys})
+	return b
+}
+
+// WithListener passes l to guests as a preopened socket, they serve connections with
+// sock_accept. Listeners get file descriptors in order of their addition following the
+// ones of preopened directories, e.g. the first one is 3 unless there are directories.
+// Instances of the module share l, a connection goes to the one calling sock_accept first.
+// Guests don't close l, it's up to the host once instances are closed with api.WasmApi.Close
+func (b *Builder) WithListener(l net.Listener) *Builder {
+	b.listeners = append(b.listeners, l)
+	return b
+}
+
+// WithClock sets the source of wall clock time, monotonic clock counts time elapsed
+// according to now since the first call of an instance
+func (b *Builder) WithClock(now func() time.Time) *Builder {
+	b.now = now
+	return b
+}
+
+// WithMaxOpenFiles limits the number of file descriptors open at once, stdio and preopened
+// directories included. Opening more fails with ErrnoNfile, the default limit is 1024
+func (b *Builder) WithMaxOpenFiles(n int) *Builder {
+	b.maxOpenFiles = n
+	return b
+}
+
+// WithRandom sets the source of random_get, it's crypto/rand.Reader by default
+func (b *Builder) WithRandom(r io.Reader) *Builder {
+	b.random = r
+	return b
+}
+
+// Build returns the host module. Every instance importing it gets its own state, such as
+// open file descriptors, while preopened file systems and listeners are shared by all of
+// them. The state is released once the instance is closed
+func (b *Builder) Build() (*api.HostModule, error) {
+	config := *b
+	config.args = append([]string(nil), b.args...)
+	config.environ = append([]string(nil), b.environ...)
+	config.preopens = append([]preopen(nil), b.preopens...)
+	config.listeners = append([]net.Listener(nil), b.listeners...)
+	// instances share streams of b, the locks of them included
+	streams := lockStreams(Stdio{Stdin: b.stdin, Stdout: b.stdout, Stderr: b.stderr})
+	config.stdin, config.stdout, config.stderr = streams.Stdin, streams.Stdout, streams.Stderr
+	key := &stateKey{config: &config}
+	for _, l := range config.listeners {
+		key.acceptors = append(key.acceptors, newAcceptor(l))
+	}
+
+	mb := api.NewHostModuleBuilder(ModuleName)
+	for _, fn := range functions {
+		var results []api.ValueType
+		impl := fn.impl
+		noResult := fn.name == "proc_exit"
+		if !noResult {
+			results = []api.ValueType{i32}
+		}
+		needsMemory := !memoryless[fn.name]
+		mb.NewFunctionBuilder().WithGoModuleFunction(func(ctx context.Context, mod api.Module, stack []uint64) {
+			mem := mod.Memory()
+			if mem == nil && needsMemory {
+				stack[0] = uint64(ErrnoFault)
+				return
+			}
+			errno := impl(key.system(mod), ctx, mem, stack)
+			if !noResult {
+				stack[0] = uint64(errno)
+			}
+		}, fn.params, results).Export(fn.name)
+	}
+	return mb.Build()
+}
+
+// stateKey identifies state of instances importing a host module built by Builder
+type stateKey struct {
+	config *Builder
+	// listeners of config shared by the instances
+	acceptors []*acceptor
+}
+
+// system returns state of the instance, which is created on the first call
+func (k *stateKey) system(mod api.Module) *system {
+	if s, ok := mod.HostData(k).(*system); ok {
+		return s
+	}
+	stdio, _ := mod.HostData(stdioKey{}).(Stdio)
+	s := newSystem(k.config, k.acceptors, stdio)
+	mod.SetHostData(k, s)
+	return s
+}
+
+// Instantiate builds the module and adds it to r
+func (b *Builder) Instantiate(r *api.Runtime) (*api.HostModule, error) {
+	hm, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.AddHostModule(hm); err != nil {
+		return nil, err
+	}
+	return hm, nil
+}
+
+const (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+// hostCall implements a WASI function for the instance whose state is s, arguments are taken
+// from stack and the result is an error code
+type hostCall func(s *system, ctx context.Context, mem api.Memory, stack []uint64) Errno
+
+type function struct {
+	name   string
+	params []api.ValueType
+	impl   hostCall
+}
+
+var functions = []function{
+	{"args_get", params(i32, i32), (*system).argsGet},
+	{"args_sizes_get", params(i32, i32), (*system).argsSizesGet},
+	{"environ_get", params(i32, i32), (*system).environGet},
+	{"environ_sizes_get", params(i32, i32), (*system).environSizesGet},
+	{"clock_res_get", params(i32, i32), (*system).clockResGet},
+	{"clock_time_get", params(i32, i64, i32), (*system).clockTimeGet},
+	{"fd_advise", params(i32, i64, i64, i32), (*system).fdAdvise},
+	{"fd_allocate", params(i32, i64, i64), (*system).fdAllocate},
+	{"fd_close", params(i32), (*system).fdClose},
+	{"fd_datasync", params(i32), (*system).fdSync},
+	{"fd_fdstat_get", params(i32, i32), (*system).fdFdstatGet},
+	{"fd_fdstat_set_flags", params(i32, i32), (*system).fdFdstatSetFlags},
+	{"fd_fdstat_set_rights", params(i32, i64, i64), (*system).fdFdstatSetRights},
+	{"fd_filestat_get", params(i32, i32), (*system).fdFilestatGet},
+	{"fd_filestat_set_size", params(i32, i64), (*system).fdFilestatSetSize},
+	{"fd_filestat_set_times", params(i32, i64, i64, i32), (*system).fdFilestatSetTimes},
+	{"fd_pread", params(i32, i32, i32, i64, i32), (*system).fdPread},
+	{"fd_prestat_get", params(i32, i32), (*system).fdPrestatGet},
+	{"fd_prestat_dir_name", params(i32, i32, i32), (*system).fdPrestatDirName},
+	{"fd_pwrite", params(i32, i32, i32, i64, i32), (*system).fdPwrite},
+	{"fd_read", params(i32, i32, i32, i32), (*system).fdRead},
+	{"fd_readdir", params(i32, i32, i32, i64, i32), (*system).fdReaddir},
+	{"fd_renumber", params(i32, i32), (*system).fdRenumber},
+	{"fd_seek", params(i32, i64, i32, i32), (*system).fdSeek},
+	{"fd_sync", params(i32), (*system).fdSync},
+	{"fd_tell", params(i32, i32), (*system).fdTell},
+	{"fd_write", params(i32, i32, i32, i32), (*system).fdWrite},
+	{"path_create_directory", params(i32, i32, i32), (*system).pathCreateDirectory},
+	{"path_filestat_get", params(i32, i32, i32, i32, i32), (*system).pathFilestatGet},
+	{"path_filestat_set_times", params(i32, i32, i32, i32, i64, i64, i32), (*system).pathFilestatSetTimes},
+	{"path_link", params(i32, i32, i32, i32, i32, i32, i32), (*system).pathLink},
+	{"path_open", params(i32, i32, i32, i32, i32, i64, i64, i32, i32), (*system).pathOpen},
+	{"path_readlink", params(i32, i32, i32, i32, i32, i32), (*system).pathReadlink},
+	{"path_remove_directory", params(i32, i32, i32), (*system).pathRemoveDirectory},
+	{"path_rename", params(i32, i32, i32, i32, i32, i32), (*system).pathRename},
+	{"path_symlink", params(i32, i32, i32, i32, i32), (*system).pathSymlink},
+	{"path_unlink_file", params(i32, i32, i32), (*system).pathUnlinkFile},
+	{"poll_oneoff", params(i32, i32, i32, i32), (*system).pollOneoff},
+	{"proc_exit", params(i32), (*system).procExit},
+	{"proc_raise", params(i32), (*system).procRaise},
+	{"sched_yield", nil, (*system).schedYield},
+	{"random_get", params(i32, i32), (*system).randomGet},
+	{"sock_accept", params(i32, i32, i32), (*system).sockAccept},
+	{"sock_recv", params(i32, i32, i32, i32, i32, i32), (*system).sockRecv},
+	{"sock_send", params(i32, i32, i32, i32, i32), (*system).sockSend},
+	{"sock_shutdown", params(i32, i32), (*system).sockShutdown},
+}
+
+// memoryless are functions that don't access memory of the guest, they work for modules
+// without one
+var memoryless = map[string]bool{
+	"fd_advise":             true,
+	"fd_allocate":           true,
+	"fd_close":              true,
+	"fd_datasync":           true,
+	"fd_fdstat_set_flags":   true,
+	"fd_fdstat_set_rights":  true,
+	"fd_filestat_set_size":  true,
+	"fd_filestat_set_times": true,
+	"fd_renumber":           true,
+	"fd_sync":               true,
+	"proc_exit":             true,
+	"proc_raise":            true,
+	"sched_yield":           true,
+	"sock_shutdown":         true,
+}
+
+func params(ts ...api.ValueType) []api.ValueType {
+	return ts
+}
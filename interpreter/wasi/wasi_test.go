@@ -0,0 +1,324 @@
+package wasi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/threadedstream/wasmexperiments/api"
+	. "github.com/threadedstream/wasmexperiments/internal/wasmtest"
+)
+
+// guest assembles a module exporting a function for each of WASI functions names, which
+// passes its arguments to the WASI function and returns its result. The module exports
+// one page of memory unless it's memoryless
+func guest(memoryless bool, names ...string) []byte {
+	m := &Module{}
+	if !memoryless {
+		m.Mems = [][]byte{Memory(1, -1)}
+		m.Exports = [][]byte{ExportMemory("memory", 0)}
+	}
+	for i, name := range names {
+		var fn function
+		for _, f := range functions {
+			if f.name == name {
+				fn = f
+			}
+		}
+		var params, code []byte
+		for j, p := range fn.params {
+			params = append(params, byte(p))
+			code = append(code, 0x20, byte(j))
+		}
+		results := B(I32)
+		if name == "proc_exit" {
+			results = nil
+		}
+		m.Types = append(m.Types, FuncType(params, results))
+		m.Imports = append(m.Imports, ImportFunc(ModuleName, name, uint32(i)))
+		m.Funcs = append(m.Funcs, Func{Type: uint32(i), Code: Cat(code, B(0x10), U(uint64(i)))})
+		m.Exports = append(m.Exports, ExportFunc(name, uint32(len(names)+i)))
+	}
+	return m.Bytes()
+}
+
+// instantiate instantiates bin importing the host module built by b
+func instantiate(t *testing.T, b *Builder, bin []byte, opts ...api.Option) *api.WasmApi {
+	t.Helper()
+	rt := api.NewRuntime()
+	if _, err := b.Instantiate(rt); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := rt.Instantiate("", bin, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inst.Close() })
+	return inst
+}
+
+// call calls forwarder of WASI function name
+func call(t *testing.T, inst *api.WasmApi, name string, args ...uint64) Errno {
+	t.Helper()
+	r, err := inst.CallRaw(name, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return Errno(r[0])
+}
+
+// write stores data in memory of the guest at offset
+func write(t *testing.T, inst *api.WasmApi, offset uint32, data []byte) {
+	t.Helper()
+	if !inst.Memory().Write(offset, data) {
+		t.Fatalf("can't write %d bytes at %d", len(data), offset)
+	}
+}
+
+// writeIovec stores an iovec of n bytes at buf at offset
+func writeIovec(t *testing.T, inst *api.WasmApi, offset, buf, n uint32) {
+	t.Helper()
+	mem := inst.Memory()
+	if !mem.WriteUint32Le(offset, buf) || !mem.WriteUint32Le(offset+4, n) {
+		t.Fatalf("can't write iovec at %d", offset)
+	}
+}
+
+func readUint32(t *testing.T, inst *api.WasmApi, offset uint32) uint32 {
+	t.Helper()
+	v, ok := inst.Memory().ReadUint32Le(offset)
+	if !ok {
+		t.Fatalf("can't read at %d", offset)
+	}
+	return v
+}
+
+func TestArgs(t *testing.T) {
+	b := NewBuilder().WithArgs("guest", "-v", "in.txt")
+	inst := instantiate(t, b, guest(false, "args_sizes_get", "args_get"))
+	if errno := call(t, inst, "args_sizes_get", 0, 4); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if argc, size := readUint32(t, inst, 0), readUint32(t, inst, 4); argc != 3 || size != 16 {
+		t.Fatalf("args_sizes_get = %d, %d", argc, size)
+	}
+	if errno := call(t, inst, "args_get", 16, 64); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	argv, _ := inst.Memory().ReadString(64, 16)
+	if argv != "guest\x00-v\x00in.txt\x00" || readUint32(t, inst, 20) != 64+6 {
+		t.Fatalf("args_get = %q", argv)
+	}
+}
+
+func TestFdWrite(t *testing.T) {
+	var out, errOut Buffer
+	inst := instantiate(t, NewBuilder(), guest(false, "fd_write"), WithStdio(Stdio{Stdout: &out, Stderr: &errOut}))
+	write(t, inst, 100, []byte("hello, world\n"))
+	writeIovec(t, inst, 0, 100, 7)
+	writeIovec(t, inst, 8, 107, 6)
+	if errno := call(t, inst, "fd_write", 1, 0, 2, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if n := readUint32(t, inst, 200); n != 13 || out.String() != "hello, world\n" || errOut.Len() != 0 {
+		t.Fatalf("fd_write wrote %d bytes, stdout %q", n, out.String())
+	}
+	if errno := call(t, inst, "fd_write", 9, 0, 2, 200); errno != ErrnoBadf {
+		t.Fatalf("fd_write to unknown fd: %v", errno)
+	}
+}
+
+func TestIovecsBeyondMemory(t *testing.T) {
+	inst := instantiate(t, NewBuilder(), guest(false, "fd_write", "fd_read"))
+	// the count of iovecs mustn't make the host allocate before checking memory
+	for fd, name := range []string{"fd_read", "fd_write"} {
+		if errno := call(t, inst, name, uint64(fd), 0xff00, maxIovecs, 0); errno != ErrnoFault {
+			t.Errorf("%s of iovecs beyond memory: %v", name, errno)
+		}
+		if errno := call(t, inst, name, uint64(fd), 0, 0xffffffff, 0); errno != ErrnoInval {
+			t.Errorf("%s of 2^32-1 iovecs: %v", name, errno)
+		}
+	}
+	writeIovec(t, inst, 0, 0xfff0, 0x100)
+	if errno := call(t, inst, "fd_write", 1, 0, 1, 8); errno != ErrnoFault {
+		t.Errorf("fd_write of a buffer crossing the end of memory: %v", errno)
+	}
+}
+
+// countingWriter counts bytes written to it and the size of the largest write
+type countingWriter struct {
+	n, largest int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	if len(p) > w.largest {
+		w.largest = len(p)
+	}
+	return len(p), nil
+}
+
+const pageSize = 64 << 10
+
+func TestFdWriteAliasingMemory(t *testing.T) {
+	var out countingWriter
+	inst := instantiate(t, NewBuilder().WithStdout(&out), guest(false, "fd_write"))
+	// every iovec covers the whole memory, the iovecs themselves included
+	for i := uint32(0); i < maxIovecs; i++ {
+		writeIovec(t, inst, 8*i, 0, pageSize)
+	}
+	if errno := call(t, inst, "fd_write", 1, 0, maxIovecs, 0); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if out.n != maxIovecs*pageSize || out.largest != pageSize {
+		t.Fatalf("fd_write wrote %d bytes, %d at most at once", out.n, out.largest)
+	}
+	if n := readUint32(t, inst, 0); n != maxIovecs*pageSize {
+		t.Fatalf("fd_write returned %d", n)
+	}
+
+	// small buffers are still gathered into a single write
+	out = countingWriter{}
+	for i := uint32(0); i < 4; i++ {
+		writeIovec(t, inst, 8*i, 100, 10)
+	}
+	if errno := call(t, inst, "fd_write", 1, 0, 4, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if out.n != 40 || out.largest != 40 {
+		t.Fatalf("fd_write wrote %d bytes, %d at most at once", out.n, out.largest)
+	}
+}
+
+// unboundedMemory accepts writes at any offset, recording them
+type unboundedMemory struct {
+	api.Memory
+	offsets []uint32
+}
+
+func (m *unboundedMemory) Write(offset uint32, _ []byte) bool {
+	m.offsets = append(m.offsets, offset)
+	return true
+}
+
+func (m *unboundedMemory) WriteUint32Le(offset uint32, _ uint32) bool {
+	m.offsets = append(m.offsets, offset)
+	return true
+}
+
+func TestWriteStringsBeyondAddressSpace(t *testing.T) {
+	// offsets of further strings and pointers mustn't wrap around to the start of memory
+	for _, tc := range []struct{ ptrs, buf uint32 }{{0xfffffffc, 0x100}, {0x100, 0xfffffff8}} {
+		mem := &unboundedMemory{}
+		if errno := writeStrings(mem, []string{"guest", "-v"}, tc.ptrs, tc.buf); errno != ErrnoFault {
+			t.Errorf("writeStrings(%#x, %#x): %v", tc.ptrs, tc.buf, errno)
+		}
+		for _, offset := range mem.offsets {
+			if offset < 0x100 {
+				t.Errorf("writeStrings(%#x, %#x) wrapped around to %#x", tc.ptrs, tc.buf, offset)
+			}
+		}
+	}
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	fsys := ReadOnly(fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	b := NewBuilder().WithPreopen("/", fsys).WithMaxOpenFiles(6)
+	inst := instantiate(t, b, guest(false, "path_open", "fd_close"))
+	write(t, inst, 100, []byte("a.txt"))
+	open := func() Errno {
+		return call(t, inst, "path_open", 3, 0, 100, 5, 0, rightFdRead, 0, 0, 200)
+	}
+	// stdio and the preopened directory take 4 of 6 descriptors
+	for i := 0; i < 2; i++ {
+		if errno := open(); errno != ErrnoSuccess {
+			t.Fatal(errno)
+		}
+	}
+	if errno := open(); errno != ErrnoNfile {
+		t.Fatalf("path_open beyond the limit: %v", errno)
+	}
+	if errno := call(t, inst, "fd_close", 4); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if errno := open(); errno != ErrnoSuccess || readUint32(t, inst, 200) != 4 {
+		t.Fatalf("path_open after fd_close: %v", errno)
+	}
+}
+
+func TestProcExitWithoutMemory(t *testing.T) {
+	inst := instantiate(t, NewBuilder(), guest(true, "proc_exit", "sched_yield", "fd_write"))
+	if errno := call(t, inst, "sched_yield"); errno != ErrnoSuccess {
+		t.Fatalf("sched_yield: %v", errno)
+	}
+	if errno := call(t, inst, "fd_write", 1, 0, 1, 0); errno != ErrnoFault {
+		t.Fatalf("fd_write without memory: %v", errno)
+	}
+	_, err := inst.CallRaw("proc_exit", 3)
+	var exit ExitError
+	if !errors.As(err, &exit) || exit.Code != 3 {
+		t.Fatalf("proc_exit: %v", err)
+	}
+}
+
+// writeClockSubscription stores a subscription to the monotonic clock timing out after d
+func writeClockSubscription(t *testing.T, inst *api.WasmApi, offset uint32, userdata uint64, d time.Duration) {
+	t.Helper()
+	mem := inst.Memory()
+	if !mem.Write(offset, make([]byte, subscriptionSize)) || !mem.WriteUint64Le(offset, userdata) ||
+		!mem.WriteUint8(offset+8, eventClock) || !mem.WriteUint32Le(offset+16, clockMonotonic) ||
+		!mem.WriteUint64Le(offset+24, uint64(d)) {
+		t.Fatalf("can't write subscription at %d", offset)
+	}
+}
+
+// writeFdSubscription stores a subscription to readiness of fd for reading
+func writeFdSubscription(t *testing.T, inst *api.WasmApi, offset uint32, userdata uint64, fd uint32) {
+	t.Helper()
+	mem := inst.Memory()
+	if !mem.Write(offset, make([]byte, subscriptionSize)) || !mem.WriteUint64Le(offset, userdata) ||
+		!mem.WriteUint8(offset+8, eventFdRead) || !mem.WriteUint32Le(offset+16, fd) {
+		t.Fatalf("can't write subscription at %d", offset)
+	}
+}
+
+func TestPollOneoff(t *testing.T) {
+	inst := instantiate(t, NewBuilder(), guest(false, "poll_oneoff"))
+	writeClockSubscription(t, inst, 0, 42, 5*time.Millisecond)
+	writeClockSubscription(t, inst, subscriptionSize, 43, time.Hour)
+	start := time.Now()
+	if errno := call(t, inst, "poll_oneoff", 0, 1024, 2, 2048); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond || elapsed > time.Minute {
+		t.Fatalf("poll_oneoff returned after %v", elapsed)
+	}
+	userdata, _ := inst.Memory().ReadUint64Le(1024)
+	if n := readUint32(t, inst, 2048); n != 1 || userdata != 42 {
+		t.Fatalf("poll_oneoff = %d events, the first of %d", n, userdata)
+	}
+
+	if errno := call(t, inst, "poll_oneoff", 0, 1024, 0, 2048); errno != ErrnoInval {
+		t.Fatalf("poll_oneoff without subscriptions: %v", errno)
+	}
+	// the count of subscriptions mustn't make the host allocate before checking memory
+	if errno := call(t, inst, "poll_oneoff", 0, 1024, 0xffffffff, 2048); errno != ErrnoFault {
+		t.Fatalf("poll_oneoff of 2^32-1 subscriptions: %v", errno)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	b := NewBuilder().WithStdin(strings.NewReader("input"))
+	inst := instantiate(t, b, guest(false, "fd_read"))
+	writeIovec(t, inst, 0, 100, 3)
+	writeIovec(t, inst, 8, 103, 16)
+	if errno := call(t, inst, "fd_read", 0, 0, 2, 200); errno != ErrnoSuccess {
+		t.Fatal(errno)
+	}
+	data, _ := inst.Memory().ReadString(100, readUint32(t, inst, 200))
+	if data != "input" {
+		t.Fatalf("fd_read = %q", data)
+	}
+}